@@ -33,11 +33,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 )
 
 const (
 	baseUrl    = "https://investigate.api.opendns.com"
-	maxTries   = 5
 	timeLayout = "2006/01/02/15"
 )
 
@@ -51,6 +51,9 @@ var urls map[string]string = map[string]string{
 	"security":       "/security/name/%s.json",
 	"tags":           "/domains/%s/latest_tags",
 	"latest_domains": "/ips/%s/latest_domains",
+	"whois":          "/whois/%s",
+	"whois_emails":   "/whois/emails/%s",
+	"whois_ns":       "/whois/nameservers/%s",
 }
 
 var supportedQueryTypes map[string]int = map[string]int{
@@ -66,6 +69,7 @@ type Investigate struct {
 	key     string
 	log     *log.Logger
 	verbose bool
+	retry   RetryPolicy
 }
 
 // Build a new Investigate client using an Investigate API key.
@@ -75,42 +79,81 @@ func New(key string) *Investigate {
 		key,
 		log.New(os.Stdout, `[Investigate] `, 0),
 		false,
+		DefaultRetryPolicy,
 	}
 }
 
 // A generic Request method which makes the given request.
-// Will retry up to 5 times on failure.
+// Network errors and 5xx responses are retried according to the client's
+// RetryPolicy (see SetRetryPolicy), provided the request can safely be sent
+// again. 4xx responses are never retried and are returned as a *StatusError.
+// If every attempt fails, the last error is wrapped in a *RetryError, unless
+// only one attempt was made.
 func (inv *Investigate) Request(req *http.Request) (*http.Response, error) {
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", inv.key))
-	resp := new(http.Response)
+	maxAttempts := inv.retry.attempts()
+	if !retryable(req) {
+		maxAttempts = 1
+	}
+
 	var err error
-	tries := 0
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := inv.retry.backoff(attempt - 1)
+			log.Printf("\nerror: %v\nTrying again in %v: Attempt %d/%d\n",
+				err, delay, attempt, maxAttempts)
+			time.Sleep(delay)
+
+			if rewindErr := rewind(req); rewindErr != nil {
+				return nil, fmt.Errorf("error: %v\ncould not retry: %v", err, rewindErr)
+			}
+		}
 
-	for ; resp.Body == nil && tries <= maxTries; tries++ {
 		inv.Logf("%s %s\n", req.Method, req.URL.String())
+		var resp *http.Response
 		resp, err = inv.client.Do(req)
-		if err != nil || (resp.StatusCode >= 400 && resp.StatusCode < 600) {
-			// if it's a 400 error code, just return an error.
-			// otherwise, if it's a server error, retry
-			if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-				errStr := fmt.Sprintf("error: %v", err)
-				inv.Log(errStr)
-				inv.LogHTTPResponseBody(resp.Body)
-				return nil, errors.New(errStr)
-			}
 
-			if tries == maxTries {
-				errStr := fmt.Sprintf("error: %v\nFailed all attempts. Skipping.", err)
-				log.Print(errStr)
-				return nil, errors.New(errStr)
-			}
+		// network error; the server may not have seen the request at all
+		if err != nil {
+			continue
+		}
+
+		if resp.StatusCode < 400 {
+			return resp, nil
+		}
+
+		err = &StatusError{resp.StatusCode, resp.Status}
 
-			log.Printf("\nerror: %v\nTrying again: Attempt %d/%d\n", err, tries+1, maxTries)
-			resp = new(http.Response)
+		// if it's a 4xx error code, just return an error.
+		// otherwise, if it's a server error, retry
+		if resp.StatusCode < 500 {
+			inv.Log(err.Error())
+			inv.LogHTTPResponseBody(resp.Body)
+			resp.Body.Close()
+			return nil, err
 		}
+
+		drain(resp.Body)
 	}
 
-	return resp, err
+	// a request which isn't retried fails with its own error
+	if maxAttempts == 1 {
+		return nil, err
+	}
+
+	errStr := fmt.Sprintf("error: %v\nFailed all %d attempts. Skipping.", err, maxAttempts)
+	log.Print(errStr)
+	return nil, &RetryError{maxAttempts, err}
+}
+
+// Sets the policy used to retry failed requests.
+func (inv *Investigate) SetRetryPolicy(policy RetryPolicy) {
+	inv.retry = policy
+}
+
+// Sets the HTTP client requests are made with, e.g. to go through a proxy.
+func (inv *Investigate) SetHTTPClient(client *http.Client) {
+	inv.client = client
 }
 
 // A generic GET call to the Investigate API.
@@ -318,6 +361,47 @@ func (inv *Investigate) LatestDomains(ip string) ([]string, error) {
 	return extractDomains(resp), nil
 }
 
+// Get the WHOIS record of the given domain.
+//
+// For details, see https://sgraph.opendns.com/docs/api#whois
+func (inv *Investigate) DomainWhois(domain string) (*DomainWhois, error) {
+	resp := new(DomainWhois)
+	err := inv.GetParse(fmt.Sprintf(urls["whois"], domain), resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Get the domains registered with the given email address.
+//
+// For details, see https://sgraph.opendns.com/docs/api#whois_emails
+func (inv *Investigate) EmailWhois(email string) (*WhoisDomainList, error) {
+	return inv.whoisDomains("whois_emails", email)
+}
+
+// Get the domains which use the given nameserver.
+//
+// For details, see https://sgraph.opendns.com/docs/api#whois_nameservers
+func (inv *Investigate) NameserverWhois(nameserver string) (*WhoisDomainList, error) {
+	return inv.whoisDomains("whois_ns", nameserver)
+}
+
+// The email and nameserver endpoints give back an object keyed by what was
+// looked up
+func (inv *Investigate) whoisDomains(queryType, item string) (*WhoisDomainList, error) {
+	resp := make(map[string]WhoisDomainList)
+	err := inv.GetParse(fmt.Sprintf(urls[queryType], url.PathEscape(item)), resp)
+	if err != nil {
+		return nil, err
+	}
+	if list, ok := resp[item]; !ok {
+		return nil, errors.New("received a malformed response body")
+	} else {
+		return &list, nil
+	}
+}
+
 // Converts the given list of items (domains or IPs)
 // to a list of their appropriate URIs for the Investigate API
 func convertToSubUris(items []string, queryType string) []string {
@@ -392,6 +476,10 @@ func (inv *Investigate) parseBody(respBody io.ReadCloser, v interface{}) (err er
 		err = json.Unmarshal(body, unpackedValue)
 	case *IPRRHistory:
 		err = json.Unmarshal(body, unpackedValue)
+	case *DomainWhois:
+		err = json.Unmarshal(body, unpackedValue)
+	case map[string]WhoisDomainList:
+		err = json.Unmarshal(body, &unpackedValue)
 	default:
 		err = errors.New("type of v is unsupported")
 	}
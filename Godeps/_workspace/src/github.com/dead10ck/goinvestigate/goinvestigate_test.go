@@ -195,6 +195,46 @@ func TestLatestDomains(t *testing.T) {
 	}
 }
 
+func TestDomainWhois(t *testing.T) {
+	t.Parallel()
+	out, err := inv.DomainWhois("google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.RegistrarName == "" {
+		t.Fatal("RegistrarName should not be empty")
+	}
+
+	if len(out.NameServers) <= 0 {
+		t.Fatal("NameServers should not be empty")
+	}
+}
+
+func TestEmailWhois(t *testing.T) {
+	t.Parallel()
+	out, err := inv.EmailWhois("dns-admin@google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.Domains) <= 0 {
+		t.Fatal("empty list")
+	}
+}
+
+func TestNameserverWhois(t *testing.T) {
+	t.Parallel()
+	out, err := inv.NameserverWhois("ns1.google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.Domains) <= 0 {
+		t.Fatal("empty list")
+	}
+}
+
 func TestErrorResponse(t *testing.T) {
 	t.Parallel()
 	badInv := New("bad_key")
@@ -261,3 +261,33 @@ type MaliciousDomain struct {
 	Domain string `json:"name"`
 	Id     int
 }
+
+type DomainWhois struct {
+	DomainName             string
+	RegistrantName         string
+	RegistrantOrganization string
+	RegistrantEmail        string
+	RegistrarName          string
+	RegistrarIANAID        string
+	Created                string
+	Updated                string
+	Expires                string
+	NameServers            []string
+	Emails                 []string
+	Status                 []string
+	WhoisServers           string
+	RecordExpired          bool
+	Timestamp              int64
+}
+
+type WhoisDomain struct {
+	Domain  string
+	Current bool
+}
+
+type WhoisDomainList struct {
+	TotalResults      int
+	MoreDataAvailable bool
+	Limit             int
+	Domains           []WhoisDomain
+}
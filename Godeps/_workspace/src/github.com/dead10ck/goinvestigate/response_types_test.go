@@ -573,6 +573,118 @@ func TestUnmarshalMaliciousDomain(t *testing.T) {
 	}
 }
 
+func TestUnmarshalDomainWhois(t *testing.T) {
+	t.Parallel()
+	data := []byte(
+		`{
+  "administrativeContactEmail": "dns-admin@google.com",
+  "created": "1997-09-15",
+  "domainName": "google.com",
+  "emails": [
+    "abusecomplaints@markmonitor.com",
+    "dns-admin@google.com"
+  ],
+  "expires": "2020-09-14",
+  "nameServers": [
+    "ns1.google.com",
+    "ns2.google.com"
+  ],
+  "recordExpired": false,
+  "registrantEmail": "dns-admin@google.com",
+  "registrantName": "Dns Admin",
+  "registrantOrganization": "Google Inc.",
+  "registrarIANAID": "292",
+  "registrarName": "MARKMONITOR INC.",
+  "status": [
+    "clientDeleteProhibited"
+  ],
+  "timestamp": 1434319123181,
+  "updated": "2015-06-12",
+  "whoisServers": "whois.markmonitor.com",
+  "zoneContactEmail": null
+}`,
+	)
+
+	var test DomainWhois
+	err := json.Unmarshal(data, &test)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if test.DomainName != "google.com" ||
+		test.RegistrantName != "Dns Admin" ||
+		test.RegistrantOrganization != "Google Inc." ||
+		test.RegistrantEmail != "dns-admin@google.com" ||
+		test.RegistrarName != "MARKMONITOR INC." ||
+		test.RegistrarIANAID != "292" ||
+		test.Created != "1997-09-15" ||
+		test.Updated != "2015-06-12" ||
+		test.Expires != "2020-09-14" ||
+		test.WhoisServers != "whois.markmonitor.com" ||
+		test.RecordExpired ||
+		test.Timestamp != 1434319123181 {
+		t.Fatalf("unexpected record %+v", test)
+	}
+
+	if !strSliceEq(test.NameServers, []string{"ns1.google.com", "ns2.google.com"}) ||
+		!strSliceEq(test.Emails, []string{"abusecomplaints@markmonitor.com", "dns-admin@google.com"}) ||
+		!strSliceEq(test.Status, []string{"clientDeleteProhibited"}) {
+		t.Fatalf("unexpected record %+v", test)
+	}
+}
+
+func TestUnmarshalWhoisDomainList(t *testing.T) {
+	t.Parallel()
+	data := []byte(
+		`{
+  "admin@example.com": {
+    "totalResults": 2,
+    "moreDataAvailable": false,
+    "limit": 500,
+    "domains": [
+      {
+        "domain": "example.com",
+        "current": true
+      },
+      {
+        "domain": "example.net",
+        "current": false
+      }
+    ]
+  }
+}`,
+	)
+
+	ref := WhoisDomainList{
+		TotalResults:      2,
+		MoreDataAvailable: false,
+		Limit:             500,
+		Domains: []WhoisDomain{
+			WhoisDomain{Domain: "example.com", Current: true},
+			WhoisDomain{Domain: "example.net", Current: false},
+		},
+	}
+
+	var test map[string]WhoisDomainList
+	err := json.Unmarshal(data, &test)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, ok := test["admin@example.com"]
+	if !ok || list.TotalResults != ref.TotalResults ||
+		list.MoreDataAvailable != ref.MoreDataAvailable ||
+		list.Limit != ref.Limit || len(list.Domains) != len(ref.Domains) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	for i := range ref.Domains {
+		if ref.Domains[i] != list.Domains[i] {
+			t.Fatalf("%v != %v", ref, test)
+		}
+	}
+}
+
 func locationSliceEq(a []Location, b []Location) bool {
 	if len(a) != len(b) {
 		return false
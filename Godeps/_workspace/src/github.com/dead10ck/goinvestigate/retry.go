@@ -0,0 +1,138 @@
+package goinvestigate
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how Request retries failed requests.
+//
+// Only network errors and 5xx responses are retried; 4xx responses are
+// returned to the caller immediately. Between attempts, Request sleeps for
+// a random duration between 0 and min(MaxDelay, BaseDelay * 2^(attempt-1))
+// ("full jitter" exponential backoff), so that many concurrent clients
+// hitting the same failure do not all retry in lockstep.
+type RetryPolicy struct {
+	// The total number of attempts, including the first one.
+	// Values less than 1 are treated as 1.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// The RetryPolicy used by clients built with New.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// StatusError is returned by Request when the API responds with a 4xx or
+// 5xx status code, so callers can tell it apart from a network error with a
+// type assertion. If the request was tried more than once, the error from
+// the last attempt is wrapped in a *RetryError instead.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response status: %s", e.Status)
+}
+
+// Temporary reports whether the error is a server error which may succeed
+// if the request is tried again.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 && e.StatusCode < 600
+}
+
+// RetryError is returned by Request when a request was tried more than once
+// and every attempt failed. Err is the error from the last attempt: either
+// a *StatusError for a 5xx response, or the network error returned by the
+// underlying http.Client.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+// backoff returns how long to wait before the given retry attempt.
+// attempt is 1 for the first retry.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < attempt && ceiling > 0; i++ {
+		if p.MaxDelay > 0 && ceiling >= p.MaxDelay {
+			break
+		}
+		ceiling *= 2
+	}
+	if p.MaxDelay > 0 && (ceiling > p.MaxDelay || ceiling < 0) {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// idempotent methods can always be safely sent more than once
+var idempotentMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"PUT":     true,
+	"DELETE":  true,
+}
+
+// retryable reports whether req may be sent again after a failure.
+// Requests with a body are retried only if the body can be rewound, since
+// re-sending a half-consumed body would corrupt the request. Requests
+// without a body are retried only if their method is idempotent.
+func retryable(req *http.Request) bool {
+	if hasBody(req) {
+		return req.GetBody != nil
+	}
+	return idempotentMethods[req.Method]
+}
+
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody
+}
+
+// rewind resets req's body so that it can be sent again.
+func rewind(req *http.Request) error {
+	if !hasBody(req) {
+		return nil
+	}
+	if req.GetBody == nil {
+		return fmt.Errorf("cannot rewind body of %s request", req.Method)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+// drain reads the rest of body and closes it, so the underlying
+// connection can be reused.
+func drain(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, body)
+	body.Close()
+}
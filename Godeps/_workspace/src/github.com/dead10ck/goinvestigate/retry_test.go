@@ -0,0 +1,195 @@
+package goinvestigate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    5 * time.Millisecond,
+}
+
+// returns a server which responds with each of the given status codes in
+// turn, and then 200 for every request after that
+func statusSequenceServer(codes []int, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		if int(n) <= len(codes) {
+			w.WriteHeader(codes[n-1])
+			return
+		}
+		w.Write(body)
+	}))
+}
+
+func newTestInv() *Investigate {
+	testInv := New("test_key")
+	testInv.SetRetryPolicy(fastRetryPolicy)
+	return testInv
+}
+
+func TestRequestRetriesServerErrors(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	ts := statusSequenceServer([]int{500, 503}, &hits)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := newTestInv().Request(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if hits != 3 {
+		t.Fatalf("server was hit %d times, but should have been hit 3 times", hits)
+	}
+}
+
+func TestRequestGivesUpAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	ts := statusSequenceServer([]int{500, 500, 500, 500, 500}, &hits)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	_, err := newTestInv().Request(req)
+	retryErr, ok := err.(*RetryError)
+	if !ok {
+		t.Fatalf("err = %#v, but should be a *RetryError", err)
+	}
+	if statusErr, ok := retryErr.Err.(*StatusError); !ok || statusErr.StatusCode != 500 {
+		t.Fatalf("retryErr.Err = %#v, but should be a 500 *StatusError", retryErr.Err)
+	}
+	if hits != int32(fastRetryPolicy.MaxAttempts) {
+		t.Fatalf("server was hit %d times, but should have been hit %d times",
+			hits, fastRetryPolicy.MaxAttempts)
+	}
+}
+
+func TestRequestDoesNotRetryClientErrors(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	ts := statusSequenceServer([]int{403}, &hits)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	_, err := newTestInv().Request(req)
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != 403 {
+		t.Fatalf("err = %#v, but should be a 403 *StatusError", err)
+	}
+	if hits != 1 {
+		t.Fatalf("server was hit %d times, but should have been hit once", hits)
+	}
+}
+
+func TestRequestRetriesNetworkErrors(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	req, _ := http.NewRequest("GET", url, nil)
+	_, err := newTestInv().Request(req)
+	retryErr, ok := err.(*RetryError)
+	if !ok {
+		t.Fatalf("err = %#v, but should be a *RetryError", err)
+	}
+	if _, ok := retryErr.Err.(*StatusError); ok {
+		t.Fatalf("retryErr.Err = %#v, but should be a network error", retryErr.Err)
+	}
+	if retryErr.Attempts != fastRetryPolicy.MaxAttempts {
+		t.Fatalf("made %d attempts, but should have made %d",
+			retryErr.Attempts, fastRetryPolicy.MaxAttempts)
+	}
+}
+
+func TestRequestRewindsPostBody(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	ts := statusSequenceServer([]int{502}, &hits)
+	defer ts.Close()
+
+	body := `["www.example.com"]`
+	req, _ := http.NewRequest("POST", ts.URL, bytes.NewReader([]byte(body)))
+	resp, err := newTestInv().Request(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	echoed, _ := ioutil.ReadAll(resp.Body)
+	if string(echoed) != body {
+		t.Fatalf("server received body %q on retry, but should have received %q",
+			echoed, body)
+	}
+	if hits != 2 {
+		t.Fatalf("server was hit %d times, but should have been hit twice", hits)
+	}
+}
+
+func TestRequestDoesNotRetryUnrewindableBody(t *testing.T) {
+	t.Parallel()
+	var hits int32
+	ts := statusSequenceServer([]int{500}, &hits)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL,
+		ioutil.NopCloser(strings.NewReader("body")))
+	_, err := newTestInv().Request(req)
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != 500 {
+		t.Fatalf("err = %#v, but should be a 500 *StatusError", err)
+	}
+	if hits != 1 {
+		t.Fatalf("server was hit %d times, but should have been hit once", hits)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	policy := RetryPolicy{
+		MaxAttempts: 10,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    50 * time.Millisecond,
+	}
+	ceilings := []time.Duration{10, 20, 40, 50, 50, 50}
+	for i, ceiling := range ceilings {
+		ceiling *= time.Millisecond
+		for j := 0; j < 100; j++ {
+			if d := policy.backoff(i + 1); d < 0 || d > ceiling {
+				t.Fatalf("backoff(%d) = %v, but should be in [0, %v]", i+1, d, ceiling)
+			}
+		}
+	}
+
+	if d := (RetryPolicy{BaseDelay: time.Second}).backoff(1000); d < 0 {
+		t.Fatalf("backoff should not overflow: %v", d)
+	}
+}
+
+func TestRequestSingleAttemptNetworkError(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.NotFoundHandler())
+	url := ts.URL
+	ts.Close()
+
+	testInv := New("test_key")
+	testInv.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	req, _ := http.NewRequest("GET", url, nil)
+	_, err := testInv.Request(req)
+	if err == nil {
+		t.Fatal("should return an error")
+	}
+	if _, ok := err.(*RetryError); ok {
+		t.Fatalf("err = %#v, but a single attempt shouldn't be a *RetryError", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	domainstats "github.com/dead10ck/domainstats/internal"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n"+
+		"  %[1]s [options] <domain list file>\n"+
+		"  %[1]s config validate [-strict] [-profile <profile>] [config file]\n"+
+		"  %[1]s config mapping [-profile <profile>] [config file]\n\n"+
+		"Options:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// Loads the config file, or the profile if one is given.
+func loadConfigOrProfile(path, profile string) (*domainstats.Config, []error, error) {
+	if profile != "" {
+		return domainstats.LoadProfile(profile, path)
+	}
+	return domainstats.LoadConfig(path)
+}
+
+// Checks the config file, or the profile if one is given, without resolving
+// the API key, for the subcommands which don't query anything.
+func checkConfigOrProfile(path, profile string) (*domainstats.Config, []error, error) {
+	if profile != "" {
+		return domainstats.CheckProfile(profile, path)
+	}
+	return domainstats.CheckConfig(path)
+}
+
+// Loads the config file or profile, logging any warnings. If strict is set,
+// warnings are returned as an error instead.
+func loadConfig(path, profile string, strict bool) (*domainstats.Config, error) {
+	config, warnings, err := loadConfigOrProfile(path, profile)
+	for _, w := range warnings {
+		log.Printf("warning: %s: %v", path, w)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strict && len(warnings) != 0 {
+		return nil, fmt.Errorf("config file %s has %d warning(s), and -strict is set",
+			path, len(warnings))
+	}
+	return config, nil
+}
+
+// Runs the `config` subcommand with the given arguments, and returns the
+// exit status.
+func configCommand(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	switch args[0] {
+	case "validate":
+		return configValidate(args[1:])
+	case "mapping":
+		return configMapping(args[1:])
+	default:
+		usage()
+		return 2
+	}
+}
+
+// Prints any problems with the config file, and what it will do.
+func configValidate(args []string) int {
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	strict := flags.Bool("strict", false, "Treat warnings, such as unknown keys, as errors.")
+	profile := flags.String("profile", opts.profile, "Validate the given profile instead.")
+	flags.Parse(args)
+
+	path := opts.configPath
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	config, warnings, err := checkConfigOrProfile(path, *profile)
+	for _, w := range warnings {
+		fmt.Printf("warning: %v\n", w)
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Header:\n  %s\n", strings.Join(config.DeriveHeader(), "\t"))
+	fmt.Println("Queries per domain:")
+	plan := config.QueryPlan()
+	if len(plan) == 0 {
+		fmt.Println("  (none)")
+	}
+	for _, q := range plan {
+		fmt.Printf("  %s\n", q)
+	}
+
+	if *strict && len(warnings) != 0 {
+		return 1
+	}
+	return 0
+}
+
+// Prints the OpenSearch/Elasticsearch index mapping for the documents
+// written with the config.
+func configMapping(args []string) int {
+	flags := flag.NewFlagSet("config mapping", flag.ExitOnError)
+	profile := flags.String("profile", opts.profile, "Use the given profile instead.")
+	flags.Parse(args)
+
+	path := opts.configPath
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+
+	config, warnings, err := checkConfigOrProfile(path, *profile)
+	for _, w := range warnings {
+		log.Printf("warning: %s: %v", path, w)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	mapping, err := json.MarshalIndent(map[string]interface{}{"mappings": config.IndexMapping()}, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(mapping))
+	return 0
+}
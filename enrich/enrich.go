@@ -0,0 +1,483 @@
+// Package enrich looks domains up in OpenDNS Investigate, the way the
+// domainstats command does, for use in other Go programs.
+//
+// An Enricher is made from a config, loaded with LoadConfig, and makes the
+// queries the config asks for. The config's skip lists, egress policy and
+// registered domain sharing all apply, and each result has both the typed
+// Investigate responses and the row domainstats would write for it:
+//
+//	config, _, err := enrich.LoadConfig("domainstats.toml")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	e, err := enrich.New(config, enrich.Options{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	res, err := e.Enrich(ctx, "example.com")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	fmt.Println(res.Categorization.Status)
+package enrich
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	domainstats "github.com/dead10ck/domainstats/internal"
+	"github.com/dead10ck/goinvestigate"
+)
+
+// The config types are the ones the domainstats command uses, so that
+// config files work the same in both.
+type (
+	Config     = domainstats.Config
+	QueryStats = domainstats.QueryStats
+	Row        = domainstats.Row
+	Query      = domainstats.DomainQueryType
+
+	// A domain's WHOIS record, with the other domains registered with its
+	// registrant's email if Whois.EmailDomains is set
+	WhoisResponse = domainstats.WhoisResponse
+)
+
+// Providers add sources of data besides Investigate; see RegisterProvider.
+type (
+	Provider         = domainstats.Provider
+	ProviderSource   = domainstats.ProviderSource
+	ProviderResponse = domainstats.ProviderResponse
+)
+
+// How many queries an Enricher makes at once, unless its options say
+// otherwise
+const DefaultConcurrency = 5
+
+// How many registered domains' results an Enricher keeps, unless its
+// options say otherwise
+const DefaultCacheSize = 10000
+
+// Reads and validates a config file. See the domainstats README for its
+// settings. Warnings, such as unknown keys, don't stop the config from
+// being used.
+func LoadConfig(path string) (config *Config, warnings []error, err error) {
+	return domainstats.LoadConfig(path)
+}
+
+// Loads a named profile or built-in preset, like the -profile flag.
+func LoadProfile(profile, path string) (config *Config, warnings []error, err error) {
+	return domainstats.LoadProfile(profile, path)
+}
+
+// Makes a provider's config table and columns available to config files,
+// for both this package and the domainstats command, if it's built with the
+// provider. It should be called from an init function, before any config
+// is loaded, and panics if the provider's name is taken.
+func RegisterProvider(p Provider) {
+	domainstats.RegisterProvider(p)
+}
+
+type Options struct {
+	// How many queries are made at once, across all domains; defaults to
+	// DefaultConcurrency. Stream also enriches this many domains at once.
+	Concurrency int
+
+	// With QueryRegisteredDomain, how long the results for a registered
+	// domain are shared between the domains under it before they're
+	// queried again. If zero, they don't expire, but only CacheSize of
+	// them are kept. Failed queries aren't shared.
+	CacheTTL time.Duration
+
+	// With QueryRegisteredDomain, the most registered domains whose results
+	// are kept at once; defaults to DefaultCacheSize.
+	CacheSize int
+
+	// Log each request and retry.
+	Verbose bool
+
+	// The client Investigate requests are made with, e.g. to go through a
+	// proxy. If nil, a new one is used.
+	HTTPClient *http.Client
+
+	// Called after each query is made, e.g. to count them. Queries which
+	// the egress policy blocked aren't made, so they aren't passed to it.
+	// It may be called from several goroutines at once.
+	OnQuery func(q Query)
+}
+
+// A domain to enrich, along with what the input says about it
+type Input struct {
+	Domain string
+
+	// The domain's line number in the input, for the Line column
+	Line int
+
+	// How often the domain was queried, for the QueryLog columns, if it
+	// came from a resolver log
+	Queries *QueryStats
+}
+
+// What Investigate says about a domain. Only the responses for the queries
+// the config asks for are set.
+type Result struct {
+	Input
+
+	// The row for the domain, one field per enabled column, in the order
+	// of the config's tables; Config.ProjectRow puts them in the order
+	// given by Columns. It is nil if Err is set.
+	Fields []string
+
+	Categorization *goinvestigate.DomainCategorization
+	Cooccurrences  []goinvestigate.Cooccurrence
+	Related        []goinvestigate.RelatedDomain
+	Security       *goinvestigate.SecurityFeatures
+	Tags           []goinvestigate.DomainTag
+	RRHistory      *goinvestigate.DomainRRHistory
+	Whois          *WhoisResponse
+
+	// The responses of registered providers, by name
+	Providers map[string]*ProviderResponse
+
+	// Why the domain wasn't queried, if it was on a skip list or blocked
+	// by the egress policy
+	Skipped string
+
+	// Why the queries failed, if they did
+	Err error
+
+	// the responses, in the order the queries were made
+	responses []interface{}
+}
+
+// Returns the result as a row for an output sink.
+func (r Result) Row() Row {
+	return Row{Line: r.Line, Domain: r.Domain, Fields: r.Fields, Responses: r.responses,
+		Queries: r.Queries, Skipped: r.Skipped, Err: r.Err}
+}
+
+// Makes the queries a config asks for about domains. It is safe for
+// concurrent use.
+type Enricher struct {
+	config *Config
+	opts   Options
+	inv    *goinvestigate.Investigate
+	skips  *domainstats.SkipList
+
+	// shared by the queries of every domain, to limit how many are made
+	// at once
+	slots chan struct{}
+
+	// nil unless the config asks to query registered domains
+	cache *resultCache
+}
+
+// Returns an Enricher for the config, after reading its skip lists.
+func New(config *Config, opts Options) (*Enricher, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = DefaultCacheSize
+	}
+	skips, err := config.NewSkipList()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Enricher{
+		config: config,
+		opts:   opts,
+		inv:    goinvestigate.New(config.APIKey),
+		skips:  skips,
+		slots:  make(chan struct{}, opts.Concurrency),
+	}
+	e.inv.SetVerbose(opts.Verbose)
+	if opts.HTTPClient != nil {
+		e.inv.SetHTTPClient(opts.HTTPClient)
+	}
+	if config.QueryRegisteredDomain {
+		e.cache = newResultCache(opts.CacheTTL, opts.CacheSize)
+	}
+	return e, nil
+}
+
+// Enriches a single domain. The error is the result's Err.
+func (e *Enricher) Enrich(ctx context.Context, domain string) (Result, error) {
+	res := e.enrich(ctx, Input{Domain: domain})
+	return res, res.Err
+}
+
+// Enriches each of the domains, and returns their results in the same
+// order. Domains whose queries failed have Err set.
+func (e *Enricher) EnrichAll(ctx context.Context, domains []string) []Result {
+	in := make(chan Input)
+	go func() {
+		for i, domain := range domains {
+			in <- Input{Domain: domain, Line: i + 1}
+		}
+		close(in)
+	}()
+
+	results := make([]Result, len(domains))
+	for res := range e.Stream(ctx, in) {
+		results[res.Line-1] = res
+	}
+	return results
+}
+
+// Enriches the domains received on in, several at once, and sends their
+// results as they're ready, so not necessarily in order. The returned
+// channel is closed once in is closed and every domain's result has been
+// sent. Once ctx is done, the domains left get its error as their Err.
+func (e *Enricher) Stream(ctx context.Context, in <-chan Input) <-chan Result {
+	out := make(chan Result, e.opts.Concurrency)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < e.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			for input := range in {
+				out <- e.enrich(ctx, input)
+			}
+			wg.Done()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func (e *Enricher) enrich(ctx context.Context, in Input) Result {
+	c := e.config
+	domain := in.Domain
+
+	// the fields which don't come from Investigate
+	row := []string{domain}
+	if c.Line {
+		row = append(row, strconv.Itoa(in.Line))
+	}
+	row = append(row, c.ExtractSuffixSubRow(domain)...)
+	row = append(row, c.ExtractQueryStatsSubRow(in.Queries)...)
+
+	// names the egress policy blocks are never queried, and neither are
+	// ones on the skip lists
+	reason := e.skips.Reason(domain)
+	if rule := c.EgressPolicy().Blocks(domain); rule != "" {
+		reason = domainstats.BlockedReasonPrefix + rule
+	}
+	if reason != "" {
+		if c.Skipped {
+			row = append(row, reason)
+		}
+		return Result{Input: in, Fields: c.PadRow(row), Skipped: reason}
+	}
+	if c.Skipped {
+		row = append(row, "")
+	}
+
+	var qr queryResult
+	if e.cache != nil {
+		queried := domain
+		if registered := domainstats.RegisteredDomain(domain); registered != "" {
+			queried = registered
+		}
+		qr = e.cache.get(queried, func() queryResult {
+			return e.query(ctx, queried)
+		})
+	} else {
+		qr = e.query(ctx, domain)
+	}
+	if qr.err != nil {
+		return Result{Input: in, Err: qr.err}
+	}
+
+	res := Result{Input: in, Fields: append(row, qr.fields...), responses: qr.responses}
+	for _, resp := range qr.responses {
+		switch resp := resp.(type) {
+		case *goinvestigate.DomainCategorization:
+			res.Categorization = resp
+		case []goinvestigate.Cooccurrence:
+			res.Cooccurrences = resp
+		case []goinvestigate.RelatedDomain:
+			res.Related = resp
+		case *goinvestigate.SecurityFeatures:
+			res.Security = resp
+		case []goinvestigate.DomainTag:
+			res.Tags = resp
+		case *goinvestigate.DomainRRHistory:
+			res.RRHistory = resp
+		case *WhoisResponse:
+			res.Whois = resp
+		case *ProviderResponse:
+			if res.Providers == nil {
+				res.Providers = make(map[string]*ProviderResponse)
+			}
+			res.Providers[resp.Provider] = resp
+		}
+	}
+	return res
+}
+
+// The results of the Investigate queries for a domain
+type queryResult struct {
+	fields    []string
+	responses []interface{}
+	err       error
+}
+
+// Makes the queries the config asks for about the domain, and returns the
+// fields and responses for its row.
+func (e *Enricher) query(ctx context.Context, domain string) queryResult {
+	queries := e.config.DeriveMessages(e.inv, domain)
+	for _, q := range queries {
+		go e.send(ctx, q)
+	}
+
+	res := queryResult{responses: []interface{}{}}
+
+	// receive once for each query that was sent
+	for _, q := range queries {
+		qmResp := <-q.RespChan
+		if qmResp.Err != nil {
+			res.err = qmResp.Err
+			return res
+		}
+		subRow, err := e.config.ExtractCSVSubRow(qmResp.Resp)
+		if err != nil {
+			e.inv.Logf("error extracting CSV sub row: %v", err)
+			continue
+		}
+		res.fields = append(res.fields, subRow...)
+		res.responses = append(res.responses, qmResp.Resp)
+	}
+	return res
+}
+
+// Makes a query once there's a free slot, and sends its response on its
+// channel, which is buffered so that this never blocks.
+func (e *Enricher) send(ctx context.Context, m *domainstats.DomainQueryMessage) {
+	if ctx.Err() != nil {
+		m.RespChan <- domainstats.DomainQueryResponse{Err: ctx.Err()}
+		return
+	}
+	select {
+	case e.slots <- struct{}{}:
+	case <-ctx.Done():
+		m.RespChan <- domainstats.DomainQueryResponse{Err: ctx.Err()}
+		return
+	}
+	resp := m.Q.Query()
+	<-e.slots
+
+	// queries the egress policy blocked were never sent
+	if _, blocked := resp.Err.(*domainstats.EgressError); !blocked && e.opts.OnQuery != nil {
+		e.opts.OnQuery(m.Q)
+	}
+	m.RespChan <- resp
+}
+
+// Shares the results of the queries for registered domains between the
+// domains under them. If ttl is positive, results are only used for that
+// long, and then the queries are made again. Failed results aren't kept,
+// and once there are more than size results, the least recently used are
+// dropped.
+type resultCache struct {
+	sync.Mutex
+	ttl  time.Duration
+	size int
+
+	// the entries, by key and from most to least recently used
+	entries map[string]*list.Element
+	order   *list.List
+
+	lastSweep time.Time
+}
+
+type cacheEntry struct {
+	key string
+
+	// closed once result is set
+	ready  chan struct{}
+	result queryResult
+	added  time.Time
+}
+
+func newResultCache(ttl time.Duration, size int) *resultCache {
+	return &resultCache{
+		ttl:       ttl,
+		size:      size,
+		entries:   make(map[string]*list.Element),
+		order:     list.New(),
+		lastSweep: time.Now(),
+	}
+}
+
+// Returns the results for the key, calling query for them if they aren't
+// cached yet. If another goroutine is already querying for them, this
+// waits for its results.
+func (c *resultCache) get(key string, query func() queryResult) queryResult {
+	c.Lock()
+	now := time.Now()
+	c.sweep(now)
+	el, ok := c.entries[key]
+	if ok && !c.expired(el.Value.(*cacheEntry), now) {
+		c.order.MoveToFront(el)
+		c.Unlock()
+
+		e := el.Value.(*cacheEntry)
+		<-e.ready
+		return e.result
+	}
+	if ok {
+		c.remove(el)
+	}
+	e := &cacheEntry{key: key, ready: make(chan struct{}), added: now}
+	c.entries[key] = c.order.PushFront(e)
+	for c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	c.Unlock()
+
+	e.result = query()
+	if e.result.err != nil {
+		// so that the next domain under it queries it again, rather than
+		// getting an error which may well have been temporary
+		c.Lock()
+		if el, ok := c.entries[key]; ok && el.Value == e {
+			c.remove(el)
+		}
+		c.Unlock()
+	}
+	close(e.ready)
+	return e.result
+}
+
+func (c *resultCache) expired(e *cacheEntry, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(e.added) >= c.ttl
+}
+
+func (c *resultCache) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*cacheEntry).key)
+	c.order.Remove(el)
+}
+
+// Drops the expired entries, at most once per ttl, so that the cache
+// doesn't hold on to results which will never be used again.
+func (c *resultCache) sweep(now time.Time) {
+	if c.ttl <= 0 || now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if c.expired(el.Value.(*cacheEntry), now) {
+			c.remove(el)
+		}
+		el = next
+	}
+	c.lastSweep = now
+}
@@ -0,0 +1,164 @@
+package enrich
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	domainstats "github.com/dead10ck/domainstats/internal"
+)
+
+func strSliceEq(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEnrichAll(t *testing.T) {
+	t.Parallel()
+	// no Investigate fields are enabled, so nothing is sent
+	config := &Config{APIKey: "test-key", Line: true, Skipped: true, TLD: true}
+	e, err := New(config, Options{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := e.EnrichAll(context.Background(), []string{"a.example.com", "intranet", "b.co.uk"})
+	ref := [][]string{
+		{"a.example.com", "1", "com", ""},
+		{"intranet", "2", "intranet", domainstats.BlockedReasonPrefix + "single-label name"},
+		{"b.co.uk", "3", "co.uk", ""},
+	}
+	for i, res := range results {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if !strSliceEq(ref[i], res.Fields) {
+			t.Fatalf("%v != %v", ref[i], res.Fields)
+		}
+	}
+	if results[1].Skipped == "" || results[1].Row().Skipped != results[1].Skipped {
+		t.Fatalf("unexpected result %+v", results[1])
+	}
+}
+
+// Answers requests itself with a canned body, and records their paths
+type cannedTransport struct {
+	body  string
+	paths chan string
+}
+
+func (ct cannedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ct.paths <- req.URL.Path
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Request: req,
+		Body: ioutil.NopCloser(strings.NewReader(ct.body))}, nil
+}
+
+func TestEnrichHTTPClient(t *testing.T) {
+	t.Parallel()
+	transport := cannedTransport{`{"found": true, "pfs2": [["b.com", 0.25], ["c.com", 0.5]]}`,
+		make(chan string, 1)}
+	config := &Config{APIKey: "test-key", Cooccurrences: domainstats.DomainScoreConfig{Domain: true}}
+	e, err := New(config, Options{HTTPClient: &http.Client{Transport: transport}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Enrich(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// requests go through the given client, and the response is typed
+	if path := <-transport.paths; path != "/recommendations/name/example.com.json" {
+		t.Fatalf("unexpected request for %s", path)
+	}
+	if len(res.Cooccurrences) != 2 || res.Cooccurrences[1].Score != 0.5 {
+		t.Fatalf("unexpected cooccurrences %v", res.Cooccurrences)
+	}
+}
+
+func TestEnrichCanceled(t *testing.T) {
+	t.Parallel()
+	queried := int32(0)
+	config := &Config{APIKey: "test-key", Status: true}
+	e, err := New(config, Options{OnQuery: func(Query) { atomic.AddInt32(&queried, 1) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := e.Enrich(ctx, "example.com")
+	if err != context.Canceled || res.Err != err || res.Fields != nil {
+		t.Fatalf("unexpected result %+v, %v", res, err)
+	}
+	if n := atomic.LoadInt32(&queried); n != 0 {
+		t.Fatalf("%v != %v", 0, n)
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	t.Parallel()
+	calls := 0
+	query := func() queryResult {
+		calls++
+		return queryResult{fields: []string{"1"}}
+	}
+
+	cache := newResultCache(0, 10)
+	cache.get("example.com", query)
+	if res := cache.get("example.com", query); calls != 1 || !strSliceEq(res.fields, []string{"1"}) {
+		t.Fatalf("unexpected result %+v after %d calls", res, calls)
+	}
+
+	// expired results are queried again, and swept out
+	cache = newResultCache(time.Millisecond, 10)
+	cache.get("example.com", query)
+	time.Sleep(2 * time.Millisecond)
+	cache.get("example.com", query)
+	if calls != 3 {
+		t.Fatalf("%v != %v", 3, calls)
+	}
+	time.Sleep(2 * time.Millisecond)
+	cache.get("example.net", query)
+	if _, ok := cache.entries["example.com"]; ok || len(cache.entries) != 1 {
+		t.Fatalf("expired results should be dropped, but %d are kept", len(cache.entries))
+	}
+
+	// failed results aren't kept
+	cache = newResultCache(0, 10)
+	failed := func() queryResult {
+		calls++
+		return queryResult{err: context.DeadlineExceeded}
+	}
+	calls = 0
+	cache.get("example.com", failed)
+	if res := cache.get("example.com", query); calls != 2 || res.err != nil {
+		t.Fatalf("unexpected result %+v after %d calls", res, calls)
+	}
+
+	// the least recently used results are dropped to make room
+	cache = newResultCache(0, 2)
+	calls = 0
+	cache.get("a.com", query)
+	cache.get("b.com", query)
+	cache.get("a.com", query)
+	cache.get("c.com", query)
+	if _, ok := cache.entries["b.com"]; ok || len(cache.entries) != 2 {
+		t.Fatalf("b.com should have been dropped, but %d results are kept", len(cache.entries))
+	}
+	cache.get("a.com", query)
+	if calls != 3 {
+		t.Fatalf("%v != %v", 3, calls)
+	}
+}
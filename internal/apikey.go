@@ -0,0 +1,151 @@
+package domainstats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The environment variable which, if set, overrides the API key given by
+// any config file.
+const APIKeyEnv = "DOMAINSTATS_API_KEY"
+
+// The file GenerateDefaultConfig stores the API key in, relative to the
+// config directory
+const defaultAPIKeyFile = "api_key"
+
+// Sets c.APIKey from the first of these sources that is set:
+//
+//  1. the DOMAINSTATS_API_KEY environment variable
+//  2. the file named by APIKeyFile
+//  3. the output of APIKeyCommand
+//  4. APIKey
+//
+// Relative APIKeyFile paths are relative to configDir. Problems which don't
+// stop the key from being used, such as a key file that other users can
+// read, are returned as warnings.
+func (c *Config) resolveAPIKey(configDir string) (warnings []error, err error) {
+	envKey := strings.TrimSpace(os.Getenv(APIKeyEnv))
+	warnings = c.apiKeySourceWarnings(envKey != "")
+
+	if envKey != "" {
+		c.APIKey = envKey
+		return warnings, nil
+	}
+
+	switch {
+	case c.APIKeyFile != "":
+		path := expandPath(c.APIKeyFile, configDir)
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+			warnings = append(warnings, fmt.Errorf("API key file %s is accessible "+
+				"by other users (mode %v); it should be 0600", path, info.Mode().Perm()))
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return warnings, fmt.Errorf("error reading APIKeyFile: %v", err)
+		}
+		c.APIKey = firstLine(contents)
+		if c.APIKey == "" {
+			return warnings, fmt.Errorf("APIKeyFile %s is empty", path)
+		}
+
+	case c.APIKeyCommand != "":
+		cmd := exec.Command("sh", "-c", c.APIKeyCommand)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return warnings, fmt.Errorf("error running APIKeyCommand %q: %v", c.APIKeyCommand, err)
+		}
+		c.APIKey = firstLine(out)
+		if c.APIKey == "" {
+			return warnings, fmt.Errorf("APIKeyCommand %q printed nothing", c.APIKeyCommand)
+		}
+
+	case c.APIKey == "":
+		return warnings, fmt.Errorf("no API key given; set one of APIKeyFile, "+
+			"APIKeyCommand or APIKey, or the %s environment variable", APIKeyEnv)
+	}
+
+	return warnings, nil
+}
+
+// Returns a warning if the config file sets more than one API key source.
+// envSet says whether the environment variable overrides them.
+func (c *Config) apiKeySourceWarnings(envSet bool) []error {
+	sources := []string{}
+	if c.APIKeyFile != "" {
+		sources = append(sources, "APIKeyFile")
+	}
+	if c.APIKeyCommand != "" {
+		sources = append(sources, "APIKeyCommand")
+	}
+	if c.APIKey != "" {
+		sources = append(sources, "APIKey")
+	}
+	if len(sources) < 2 {
+		return nil
+	}
+
+	used := sources[0]
+	if envSet {
+		used = APIKeyEnv
+	}
+	return []error{fmt.Errorf("more than one API key source is set (%s); "+
+		"using %s", strings.Join(sources, ", "), used)}
+}
+
+// Checks the API key settings without reading the key. A config with no
+// key source is only warned about, since the key may be given by the
+// environment when the config is used.
+func (c *Config) checkAPIKeySources() []error {
+	envSet := strings.TrimSpace(os.Getenv(APIKeyEnv)) != ""
+	warnings := c.apiKeySourceWarnings(envSet)
+	if !envSet && c.APIKeyFile == "" && c.APIKeyCommand == "" && c.APIKey == "" {
+		warnings = append(warnings, fmt.Errorf("no API key is given; set one of "+
+			"APIKeyFile, APIKeyCommand or APIKey, or the %s environment variable", APIKeyEnv))
+	}
+	return warnings
+}
+
+// Writes the API key to the given file, readable only by the current user.
+func writeAPIKeyFile(path, apiKey string) error {
+	keyFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer keyFile.Close()
+
+	// the file may have already existed with looser permissions
+	if err := keyFile.Chmod(0600); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(keyFile, apiKey)
+	return err
+}
+
+// Returns the first line of b, without surrounding whitespace
+func firstLine(b []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// Expands a leading ~/ to the user's home directory, and makes relative
+// paths relative to dir.
+func expandPath(path, dir string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(dir, path)
+	}
+	return path
+}
@@ -0,0 +1,103 @@
+package domainstats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveAPIKey(t *testing.T) {
+	t.Setenv(APIKeyEnv, "")
+	dir, err := ioutil.TempDir("", "domainstats-apikey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := writeAPIKeyFile(filepath.Join(dir, "key"), "file-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(c Config, ref string, numWarnings int) {
+		warnings, err := c.resolveAPIKey(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.APIKey != ref {
+			t.Fatalf("APIKey = %q, but should = %q", c.APIKey, ref)
+		}
+		if len(warnings) != numWarnings {
+			t.Fatalf("warnings = %v, but there should be %d", warnings, numWarnings)
+		}
+	}
+
+	verify(Config{APIKey: "plain-key"}, "plain-key", 0)
+	verify(Config{APIKeyFile: "key"}, "file-key", 0)
+	verify(Config{APIKeyFile: filepath.Join(dir, "key")}, "file-key", 0)
+	verify(Config{APIKeyCommand: "echo command-key; echo second line"}, "command-key", 0)
+
+	// the key file takes precedence, but setting more than one is suspicious
+	verify(Config{APIKey: "plain-key", APIKeyFile: "key"}, "file-key", 1)
+	verify(Config{APIKeyFile: "key", APIKeyCommand: "echo command-key"}, "file-key", 1)
+	verify(Config{APIKey: "plain-key", APIKeyCommand: "echo command-key"}, "command-key", 1)
+
+	// the environment overrides everything
+	t.Setenv(APIKeyEnv, "env-key")
+	verify(Config{APIKeyFile: "key"}, "env-key", 0)
+	verify(Config{}, "env-key", 0)
+
+	c := Config{APIKey: "plain-key", APIKeyFile: "key"}
+	warnings, err := c.resolveAPIKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.HasSuffix(warnings[0].Error(), "using "+APIKeyEnv) {
+		t.Fatalf("the warning should name the source which is used: %v", warnings)
+	}
+}
+
+func TestResolveAPIKeyErrors(t *testing.T) {
+	t.Setenv(APIKeyEnv, "")
+	dir, err := ioutil.TempDir("", "domainstats-apikey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "empty"), nil, 0600)
+
+	for _, c := range []Config{
+		{},
+		{APIKeyFile: "missing"},
+		{APIKeyFile: "empty"},
+		{APIKeyCommand: "exit 1"},
+		{APIKeyCommand: "true"},
+	} {
+		if _, err := c.resolveAPIKey(dir); err == nil {
+			t.Fatalf("resolveAPIKey should return an error for %+v", c)
+		}
+	}
+}
+
+func TestResolveAPIKeyFilePermissions(t *testing.T) {
+	t.Setenv(APIKeyEnv, "")
+	dir, err := ioutil.TempDir("", "domainstats-apikey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "key")
+	ioutil.WriteFile(keyPath, []byte("file-key\n"), 0644)
+	os.Chmod(keyPath, 0644)
+
+	c := Config{APIKeyFile: keyPath}
+	warnings, err := c.resolveAPIKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("a world-readable key file should give a warning: %v", warnings)
+	}
+}
@@ -0,0 +1,223 @@
+package domainstats
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A single column of the output file. Path is the column's location in the
+// config, e.g. "Security.ThreatType", and is what goes in the Columns list.
+// Header is the name written to the header row.
+type column struct {
+	Path   string
+	Header string
+}
+
+// A column picked by the Columns list. Index is the position of the field
+// in a row built from the config's enabled fields.
+type selectedColumn struct {
+	Index  int
+	Header string
+}
+
+// the separator between a column and its alias in the Columns list
+const aliasSep = " as "
+
+// Returns the columns enabled in the config, in the order the fields of a
+// row are built: the same order the queries are constructed.
+func (c *Config) columns() (cols []column) {
+	appendColumn := func(path, header string, cond bool) {
+		if cond {
+			cols = append(cols, column{path, header})
+		}
+	}
+
+	appendColumns := func(prefix string, structField interface{}) {
+		rVal := reflect.ValueOf(structField)
+		for i := 0; i < rVal.NumField(); i++ {
+			fieldName := rVal.Type().Field(i).Name
+			if fieldName != "Labels" && rVal.Field(i).Kind() == reflect.Bool {
+				appendColumn(prefix+"."+fieldName, fieldName, rVal.Field(i).Bool())
+			}
+		}
+	}
+
+	appendColumn("Domain", "Domain", true)
+	appendColumn("Line", "Line", c.Line)
+	appendColumn("RegisteredDomain", "RegisteredDomain", c.RegisteredDomain)
+	appendColumn("TLD", "TLD", c.TLD)
+	appendColumns("QueryLog", c.QueryLog)
+	appendColumn("Skipped", "Skipped", c.Skipped)
+	appendColumn("Status", "Status", c.Status)
+	appendColumns("Categories", c.Categories)
+	appendColumn("Cooccurrences", "Cooccurrences", any(c.Cooccurrences))
+	appendColumn("Related", "RelatedDomains", any(c.Related))
+	appendColumns("Security", c.Security)
+	appendColumn("TaggingDates", "TaggingDates", any(c.TaggingDates))
+	appendColumn("DomainRRHistory.Periods", "RR Periods", any(c.DomainRRHistory.Periods))
+	appendColumns("DomainRRHistory.Features", c.DomainRRHistory.Features)
+	appendColumns("Whois", c.Whois)
+
+	for _, p := range registeredProviders() {
+		enabled := make(map[string]bool)
+		for _, name := range c.providerColumns(p) {
+			enabled[name] = true
+		}
+		for _, name := range p.Columns() {
+			appendColumn(p.Name()+"."+name, name, enabled[name])
+		}
+	}
+
+	return cols
+}
+
+// Returns every column which can go in the Columns list
+func allColumns() []column {
+	config := defaultConfig()
+	config.Line = true
+	config.Whois.EmailDomains = true
+	for _, p := range registeredProviders() {
+		settings := config.providerSettings(p)
+		for _, name := range p.Columns() {
+			settings.FieldByName(name).SetBool(true)
+		}
+	}
+	return config.columns()
+}
+
+// Returns the paths of every column which can go in the Columns list.
+func KnownColumns() []string {
+	paths := []string{}
+	for _, col := range allColumns() {
+		paths = append(paths, col.Path)
+	}
+	return paths
+}
+
+// Looks up a Columns entry, which may be either a column's path or its
+// default header name, e.g. "Security.ThreatType" or "ThreatType".
+func lookupColumn(name string) (column, bool) {
+	for _, col := range allColumns() {
+		if name == col.Path || name == col.Header {
+			return col, true
+		}
+	}
+	return column{}, false
+}
+
+// Returns the config field at the given dot-separated path.
+func (c *Config) fieldByPath(path string) reflect.Value {
+	names := strings.Split(path, ".")
+	v := reflect.ValueOf(c).Elem()
+	if p, ok := lookupProvider(names[0]); ok {
+		v, names = c.providerSettings(p), names[1:]
+	}
+	for _, name := range names {
+		v = v.FieldByName(name)
+	}
+	return v
+}
+
+// Sets a column's field in the config. Columns which are a whole table,
+// like Cooccurrences, have every field in the table set when enabled,
+// unless some of them already are; when disabled, all of them are cleared.
+func (c *Config) setColumn(path string, enabled bool) {
+	field := c.fieldByPath(path)
+	if field.Kind() == reflect.Bool {
+		field.SetBool(enabled)
+		return
+	}
+
+	if enabled && any(field.Interface()) {
+		return
+	}
+	for i := 0; i < field.NumField(); i++ {
+		if field.Field(i).Kind() == reflect.Bool {
+			field.Field(i).SetBool(enabled)
+		}
+	}
+}
+
+// If the config has a Columns list, enables exactly the fields in it,
+// and records the order and header names to write them out with.
+func (c *Config) resolveColumns() error {
+	if len(c.Columns) == 0 {
+		c.selected = nil
+		return nil
+	}
+
+	type entry struct {
+		col    column
+		header string
+	}
+
+	entries := []entry{}
+	seen := make(map[string]bool)
+	for _, name := range c.Columns {
+		header := ""
+		if i := strings.Index(name, aliasSep); i >= 0 {
+			header = strings.TrimSpace(name[i+len(aliasSep):])
+			name = name[:i]
+		}
+		name = strings.TrimSpace(name)
+
+		col, ok := lookupColumn(name)
+		if !ok {
+			return fmt.Errorf("unknown column %q in Columns", name)
+		}
+		if seen[col.Path] {
+			return fmt.Errorf("column %q appears more than once in Columns", col.Path)
+		}
+		seen[col.Path] = true
+
+		if header == "" {
+			header = col.Header
+		}
+		entries = append(entries, entry{col, header})
+	}
+
+	for _, path := range KnownColumns() {
+		if path != "Domain" {
+			c.setColumn(path, seen[path])
+		}
+	}
+
+	indices := make(map[string]int)
+	for i, col := range c.columns() {
+		indices[col.Path] = i
+	}
+
+	c.selected = []selectedColumn{}
+	for _, e := range entries {
+		c.selected = append(c.selected, selectedColumn{indices[e.col.Path], e.header})
+	}
+
+	return nil
+}
+
+// Rearranges a row built from the config's enabled fields into the order
+// given by the Columns list. If there is no Columns list, returns the row
+// as-is.
+func (c *Config) ProjectRow(row []string) []string {
+	if c.selected == nil {
+		return row
+	}
+
+	projected := make([]string, len(c.selected))
+	for i, sc := range c.selected {
+		if sc.Index < len(row) {
+			projected[i] = row[sc.Index]
+		}
+	}
+	return projected
+}
+
+// Pads a row with empty fields up to the number of enabled columns, for rows
+// which stop short, like those of skipped domains.
+func (c *Config) PadRow(row []string) []string {
+	if n := len(c.columns()); len(row) < n {
+		row = append(row, make([]string, n-len(row))...)
+	}
+	return row
+}
@@ -0,0 +1,65 @@
+package domainstats
+
+import "testing"
+
+func TestResolveColumns(t *testing.T) {
+	t.Parallel()
+	varConfig := defaultConfig()
+	varConfig.Columns = []string{
+		"Domain", "Security.ThreatType as Threat", "Status", "RR Periods",
+		"Cooccurrences",
+	}
+	varConfig.Cooccurrences = DomainScoreConfig{Domain: true}
+
+	if err := varConfig.resolveColumns(); err != nil {
+		t.Fatal(err)
+	}
+
+	refHeader := []string{"Domain", "Threat", "Status", "RR Periods", "Cooccurrences"}
+	testHeader := varConfig.DeriveHeader()
+	if !strSliceEq(refHeader, testHeader) {
+		t.Fatalf("testHeader = %v, but should = %v", testHeader, refHeader)
+	}
+
+	// only the listed fields should be enabled
+	if varConfig.Security.DGAScore || varConfig.Categories.SecurityCategories ||
+		any(varConfig.DomainRRHistory.Features) || any(varConfig.Related) {
+		t.Fatalf("fields not in Columns should be disabled: %+v", varConfig)
+	}
+
+	// tables which were already partially set should be left alone
+	if !varConfig.Cooccurrences.Domain || varConfig.Cooccurrences.Score {
+		t.Fatalf("Cooccurrences = %+v, but should only have Domain set",
+			varConfig.Cooccurrences)
+	}
+
+	// rows are built in config order, then projected into Columns order
+	row := []string{"www.example.com", "1", "Cooc", "Malware", "RRs"}
+	ref := []string{"www.example.com", "Malware", "1", "RRs", "Cooc"}
+	test := varConfig.ProjectRow(row)
+	if !strSliceEq(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+}
+
+func TestResolveColumnsErrors(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{Columns: []string{"Domain", "Security.DGAscore"}}
+	if err := varConfig.resolveColumns(); err == nil {
+		t.Fatal("unknown columns should return an error")
+	}
+
+	varConfig = Config{Columns: []string{"ThreatType", "Security.ThreatType"}}
+	if err := varConfig.resolveColumns(); err == nil {
+		t.Fatal("duplicate columns should return an error")
+	}
+}
+
+func TestProjectRowWithoutColumns(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{Status: true}
+	row := []string{"www.example.com", "1"}
+	if test := varConfig.ProjectRow(row); !strSliceEq(row, test) {
+		t.Fatalf("%v != %v", row, test)
+	}
+}
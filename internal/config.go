@@ -1,16 +1,26 @@
 package domainstats
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/dead10ck/goinvestigate"
 )
 
 var (
+	// The directory holding the default config, the API key file written by
+	// setup, and profiles. This is ~/.domainstats if it exists, for configs
+	// set up by older versions; otherwise, it is $XDG_CONFIG_HOME/domainstats,
+	// or ~/.config/domainstats if XDG_CONFIG_HOME is not set.
+	ConfigDir         string
 	DefaultConfigPath string
 )
 
@@ -20,17 +30,32 @@ func init() {
 		log.Fatal("HOME environment variable not set. Wrong platform?")
 	}
 
-	DefaultConfigPath = path.Join(home, "/.domainstats/default.toml")
+	ConfigDir = configDir(home, os.Getenv("XDG_CONFIG_HOME"))
+	DefaultConfigPath = path.Join(ConfigDir, "default.toml")
 }
 
-// Takes a struct that consists of just bool fields
-// and returns true if any of the fields are true
+func configDir(home, xdgConfigHome string) string {
+	legacyDir := path.Join(home, ".domainstats")
+	if info, err := os.Stat(legacyDir); err == nil && info.IsDir() {
+		return legacyDir
+	}
+
+	// relative paths are invalid according to the XDG spec, and should be
+	// ignored
+	if xdgConfigHome == "" || !path.IsAbs(xdgConfigHome) {
+		xdgConfigHome = path.Join(home, ".config")
+	}
+	return path.Join(xdgConfigHome, "domainstats")
+}
+
+// Takes a struct and returns true if any of its bool fields are true.
+// Fields of other types, like options, are ignored.
 func any(structField interface{}) bool {
 	rType := reflect.TypeOf(structField)
 	rInterfaceVal := reflect.ValueOf(structField)
 	rVal := rInterfaceVal.Convert(rType)
 	for i := 0; i < rVal.NumField(); i++ {
-		if rVal.Field(i).Bool() {
+		if rVal.Field(i).Kind() == reflect.Bool && rVal.Field(i).Bool() {
 			return true
 		}
 	}
@@ -39,46 +64,16 @@ func any(structField interface{}) bool {
 
 // Derive the header of the CSV output file from the config
 func (c *Config) DeriveHeader() (header []string) {
-	appendField := func(field string, cond bool) {
-		if cond {
-			header = append(header, field)
+	if c.selected != nil {
+		for _, sc := range c.selected {
+			header = append(header, sc.Header)
 		}
+		return header
 	}
 
-	appendFields := func(structField interface{}) {
-		rType := reflect.TypeOf(structField)
-		rInterfaceVal := reflect.ValueOf(structField)
-		rVal := rInterfaceVal.Convert(rType)
-		for i := 0; i < rVal.NumField(); i++ {
-			fieldVal := rVal.Type().Field(i)
-			fieldName := fieldVal.Name
-			if fieldName != "Labels" {
-				appendField(fieldName, rVal.Field(i).Bool())
-			}
-		}
-	}
-
-	// add the domain to the front
-	header = append(header, "Domain")
-
-	// add the fields in the same order the queries are constructed
-	appendField("Status", c.Status)
-	appendFields(c.Categories)
-	if any(c.Cooccurrences) {
-		appendField("Cooccurrences", true)
-	}
-	if any(c.Related) {
-		appendField("RelatedDomains", true)
-	}
-	appendFields(c.Security)
-	if any(c.TaggingDates) {
-		appendField("TaggingDates", true)
-	}
-	if any(c.DomainRRHistory.Periods) {
-		appendField("RR Periods", true)
+	for _, col := range c.columns() {
+		header = append(header, col.Header)
 	}
-	appendFields(c.DomainRRHistory.Features)
-
 	return header
 }
 
@@ -88,7 +83,7 @@ func (c *Config) DeriveMessages(inv *goinvestigate.Investigate,
 	if any(c.Categories) || c.Status {
 		msgs = append(msgs, &DomainQueryMessage{
 			&CategorizationQuery{
-				DomainQuery{inv, domain},
+				DomainQuery{inv, domain, c.egress},
 				c.Categories.Labels,
 			},
 			make(chan DomainQueryResponse, 1),
@@ -97,7 +92,7 @@ func (c *Config) DeriveMessages(inv *goinvestigate.Investigate,
 	if any(c.Cooccurrences) {
 		msgs = append(msgs, &DomainQueryMessage{
 			&CooccurrencesQuery{
-				DomainQuery{inv, domain},
+				DomainQuery{inv, domain, c.egress},
 			},
 			make(chan DomainQueryResponse, 1),
 		})
@@ -105,7 +100,7 @@ func (c *Config) DeriveMessages(inv *goinvestigate.Investigate,
 	if any(c.Related) {
 		msgs = append(msgs, &DomainQueryMessage{
 			&RelatedQuery{
-				DomainQuery{inv, domain},
+				DomainQuery{inv, domain, c.egress},
 			},
 			make(chan DomainQueryResponse, 1),
 		})
@@ -113,7 +108,7 @@ func (c *Config) DeriveMessages(inv *goinvestigate.Investigate,
 	if any(c.Security) {
 		msgs = append(msgs, &DomainQueryMessage{
 			&SecurityQuery{
-				DomainQuery{inv, domain},
+				DomainQuery{inv, domain, c.egress},
 			},
 			make(chan DomainQueryResponse, 1),
 		})
@@ -121,7 +116,7 @@ func (c *Config) DeriveMessages(inv *goinvestigate.Investigate,
 	if any(c.TaggingDates) {
 		msgs = append(msgs, &DomainQueryMessage{
 			&DomainTagsQuery{
-				DomainQuery{inv, domain},
+				DomainQuery{inv, domain, c.egress},
 			},
 			make(chan DomainQueryResponse, 1),
 		})
@@ -129,45 +124,207 @@ func (c *Config) DeriveMessages(inv *goinvestigate.Investigate,
 	if any(c.DomainRRHistory.Periods) || any(c.DomainRRHistory.Features) {
 		msgs = append(msgs, &DomainQueryMessage{
 			&DomainRRHistoryQuery{
-				DomainQuery{inv, domain},
+				DomainQuery{inv, domain, c.egress},
 				"A",
 			},
 			make(chan DomainQueryResponse, 1),
 		})
 	}
+	if any(c.Whois) {
+		msgs = append(msgs, &DomainQueryMessage{
+			&WhoisQuery{
+				DomainQuery{inv, domain, c.egress},
+				c.Whois.EmailDomains,
+			},
+			make(chan DomainQueryResponse, 1),
+		})
+	}
+	for _, s := range c.sources {
+		msgs = append(msgs, &DomainQueryMessage{
+			&ProviderQuery{
+				DomainQuery{inv, domain, c.egress},
+				s.name,
+				s.source,
+			},
+			make(chan DomainQueryResponse, 1),
+		})
+	}
 	return msgs
 }
 
 // Returns a new Config object. Reads the TOML file given by configFilePath.
+// Any warnings about the config, such as unknown keys, are logged.
 func NewConfig(configFilePath string) (config *Config, err error) {
-	if _, err := toml.DecodeFile(configFilePath, &config); err != nil {
-		log.Fatal(err)
+	config, warnings, err := LoadConfig(configFilePath)
+	if err != nil {
+		return nil, err
 	}
 
-	if config.APIKey == "" {
-		log.Fatal("Config file is missing APIKey")
+	for _, w := range warnings {
+		log.Printf("warning: %s: %v", configFilePath, w)
 	}
 
 	return config, nil
 }
 
-// Generates a default config and writes it to ~/.domainstats/default.toml
-func GenerateDefaultConfig(apiKey string) error {
-	configDir := path.Dir(DefaultConfigPath)
+// Reads and validates the TOML file given by configFilePath.
+//
+// Problems which make the config unusable are returned as err. Problems
+// which may be mistakes, but don't stop the config from being used, are
+// returned as warnings; e.g., an *UnknownKeyError for each key which
+// doesn't match any config option.
+func LoadConfig(configFilePath string) (config *Config, warnings []error, err error) {
+	return loadConfig(configFilePath, "", true)
+}
+
+// Loads the named profile. If the config directory (see ConfigDir) has a
+// file named <profile>.toml, it is loaded like LoadConfig. Otherwise, if
+// there is a built-in preset with the profile's name, the config file given
+// by configFilePath is loaded, with its fields replaced by the preset's.
+func LoadProfile(profile, configFilePath string) (config *Config, warnings []error, err error) {
+	return loadProfile(profile, configFilePath, true)
+}
+
+// Checks the config file like LoadConfig, but without resolving the API key,
+// so that it can be checked where the key isn't available. APIKeyFile isn't
+// read and APIKeyCommand isn't run; the returned config has no key.
+func CheckConfig(configFilePath string) (config *Config, warnings []error, err error) {
+	return loadConfig(configFilePath, "", false)
+}
+
+// Checks the named profile like LoadProfile, without resolving the API key.
+func CheckProfile(profile, configFilePath string) (config *Config, warnings []error, err error) {
+	return loadProfile(profile, configFilePath, false)
+}
+
+func loadProfile(profile, configFilePath string, resolveKey bool) (config *Config, warnings []error, err error) {
+	profilePath := filepath.Join(ConfigDir, profile+".toml")
+	if _, err := os.Stat(profilePath); err == nil {
+		return loadConfig(profilePath, "", resolveKey)
+	}
+
+	if _, ok := presets[profile]; !ok {
+		return nil, nil, fmt.Errorf("no profile named %q: %s does not exist, and "+
+			"there is no built-in preset of that name (presets: %s)",
+			profile, profilePath, strings.Join(PresetNames(), ", "))
+	}
+	return loadConfig(configFilePath, profile, resolveKey)
+}
+
+func loadConfig(configFilePath, preset string, resolveKey bool) (config *Config, warnings []error, err error) {
+	config = new(Config)
+	warnings, err = config.decodeFile(configFilePath, make(map[string]bool))
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	if preset != "" {
+		if err := config.applyPreset(preset); err != nil {
+			return nil, warnings, err
+		}
+	}
+
+	if resolveKey {
+		keyWarnings, err := config.resolveAPIKey(config.apiKeyDir)
+		warnings = append(warnings, keyWarnings...)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("config file %s: %v", configFilePath, err)
+		}
+	} else {
+		warnings = append(warnings, config.checkAPIKeySources()...)
+	}
+
+	if err := config.validateOptions(); err != nil {
+		return nil, warnings, fmt.Errorf("config file %s: %v", configFilePath, err)
+	}
+
+	config.egress = config.newEgressPolicy()
+
+	if err := config.resolveColumns(); err != nil {
+		return nil, warnings, fmt.Errorf("config file %s: %v", configFilePath, err)
+	}
+
+	if err := config.validateWebhook(); err != nil {
+		return nil, warnings, fmt.Errorf("config file %s: %v", configFilePath, err)
+	}
+
+	if err := config.openProviders(); err != nil {
+		return nil, warnings, fmt.Errorf("config file %s: %v", configFilePath, err)
+	}
+
+	if len(config.QueryPlan()) == 0 {
+		warnings = append(warnings, errors.New("no Investigate fields are enabled; "+
+			"only the domains themselves will be written"))
+	}
+
+	return config, warnings, nil
+}
+
+// Decodes the given TOML file into the config. If it has an Include, that
+// is decoded first, so that the file's own settings override it. seen
+// holds the files already being decoded, to catch include cycles.
+func (c *Config) decodeFile(configFilePath string, seen map[string]bool) (warnings []error, err error) {
+	absPath, err := filepath.Abs(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if seen[absPath] {
+		return nil, fmt.Errorf("include cycle: config file %s is already being read", configFilePath)
+	}
+	seen[absPath] = true
+
+	contents, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", configFilePath, err)
+	}
 
-	err := os.MkdirAll(configDir, 0700)
+	var include struct{ Include string }
+	if _, err := toml.Decode(string(contents), &include); err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", configFilePath, err)
+	}
 
+	configDir := filepath.Dir(configFilePath)
+	switch {
+	case strings.HasPrefix(include.Include, presetPrefix):
+		if err := c.includePreset(strings.TrimPrefix(include.Include, presetPrefix)); err != nil {
+			return nil, fmt.Errorf("config file %s: %v", configFilePath, err)
+		}
+	case include.Include != "":
+		includePath := expandPath(include.Include, configDir)
+		includeWarnings, err := c.decodeFile(includePath, seen)
+		for _, w := range includeWarnings {
+			warnings = append(warnings, fmt.Errorf("%s: %v", includePath, w))
+		}
+		if err != nil {
+			return warnings, fmt.Errorf("config file %s: %v", configFilePath, err)
+		}
+	}
+
+	md, err := toml.Decode(string(contents), c)
 	if err != nil {
-		return err
+		return warnings, fmt.Errorf("error reading config file %s: %v", configFilePath, err)
 	}
 
-	configFile, err := os.Create(DefaultConfigPath)
+	providerWarnings, err := c.decodeProviders(string(contents))
 	if err != nil {
-		return err
+		return warnings, fmt.Errorf("error reading config file %s: %v", configFilePath, err)
+	}
+	warnings = append(warnings, providerWarnings...)
+
+	if md.IsDefined("APIKeyFile") {
+		c.apiKeyDir = configDir
+	}
+	if md.IsDefined("Skip", "RankingFile") {
+		c.skipDir = configDir
 	}
 
-	trueConfig := Config{
-		APIKey: apiKey,
+	return append(warnings, unknownKeys(md)...), nil
+}
+
+// Returns a config with every field set to true, except Whois.EmailDomains,
+// which takes a query of its own and is opt-in
+func defaultConfig() *Config {
+	return &Config{
 		Status: true,
 		Categories: CategoriesConfig{
 			Labels:             true,
@@ -241,26 +398,90 @@ func GenerateDefaultConfig(apiKey string) error {
 				IsSubdomain:     true,
 			},
 		},
+		Whois: WhoisConfig{
+			Registrant:             true,
+			RegistrantOrganization: true,
+			RegistrantEmail:        true,
+			Registrar:              true,
+			Created:                true,
+			Expires:                true,
+			NameServers:            true,
+			Emails:                 true,
+		},
 	}
-
-	tomlEncoder := toml.NewEncoder(configFile)
-	err = tomlEncoder.Encode(trueConfig)
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
 
 type Config struct {
-	APIKey          string
-	Status          bool
-	Categories      CategoriesConfig
-	Cooccurrences   DomainScoreConfig
-	Related         DomainScoreConfig
-	Security        SecurityConfig
-	TaggingDates    TaggingDatesConfig
-	DomainRRHistory DomainRRHistoryConfig
+	// The Investigate API key. Rather than putting the key itself in the
+	// config, it can be read from a file or from the output of a command,
+	// so config files can be shared without leaking it. If the
+	// DOMAINSTATS_API_KEY environment variable is set, it is used instead.
+	APIKey        string
+	APIKeyFile    string
+	APIKeyCommand string
+
+	// Another config file to read before this one, so that this one only
+	// has to set what it changes. A built-in preset can be included with
+	// "preset:<name>".
+	Include string
+
+	// Make the Investigate queries once per registered domain (see
+	// RegisteredDomain), rather than once per domain, and give each domain
+	// the results for its registered domain. This saves queries on lists
+	// with many subdomains of the same domain.
+	QueryRegisteredDomain bool
+
+	// Which columns to write out, and in what order. If set, this overrides
+	// the fields set in the tables below. See KnownColumns for valid names.
+	Columns          []string
+	Line             bool
+	RegisteredDomain bool
+	TLD              bool
+	QueryLog         QueryLogConfig
+	Skipped          bool
+	Status           bool
+	Categories       CategoriesConfig
+	Cooccurrences    DomainScoreConfig
+	Related          DomainScoreConfig
+	Security         SecurityConfig
+	TaggingDates     TaggingDatesConfig
+	DomainRRHistory  DomainRRHistoryConfig
+	Whois            WhoisConfig
+
+	// Settings for the output formats which send results somewhere other
+	// than the output file. These tables are optional.
+	OpenSearch *OpenSearchConfig
+	Splunk     *SplunkConfig
+	Syslog     *SyslogConfig
+
+	// Settings for notifying a webhook of flagged domains, alongside the
+	// output. This table is optional.
+	Webhook *WebhookConfig
+
+	// Settings for skipping domains which aren't worth querying. This table
+	// is optional.
+	Skip *SkipConfig
+
+	// Additions to the egress policy, which keeps internal names from being
+	// sent to Investigate. The policy applies whether or not this is set.
+	Egress *EgressConfig
+
+	// the columns picked by Columns, in output order
+	selected []selectedColumn
+
+	// the directory relative APIKeyFile paths are relative to
+	apiKeyDir string
+
+	// the directory a relative Skip.RankingFile path is relative to
+	skipDir string
+
+	// the egress policy, built from Egress
+	egress *EgressPolicy
+
+	// the settings of registered providers, by name, and the sources of the
+	// ones with columns on
+	providers map[string]interface{}
+	sources   []providerSource
 }
 
 type CategoriesConfig struct {
@@ -272,6 +493,19 @@ type CategoriesConfig struct {
 type DomainScoreConfig struct {
 	Domain bool
 	Score  bool
+
+	// Options to keep the column readable for domains with many results.
+	// Results are filtered, then sorted, then cut down to MaxResults.
+
+	// The most results to include. 0 means no limit.
+	MaxResults int
+	// Results with a lower score are left out
+	MinScore float64
+	// "score" sorts by score, highest first, and "domain" sorts
+	// alphabetically. By default, results are in the order the API gives.
+	Sort string
+	// Results for these domains, or their subdomains, are left out
+	Exclude []string
 }
 
 type SecurityConfig struct {
@@ -339,3 +573,22 @@ type DomainRRHistoryFeaturesConfig struct {
 	BaseDomain      bool
 	IsSubdomain     bool
 }
+
+type WhoisConfig struct {
+	Registrant             bool
+	RegistrantOrganization bool
+	RegistrantEmail        bool
+	Registrar              bool
+	Created                bool
+	Expires                bool
+	NameServers            bool
+	Emails                 bool
+
+	// Other domains registered with the same registrant email, which takes
+	// a second query, so it's off by default. The domain itself and its
+	// subdomains are left out.
+	EmailDomains bool
+
+	// The most domains to list in EmailDomains. 0 means no limit.
+	MaxEmailDomains int
+}
@@ -2,6 +2,8 @@ package domainstats
 
 import (
 	"errors"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -25,6 +27,10 @@ func (c *Config) ExtractCSVSubRow(goinvResp interface{}) (row []string, err erro
 		return c.extractDomainTagInfo(resp), nil
 	case *goinvestigate.DomainRRHistory:
 		return c.extractDomainRRHistoryInfo(resp), nil
+	case *WhoisResponse:
+		return c.extractWhoisInfo(resp), nil
+	case *ProviderResponse:
+		return c.extractProviderInfo(resp), nil
 	default:
 		return nil, errors.New("invalid type")
 	}
@@ -49,54 +55,95 @@ func (c *Config) extractDomainCatInfo(resp *goinvestigate.DomainCategorization)
 
 // dynamic field. Should return a singleton list
 func (c *Config) extractRelatedDomainInfo(resp []goinvestigate.RelatedDomain) []string {
-	if len(resp) == 0 && any(c.Related) {
-		return []string{""}
-	}
-	row := []string{}
+	return c.Related.scoredDomainsCell(relatedDomainResults(resp))
+}
+
+// dynamic field. Should return a singleton list
+func (c *Config) extractCooccurrenceInfo(resp []goinvestigate.Cooccurrence) []string {
+	return c.Cooccurrences.scoredDomainsCell(cooccurrenceResults(resp))
+}
+
+// A result from an endpoint which gives back a list of domains with scores
+type scoredDomain struct {
+	Domain   string
+	Score    float64
+	ScoreStr string
+}
+
+func relatedDomainResults(resp []goinvestigate.RelatedDomain) []scoredDomain {
+	results := []scoredDomain{}
 	for _, rd := range resp {
-		if c.Related.Domain {
-			row = append(row, rd.Domain)
-			if c.Related.Score {
-				row[len(row)-1] += ":"
-			}
-		} else if c.Related.Score {
-			row = append(row, "")
-		}
-		if c.Related.Score {
-			row[len(row)-1] += strconv.Itoa(rd.Score)
-		}
+		results = append(results, scoredDomain{rd.Domain, float64(rd.Score), strconv.Itoa(rd.Score)})
 	}
-	if len(row) == 0 {
-		return []string{}
+	return results
+}
+
+func cooccurrenceResults(resp []goinvestigate.Cooccurrence) []scoredDomain {
+	results := []scoredDomain{}
+	for _, cooc := range resp {
+		results = append(results, scoredDomain{cooc.Domain, cooc.Score, convertFloatToStr(cooc.Score)})
 	}
-	return []string{strings.Join(row, ", ")}
+	return results
 }
 
-// dynamic field. Should return a singleton list
-func (c *Config) extractCooccurrenceInfo(resp []goinvestigate.Cooccurrence) []string {
-	if len(resp) == 0 && any(c.Cooccurrences) {
-		return []string{""}
+// Filters, sorts and limits the results according to the config, and
+// formats them as a single cell.
+func (dsc DomainScoreConfig) scoredDomainsCell(results []scoredDomain) []string {
+	if !any(dsc) {
+		return []string{}
 	}
+
+	results = dsc.filter(results)
+
 	row := []string{}
-	for _, cooc := range resp {
-		if c.Cooccurrences.Domain {
-			row = append(row, cooc.Domain)
-			if c.Cooccurrences.Score {
+	for _, sd := range results {
+		if dsc.Domain {
+			row = append(row, sd.Domain)
+			if dsc.Score {
 				row[len(row)-1] += ":"
 			}
-		} else if c.Cooccurrences.Score {
+		} else if dsc.Score {
 			row = append(row, "")
 		}
-		if c.Cooccurrences.Score {
-			row[len(row)-1] += convertFloatToStr(cooc.Score)
+		if dsc.Score {
+			row[len(row)-1] += sd.ScoreStr
 		}
 	}
-	if len(row) == 0 {
-		return []string{}
-	}
 	return []string{strings.Join(row, ", ")}
 }
 
+func (dsc DomainScoreConfig) filter(results []scoredDomain) []scoredDomain {
+	filtered := []scoredDomain{}
+	for _, sd := range results {
+		if sd.Score < dsc.MinScore || domainMatches(sd.Domain, dsc.Exclude) {
+			continue
+		}
+		filtered = append(filtered, sd)
+	}
+
+	switch dsc.Sort {
+	case "score":
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return filtered[i].Score > filtered[j].Score
+		})
+	case "domain":
+		sort.SliceStable(filtered, func(i, j int) bool {
+			return filtered[i].Domain < filtered[j].Domain
+		})
+	}
+
+	if dsc.MaxResults > 0 && len(filtered) > dsc.MaxResults {
+		filtered = filtered[:dsc.MaxResults]
+	}
+	return filtered
+}
+
+// Reports whether the domain is one of the given domains, or a subdomain
+// of one of them.
+func domainMatches(domain string, suffixes []string) bool {
+	return newDomainMatcher(suffixes, nil).match(normalizeDomain(domain)) != ""
+}
+
 // partially dynamic. Geo* fields are single fields
 func (c *Config) extractSecurityFeaturesInfo(resp *goinvestigate.SecurityFeatures) []string {
 	row := []string{}
@@ -190,6 +237,61 @@ func (c *Config) extractDomainRRHistoryInfo(resp *goinvestigate.DomainRRHistory)
 	return row
 }
 
+func (c *Config) extractWhoisInfo(resp *WhoisResponse) []string {
+	row := []string{}
+	for _, column := range c.Whois.columns() {
+		switch v := c.Whois.value(resp, column).(type) {
+		case []string:
+			row = append(row, strings.Join(v, ", "))
+		case string:
+			row = append(row, v)
+		}
+	}
+	return row
+}
+
+// Returns the names of the enabled Whois columns, in order.
+func (wc WhoisConfig) columns() []string {
+	cols := []string{}
+	rVal := reflect.ValueOf(wc)
+	for i := 0; i < rVal.NumField(); i++ {
+		if rVal.Field(i).Kind() == reflect.Bool && rVal.Field(i).Bool() {
+			cols = append(cols, rVal.Type().Field(i).Name)
+		}
+	}
+	return cols
+}
+
+// Returns the value of a Whois column: a string, or a list of strings for
+// the columns which can have several values.
+func (wc WhoisConfig) value(resp *WhoisResponse, column string) interface{} {
+	switch column {
+	case "Registrant":
+		return resp.RegistrantName
+	case "RegistrantOrganization":
+		return resp.RegistrantOrganization
+	case "RegistrantEmail":
+		return resp.RegistrantEmail
+	case "Registrar":
+		return resp.RegistrarName
+	case "Created":
+		return resp.Created
+	case "Expires":
+		return resp.Expires
+	case "NameServers":
+		return resp.NameServers
+	case "Emails":
+		return resp.Emails
+	case "EmailDomains":
+		domains := resp.EmailDomains
+		if wc.MaxEmailDomains > 0 && len(domains) > wc.MaxEmailDomains {
+			domains = domains[:wc.MaxEmailDomains]
+		}
+		return domains
+	}
+	return ""
+}
+
 func locsToStr(locs []goinvestigate.Location) string {
 	strs := []string{}
 	for _, loc := range locs {
@@ -1,6 +1,7 @@
 package domainstats
 
 import (
+	"fmt"
 	"runtime"
 	"testing"
 
@@ -86,7 +87,7 @@ func TestRrPeriodsToStr(t *testing.T) {
 	testStr := config.rrPeriodsToStr(testRRPeriods)
 
 	if refStr != testStr {
-		t.Fatal("testStr = %s, but should = %s", testStr, refStr)
+		t.Fatalf("testStr = %s, but should = %s", testStr, refStr)
 	}
 
 	// sanity check for some config variation
@@ -108,7 +109,7 @@ func TestRrPeriodsToStr(t *testing.T) {
 	testStr = varConfig.rrPeriodsToStr(testRRPeriods)
 
 	if refStr != testStr {
-		t.Fatal("testStr = %s, but should = %s", testStr, refStr)
+		t.Fatalf("testStr = %s, but should = %s", testStr, refStr)
 	}
 }
 
@@ -694,3 +695,134 @@ func TestExtractDomainRRHistoryInfo(t *testing.T) {
 		t.Fatalf("%v != %v", ref, test)
 	}
 }
+
+func TestScoredDomainFilters(t *testing.T) {
+	t.Parallel()
+	cl := []goinvestigate.Cooccurrence{
+		goinvestigate.Cooccurrence{Domain: "a.example.com", Score: 0.1},
+		goinvestigate.Cooccurrence{Domain: "www.google.com", Score: 0.5},
+		goinvestigate.Cooccurrence{Domain: "c.example.com", Score: 0.3},
+		goinvestigate.Cooccurrence{Domain: "b.example.com", Score: 0.05},
+	}
+	verify := func(dsc DomainScoreConfig, ref []string) {
+		varConfig := Config{Cooccurrences: dsc}
+		test, _ := varConfig.ExtractCSVSubRow(cl)
+		if !strSliceEq(ref, test) {
+			t.Fatalf("%v != %v", ref, test)
+		}
+	}
+
+	verify(DomainScoreConfig{Domain: true, MaxResults: 2},
+		[]string{"a.example.com, www.google.com"})
+	verify(DomainScoreConfig{Domain: true, Score: true, MinScore: 0.2},
+		[]string{"www.google.com:0.5, c.example.com:0.3"})
+	verify(DomainScoreConfig{Domain: true, Sort: "score", MaxResults: 3},
+		[]string{"www.google.com, c.example.com, a.example.com"})
+	verify(DomainScoreConfig{Domain: true, Sort: "domain"},
+		[]string{"a.example.com, b.example.com, c.example.com, www.google.com"})
+	verify(DomainScoreConfig{Domain: true, Exclude: []string{"google.com"}},
+		[]string{"a.example.com, c.example.com, b.example.com"})
+
+	// filters are applied before sorting and limiting
+	verify(DomainScoreConfig{Score: true, Sort: "score", MaxResults: 2, Exclude: []string{"*.google.com."}},
+		[]string{"0.3, 0.1"})
+
+	// if every result is filtered out, the field should still be there
+	verify(DomainScoreConfig{Domain: true, MinScore: 1}, []string{""})
+
+	// options alone don't enable the field
+	verify(DomainScoreConfig{MaxResults: 2}, []string{})
+}
+
+func TestDomainMatches(t *testing.T) {
+	t.Parallel()
+	suffixes := []string{"example.com", "*.Corp.example.", "akamai.net."}
+	for domain, ref := range map[string]bool{
+		"example.com":         true,
+		"www.example.com":     true,
+		"WWW.EXAMPLE.COM.":    true,
+		"notexample.com":      false,
+		"host.corp.example":   true,
+		"a2.cdn.akamai.net":   true,
+		"akamai.net.evil.com": false,
+	} {
+		if test := domainMatches(domain, suffixes); test != ref {
+			t.Fatalf("domainMatches(%q) = %v, but should = %v", domain, test, ref)
+		}
+	}
+}
+
+func TestExtractWhoisInfo(t *testing.T) {
+	t.Parallel()
+	resp := &WhoisResponse{
+		DomainWhois: &goinvestigate.DomainWhois{
+			DomainName:      "example.com",
+			RegistrantName:  "Domain Admin",
+			RegistrantEmail: "admin@example.com",
+			RegistrarName:   "EXAMPLE REGISTRAR, INC.",
+			Created:         "1995-08-14",
+			Expires:         "2025-08-13",
+			NameServers:     []string{"a.iana-servers.net", "b.iana-servers.net"},
+		},
+		EmailDomains: []string{"example.net", "example.org", "example.edu"},
+	}
+
+	config := Config{Whois: defaultConfig().Whois}
+	if config.Whois.EmailDomains {
+		t.Fatal("EmailDomains should be off by default")
+	}
+	config.Whois.EmailDomains = true
+	ref := []string{
+		"Domain Admin", "", "admin@example.com", "EXAMPLE REGISTRAR, INC.",
+		"1995-08-14", "2025-08-13", "a.iana-servers.net, b.iana-servers.net", "",
+		"example.net, example.org, example.edu",
+	}
+	test, err := config.ExtractCSVSubRow(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strSliceEq(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	config.Whois = WhoisConfig{Registrar: true, EmailDomains: true, MaxEmailDomains: 2}
+	ref = []string{"EXAMPLE REGISTRAR, INC.", "example.net, example.org"}
+	test, _ = config.ExtractCSVSubRow(resp)
+	if !strSliceEq(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	// the columns are in the same order as the fields
+	refCols := []string{"Domain", "Registrar", "EmailDomains"}
+	if testCols := config.DeriveHeader(); !strSliceEq(refCols, testCols) {
+		t.Fatalf("%v != %v", refCols, testCols)
+	}
+	// and lists are lists in Parquet
+	fields := config.parquetFields()
+	row := Row{Domain: "example.com", Responses: []interface{}{resp}}
+	if test := fields[1].value(row); test != "EXAMPLE REGISTRAR, INC." {
+		t.Fatalf("%v != %v", "EXAMPLE REGISTRAR, INC.", test)
+	}
+	refList := parquetListValue([]interface{}{"example.net", "example.org"})
+	if test := fields[2].value(row); fmt.Sprint(refList) != fmt.Sprint(test) {
+		t.Fatalf("%v != %v", refList, test)
+	}
+}
+
+func TestWhoisEmailDomains(t *testing.T) {
+	t.Parallel()
+	config := &Config{}
+	config.egress = config.newEgressPolicy()
+	q := &WhoisQuery{DomainQuery{nil, "example.com", config.egress}, true}
+
+	// neither of these are sent, so there's no need for a client
+	for _, email := range []string{"", "admin@intranet", "hostmaster@10.in-addr.arpa"} {
+		domains, err := q.emailDomains("example.com", email)
+		if err != nil || len(domains) != 0 {
+			t.Fatalf("%q: unexpected domains %v, %v", email, domains, err)
+		}
+	}
+	if n := config.egress.Blocked(); n != 2 {
+		t.Fatalf("%v != %v", 2, n)
+	}
+}
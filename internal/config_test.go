@@ -2,8 +2,6 @@ package domainstats
 
 import (
 	"log"
-	"os"
-	"path/filepath"
 	"runtime"
 	"testing"
 
@@ -16,9 +14,8 @@ var (
 )
 
 func init() {
-	home := os.Getenv("HOME")
 	var err error
-	config, err = NewConfig(filepath.Join(home, ".domainstats/default.toml"))
+	config, err = NewConfig(DefaultConfigPath)
 	if err != nil {
 		log.Fatal(err)
 	}
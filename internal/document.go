@@ -0,0 +1,103 @@
+package domainstats
+
+// Builds JSON documents from rows, for the sinks which send results to a
+// search or logging system. Documents have the same fields and types as
+// the Parquet output: one per output column, with lists and groups kept as
+// JSON arrays and objects.
+type documentBuilder struct {
+	fields []parquetField
+}
+
+func newDocumentBuilder(c *Config) *documentBuilder {
+	return &documentBuilder{fields: c.parquetFields()}
+}
+
+// Returns the document for the row, as a value which encoding/json can
+// marshal.
+func (b *documentBuilder) document(row Row) map[string]interface{} {
+	doc := make(map[string]interface{})
+	for _, f := range b.fields {
+		doc[f.node.Name] = jsonValue(f.node, f.value(row))
+	}
+	return doc
+}
+
+// Converts a value given to a Parquet schema node into its JSON form.
+func jsonValue(n *parquetNode, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	if n.ConvertedType == parquetList {
+		elem := n.Children[0].Children[0]
+		items := value.([]interface{})[0].([]interface{})
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			list[i] = jsonValue(elem, item.([]interface{})[0])
+		}
+		return list
+	}
+
+	if n.Children != nil {
+		fields := value.([]interface{})
+		obj := make(map[string]interface{})
+		for i, child := range n.Children {
+			obj[child.Name] = jsonValue(child, fields[i])
+		}
+		return obj
+	}
+
+	return value
+}
+
+// Returns an Elasticsearch/OpenSearch mapping for the documents. Strings
+// are mapped as keywords, and lists of groups as nested objects, so that
+// e.g. a cooccurring domain can be matched together with its score.
+func (b *documentBuilder) mapping() map[string]interface{} {
+	props := map[string]interface{}{
+		"@timestamp": map[string]interface{}{"type": "date"},
+	}
+	for _, f := range b.fields {
+		props[f.node.Name] = fieldMapping(f.node)
+	}
+	return map[string]interface{}{"properties": props}
+}
+
+func fieldMapping(n *parquetNode) map[string]interface{} {
+	if n.ConvertedType == parquetList {
+		elem := n.Children[0].Children[0]
+		m := fieldMapping(elem)
+		if elem.Children != nil {
+			m["type"] = "nested"
+		}
+		return m
+	}
+
+	if n.Children != nil {
+		props := make(map[string]interface{})
+		for _, child := range n.Children {
+			props[child.Name] = fieldMapping(child)
+		}
+		return map[string]interface{}{"properties": props}
+	}
+
+	if n.ConvertedType == parquetTimestampMillis {
+		return map[string]interface{}{"type": "date", "format": "epoch_millis"}
+	}
+	switch n.Type {
+	case parquetBoolean:
+		return map[string]interface{}{"type": "boolean"}
+	case parquetInt64:
+		return map[string]interface{}{"type": "long"}
+	case parquetDouble:
+		return map[string]interface{}{"type": "double"}
+	default:
+		return map[string]interface{}{"type": "keyword"}
+	}
+}
+
+// Returns the Elasticsearch/OpenSearch index mapping for the documents
+// written with the config.
+func (c *Config) IndexMapping() map[string]interface{} {
+	return newDocumentBuilder(c).mapping()
+}
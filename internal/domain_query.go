@@ -1,6 +1,10 @@
 package domainstats
 
-import "github.com/dead10ck/goinvestigate"
+import (
+	"strings"
+
+	"github.com/dead10ck/goinvestigate"
+)
 
 type DomainQueryType interface {
 	Query() DomainQueryResponse
@@ -9,6 +13,17 @@ type DomainQueryType interface {
 type DomainQuery struct {
 	Inv    *goinvestigate.Investigate
 	Domain string
+
+	// checked before the query is made
+	egress *EgressPolicy
+}
+
+// Returns an error if the egress policy blocks the domain from being sent.
+func (q *DomainQuery) blocked() error {
+	if rule := q.egress.Blocks(q.Domain); rule != "" {
+		return &EgressError{q.Domain, rule}
+	}
+	return nil
 }
 
 type DomainQueryMessage struct {
@@ -27,6 +42,9 @@ type CategorizationQuery struct {
 }
 
 func (q *CategorizationQuery) Query() DomainQueryResponse {
+	if err := q.blocked(); err != nil {
+		return DomainQueryResponse{Err: err}
+	}
 	resp, err := q.Inv.Categorization(q.Domain, q.Labels)
 	return DomainQueryResponse{Resp: resp, Err: err}
 }
@@ -36,6 +54,9 @@ type RelatedQuery struct {
 }
 
 func (q *RelatedQuery) Query() DomainQueryResponse {
+	if err := q.blocked(); err != nil {
+		return DomainQueryResponse{Err: err}
+	}
 	resp, err := q.Inv.RelatedDomains(q.Domain)
 	return DomainQueryResponse{Resp: resp, Err: err}
 }
@@ -45,7 +66,10 @@ type CooccurrencesQuery struct {
 }
 
 func (q *CooccurrencesQuery) Query() DomainQueryResponse {
-	resp, err := q.Inv.RelatedDomains(q.Domain)
+	if err := q.blocked(); err != nil {
+		return DomainQueryResponse{Err: err}
+	}
+	resp, err := q.Inv.Cooccurrences(q.Domain)
 	return DomainQueryResponse{Resp: resp, Err: err}
 }
 
@@ -54,6 +78,9 @@ type SecurityQuery struct {
 }
 
 func (q *SecurityQuery) Query() DomainQueryResponse {
+	if err := q.blocked(); err != nil {
+		return DomainQueryResponse{Err: err}
+	}
 	resp, err := q.Inv.Security(q.Domain)
 	return DomainQueryResponse{Resp: resp, Err: err}
 }
@@ -63,6 +90,9 @@ type DomainTagsQuery struct {
 }
 
 func (q *DomainTagsQuery) Query() DomainQueryResponse {
+	if err := q.blocked(); err != nil {
+		return DomainQueryResponse{Err: err}
+	}
 	resp, err := q.Inv.DomainTags(q.Domain)
 	return DomainQueryResponse{Resp: resp, Err: err}
 }
@@ -73,6 +103,68 @@ type DomainRRHistoryQuery struct {
 }
 
 func (q *DomainRRHistoryQuery) Query() DomainQueryResponse {
+	if err := q.blocked(); err != nil {
+		return DomainQueryResponse{Err: err}
+	}
 	resp, err := q.Inv.DomainRRHistory(q.Domain, q.QueryType)
 	return DomainQueryResponse{Resp: resp, Err: err}
 }
+
+type WhoisQuery struct {
+	DomainQuery
+	EmailDomains bool
+}
+
+// The WHOIS record of a domain, along with the other domains registered
+// with its registrant's email, if they were asked for
+type WhoisResponse struct {
+	*goinvestigate.DomainWhois
+	EmailDomains []string
+}
+
+func (q *WhoisQuery) Query() DomainQueryResponse {
+	if err := q.blocked(); err != nil {
+		return DomainQueryResponse{Err: err}
+	}
+
+	// WHOIS records are kept for registered domains, not their subdomains
+	domain := q.Domain
+	if registered := RegisteredDomain(domain); registered != "" {
+		domain = registered
+	}
+	record, err := q.Inv.DomainWhois(domain)
+	if err != nil {
+		return DomainQueryResponse{Err: err}
+	}
+
+	resp := &WhoisResponse{DomainWhois: record}
+	if q.EmailDomains {
+		resp.EmailDomains, err = q.emailDomains(domain, record.RegistrantEmail)
+		if err != nil {
+			return DomainQueryResponse{Err: err}
+		}
+	}
+	return DomainQueryResponse{Resp: resp}
+}
+
+// Returns the domains registered with the email, other than the given
+// domain and its subdomains. The email isn't looked up if it's empty, or if
+// the egress policy blocks its domain.
+func (q *WhoisQuery) emailDomains(domain, email string) ([]string, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 || q.egress.Blocks(email[at+1:]) != "" {
+		return []string{}, nil
+	}
+
+	list, err := q.Inv.EmailWhois(email)
+	if err != nil {
+		return nil, err
+	}
+	domains := []string{}
+	for _, wd := range list.Domains {
+		if !domainMatches(wd.Domain, []string{domain}) {
+			domains = append(domains, wd.Domain)
+		}
+	}
+	return domains, nil
+}
@@ -0,0 +1,91 @@
+package domainstats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// Sends every request to the test server, whatever its host
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme, req.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Returns a client whose requests are answered with the given bodies, by
+// path. Other paths get a 404.
+func newTestInvestigate(t *testing.T, responses map[string]string) *goinvestigate.Investigate {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(ts.Close)
+
+	target, _ := url.Parse(ts.URL)
+	inv := goinvestigate.New("test-key")
+	inv.SetHTTPClient(&http.Client{Transport: redirectTransport{target}})
+	return inv
+}
+
+// Responses for example.com from each endpoint the queries go to
+var testResponses = map[string]string{
+	"/recommendations/name/example.com.json": `{"found": true, "pfs2": [["b.com", 0.25], ["c.com", 0.5]]}`,
+	"/links/name/example.com.json":           `{"found": true, "tb1": [["r.com", 3]]}`,
+}
+
+// Makes the queries the config asks for about the domain, the way the
+// domainstats command does, and returns its row.
+func queryTestRow(t *testing.T, c *Config, inv *goinvestigate.Investigate, domain string) Row {
+	row := Row{Domain: domain, Fields: []string{domain}}
+	for _, msg := range c.DeriveMessages(inv, domain) {
+		resp := msg.Q.Query()
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+		subRow, err := c.ExtractCSVSubRow(resp.Resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		row.Fields = append(row.Fields, subRow...)
+		row.Responses = append(row.Responses, resp.Resp)
+	}
+	return row
+}
+
+func TestCooccurrencesQuery(t *testing.T) {
+	t.Parallel()
+	inv := newTestInvestigate(t, testResponses)
+
+	varConfig := &Config{
+		Cooccurrences: DomainScoreConfig{Domain: true, Score: true, Sort: "score", MaxResults: 1},
+	}
+	row := queryTestRow(t, varConfig, inv, "example.com")
+	if _, ok := row.Responses[0].([]goinvestigate.Cooccurrence); !ok {
+		t.Fatalf("unexpected response %#v", row.Responses[0])
+	}
+	ref := []string{"example.com", "c.com:0.5"}
+	if !strSliceEq(ref, row.Fields) {
+		t.Fatalf("%v != %v", ref, row.Fields)
+	}
+	if header := varConfig.DeriveHeader(); len(header) != len(row.Fields) {
+		t.Fatalf("%v doesn't line up with %v", header, row.Fields)
+	}
+
+	// each table's options only apply to its own endpoint
+	varConfig.Related = DomainScoreConfig{Domain: true}
+	ref = []string{"example.com", "c.com:0.5", "r.com"}
+	if row := queryTestRow(t, varConfig, inv, "example.com"); !strSliceEq(ref, row.Fields) {
+		t.Fatalf("%v != %v", ref, row.Fields)
+	}
+}
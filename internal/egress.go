@@ -0,0 +1,149 @@
+package domainstats
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings for the egress policy, which keeps internal names from ever
+// being sent to Investigate. The policy always blocks single-label names,
+// the reverse zones of private networks (RFC 1918 and RFC 4193), and names
+// whose TLD isn't on the Public Suffix List, like "corp" or "local"; this
+// table adds to it.
+type EgressConfig struct {
+	// Names which are one of these, or under one of them, are blocked,
+	// e.g. "corp.example".
+	BlockSuffixes []string
+
+	// Names which match any of these regular expressions are blocked.
+	BlockPatterns []string
+
+	// A file to append a line to for each blocked name, with the time, the
+	// name and the rule which blocked it, separated by tabs.
+	AuditFile string
+}
+
+func (ec *EgressConfig) validate() error {
+	for _, p := range ec.BlockPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("Egress.BlockPatterns: %v", err)
+		}
+	}
+	return nil
+}
+
+// The reverse zones of private address ranges
+var privateReverseZones = func() []string {
+	zones := []string{"10.in-addr.arpa", "168.192.in-addr.arpa", "c.f.ip6.arpa", "d.f.ip6.arpa"}
+	for i := 16; i < 32; i++ {
+		zones = append(zones, strconv.Itoa(i)+".172.in-addr.arpa")
+	}
+	return zones
+}()
+
+// Decides which names may be sent to Investigate, and keeps an audit of
+// the ones which were blocked. A nil policy has only the built-in rules.
+type EgressPolicy struct {
+	domainMatcher
+
+	mu      sync.Mutex
+	audit   io.Writer
+	blocked int
+}
+
+// The start of the Skipped reason of a row whose domain the egress policy
+// blocked, before the rule which blocked it.
+const BlockedReasonPrefix = "blocked: "
+
+// Returned instead of a response for a query about a name the egress
+// policy blocks.
+type EgressError struct {
+	Name string
+	Rule string
+}
+
+func (e *EgressError) Error() string {
+	return fmt.Sprintf("the egress policy blocks %s (%s)", e.Name, e.Rule)
+}
+
+// Builds the config's egress policy. The config has to be valid.
+func (c *Config) newEgressPolicy() *EgressPolicy {
+	p := &EgressPolicy{}
+	if c.Egress == nil {
+		return p
+	}
+	var patterns []*regexp.Regexp
+	for _, pat := range c.Egress.BlockPatterns {
+		patterns = append(patterns, regexp.MustCompile(pat))
+	}
+	p.domainMatcher = newDomainMatcher(c.Egress.BlockSuffixes, patterns)
+	return p
+}
+
+// Returns the egress policy every query made with the config is checked
+// against.
+func (c *Config) EgressPolicy() *EgressPolicy {
+	return c.egress
+}
+
+// Sets where a line is written for each blocked name.
+func (p *EgressPolicy) SetAudit(w io.Writer) {
+	p.mu.Lock()
+	p.audit = w
+	p.mu.Unlock()
+}
+
+// Returns how many names have been blocked.
+func (p *EgressPolicy) Blocked() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.blocked
+}
+
+// Returns the rule which blocks the name from being sent, or "" if it may
+// be sent: e.g. "single-label name", "private reverse zone 10.in-addr.arpa",
+// "non-public TLD corp", "suffix corp.example" or "pattern ^ip-". Blocked
+// names are counted and audited.
+func (p *EgressPolicy) Blocks(name string) string {
+	rule := p.rule(name)
+	if rule != "" && p != nil {
+		p.mu.Lock()
+		p.blocked++
+		if p.audit != nil {
+			fmt.Fprintf(p.audit, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), name, rule)
+		}
+		p.mu.Unlock()
+	}
+	return rule
+}
+
+func (p *EgressPolicy) rule(name string) string {
+	labels := domainLabels(name)
+	if len(labels) < 2 {
+		return "single-label name"
+	}
+	domain := strings.Join(labels, ".")
+
+	for _, zone := range privateReverseZones {
+		if domain == zone || strings.HasSuffix(domain, "."+zone) {
+			return "private reverse zone " + zone
+		}
+	}
+	suffixRulesOnce.Do(loadSuffixRules)
+	if tld := asciiLabel(labels[len(labels)-1]); suffixRules[tld] == 0 {
+		return "non-public TLD " + tld
+	}
+
+	if p == nil {
+		return ""
+	}
+	return p.match(domain)
+}
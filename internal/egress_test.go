@@ -0,0 +1,76 @@
+package domainstats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEgressPolicy(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{Egress: &EgressConfig{
+		BlockSuffixes: []string{"Corp.Example.com."},
+		BlockPatterns: []string{`^ip-[0-9-]+\.`},
+	}}
+	policy := varConfig.newEgressPolicy()
+	audit := &bytes.Buffer{}
+	policy.SetAudit(audit)
+
+	for name, ref := range map[string]string{
+		"fileserver":                        "single-label name",
+		"fileserver.":                       "single-label name",
+		"1.0.168.192.in-addr.arpa":          "private reverse zone 168.192.in-addr.arpa",
+		"4.3.2.20.172.in-addr.arpa":         "private reverse zone 20.172.in-addr.arpa",
+		"4.3.2.32.172.in-addr.arpa":         "",
+		"8.8.8.8.in-addr.arpa":              "",
+		"1.0.0.0.c.f.ip6.arpa":              "private reverse zone c.f.ip6.arpa",
+		"printer.local":                     "non-public TLD local",
+		"dc01.ad.corp":                      "non-public TLD corp",
+		"10.1.2.3":                          "non-public TLD 3",
+		"wiki.corp.example.com":             "suffix corp.example.com",
+		"ip-10-0-0-1.compute.example":       "non-public TLD example",
+		"ip-10-0-0-1.compute.amazonaws.com": `pattern ^ip-[0-9-]+\.`,
+		"www.example.com":                   "",
+		"пример.рф":                         "",
+	} {
+		if test := policy.Blocks(name); test != ref {
+			t.Fatalf("%s: %v != %v", name, ref, test)
+		}
+	}
+
+	if test := policy.Blocked(); test != 11 {
+		t.Fatalf("%v != %v", 11, test)
+	}
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(lines) != 11 {
+		t.Fatalf("%v != %v", 11, len(lines))
+	}
+	for _, line := range lines {
+		if fields := strings.Split(line, "\t"); len(fields) != 3 || fields[2] == "" {
+			t.Fatalf("unexpected audit line %q", line)
+		}
+	}
+}
+
+func TestEgressPolicyQuery(t *testing.T) {
+	t.Parallel()
+	// the built-in rules apply even without a policy, and nothing is sent
+	q := &SecurityQuery{DomainQuery{nil, "intranet", nil}}
+	resp := q.Query()
+	if err, ok := resp.Err.(*EgressError); !ok || err.Rule != "single-label name" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	varConfig := Config{Status: true, Security: SecurityConfig{DGAScore: true},
+		Egress: &EgressConfig{BlockSuffixes: []string{"corp.example.com"}}}
+	varConfig.egress = varConfig.newEgressPolicy()
+	msgs := varConfig.DeriveMessages(nil, "wiki.corp.example.com")
+	if len(msgs) != 2 {
+		t.Fatalf("%v != %v", 2, len(msgs))
+	}
+	for _, msg := range msgs {
+		if _, ok := msg.Q.Query().Err.(*EgressError); !ok {
+			t.Fatalf("%T should have been blocked", msg.Q)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package domainstats
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Follows a file as it grows, like tail -F.
+type follower struct {
+	path   string
+	file   *os.File
+	reader *bufio.Reader
+
+	// how far into the file has been read, to notice it being truncated
+	offset int64
+
+	// the start of a line which hasn't been finished yet
+	partial string
+}
+
+// Sends the lines of the file at path, without their line endings, and
+// then the lines written to it as it grows, checking for more every
+// interval. If the file is rotated (renamed or removed, and created again),
+// the rest of the old file is read and then the new one is followed from
+// its start; if it is truncated, it is followed from its start. The channel
+// is closed once stop is closed.
+func FollowFile(path string, interval time.Duration, stop <-chan struct{}) (<-chan string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &follower{path: path, file: file, reader: bufio.NewReader(file)}
+	lines := make(chan string, 100)
+	go f.run(lines, interval, stop)
+	return lines, nil
+}
+
+func (f *follower) run(lines chan<- string, interval time.Duration, stop <-chan struct{}) {
+	defer close(lines)
+	defer func() { f.file.Close() }()
+
+	for {
+		if !f.sendLines(lines, stop) {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+
+		info, err := os.Stat(f.path)
+		if err != nil {
+			// removed, but not created again yet
+			continue
+		}
+		cur, err := f.file.Stat()
+		switch {
+		case err == nil && os.SameFile(cur, info):
+			if info.Size() < f.offset {
+				if _, err := f.file.Seek(0, io.SeekStart); err == nil {
+					f.reset(f.file)
+				}
+			}
+		default:
+			file, err := os.Open(f.path)
+			if err != nil {
+				continue
+			}
+			// whatever was written to the old file before it was rotated,
+			// including a last line without a line ending
+			if !f.sendLines(lines, stop) || !f.send(lines, stop) {
+				file.Close()
+				return
+			}
+			f.file.Close()
+			f.reset(file)
+		}
+	}
+}
+
+func (f *follower) reset(file *os.File) {
+	f.file = file
+	f.reader = bufio.NewReader(file)
+	f.offset = 0
+	f.partial = ""
+}
+
+// Sends every finished line which can be read now. It returns false if stop
+// was closed.
+func (f *follower) sendLines(lines chan<- string, stop <-chan struct{}) bool {
+	for {
+		line, err := f.reader.ReadString('\n')
+		f.offset += int64(len(line))
+		f.partial += line
+		if err != nil {
+			return true
+		}
+		if !f.send(lines, stop) {
+			return false
+		}
+	}
+}
+
+// Sends the current line, if there is one. It returns false if stop was
+// closed.
+func (f *follower) send(lines chan<- string, stop <-chan struct{}) bool {
+	if f.partial == "" {
+		return true
+	}
+	select {
+	case lines <- strings.TrimRight(f.partial, "\r\n"):
+		f.partial = ""
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Remembers the domains seen within a window of time, so that repeats of
+// them can be skipped.
+type RecentDomains struct {
+	window    time.Duration
+	seen      map[string]time.Time
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// Returns a RecentDomains which remembers domains for the given window. If
+// it isn't positive, nothing is remembered.
+func NewRecentDomains(window time.Duration) *RecentDomains {
+	return &RecentDomains{
+		window:    window,
+		seen:      make(map[string]time.Time),
+		lastPrune: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Reports whether the domain was seen within the window. If it wasn't, it
+// is remembered as seen now. Domains are compared ignoring case and a
+// trailing dot.
+func (r *RecentDomains) Seen(domain string) bool {
+	if r.window <= 0 {
+		return false
+	}
+	now := r.now()
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	// forget domains once they are out of the window, so the map doesn't
+	// grow forever
+	if now.Sub(r.lastPrune) >= r.window {
+		for d, t := range r.seen {
+			if now.Sub(t) >= r.window {
+				delete(r.seen, d)
+			}
+		}
+		r.lastPrune = now
+	}
+
+	if t, ok := r.seen[domain]; ok && now.Sub(t) < r.window {
+		return true
+	}
+	r.seen[domain] = now
+	return false
+}
@@ -0,0 +1,115 @@
+package domainstats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Returns the next line from the follower, failing if there isn't one soon.
+func nextLine(t *testing.T, lines <-chan string) string {
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a line")
+		return ""
+	}
+}
+
+func appendFile(t *testing.T, path, s string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "domains.txt")
+	appendFile(t, path, "a.com\r\nb.com\n")
+
+	stop := make(chan struct{})
+	lines, err := FollowFile(path, 5*time.Millisecond, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := []string{nextLine(t, lines), nextLine(t, lines)}
+
+	// a line is only sent once it is finished
+	appendFile(t, path, "c.c")
+	time.Sleep(20 * time.Millisecond)
+	appendFile(t, path, "om\n")
+	test = append(test, nextLine(t, lines))
+
+	// rotated: the old file's last line is sent, then the new file's lines
+	appendFile(t, path, "d.com")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "e.com\n")
+	test = append(test, nextLine(t, lines), nextLine(t, lines))
+
+	// truncated, then written again
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	appendFile(t, path, "f.com\n")
+	test = append(test, nextLine(t, lines))
+
+	ref := []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com"}
+	if !strSliceEq(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	close(stop)
+	for range lines {
+	}
+}
+
+func TestFollowFileMissing(t *testing.T) {
+	t.Parallel()
+	if _, err := FollowFile(filepath.Join(t.TempDir(), "nope"), time.Second, nil); err == nil {
+		t.Fatal("a missing file should return an error")
+	}
+}
+
+func TestRecentDomains(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, 6, 30, 12, 0, 0, 0, time.UTC)
+	recent := NewRecentDomains(time.Hour)
+	recent.now = func() time.Time { return now }
+	recent.lastPrune = now
+
+	for _, c := range []struct {
+		domain string
+		after  time.Duration
+		ref    bool
+	}{
+		{"a.com", 0, false},
+		{"A.com.", time.Minute, true},
+		{"b.com", 30 * time.Minute, false},
+		// an hour after a.com was first seen, it is enriched again
+		{"a.com", 30 * time.Minute, false},
+		{"b.com", 0, true},
+	} {
+		now = now.Add(c.after)
+		if test := recent.Seen(c.domain); test != c.ref {
+			t.Fatalf("%s: %v != %v", c.domain, c.ref, test)
+		}
+	}
+	if len(recent.seen) != 2 {
+		t.Fatalf("there should be 2 remembered domains: %v", recent.seen)
+	}
+
+	if NewRecentDomains(0).Seen("a.com") || NewRecentDomains(0).Seen("a.com") {
+		t.Fatal("a zero window should not remember anything")
+	}
+}
@@ -0,0 +1,51 @@
+package domainstats
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Matches domains against suffixes and regular expressions, for the Skip
+// and Egress tables and the Exclude options.
+type domainMatcher struct {
+	suffixes []string
+	patterns []*regexp.Regexp
+}
+
+// Returns a matcher for the given suffixes and patterns. Suffixes are
+// normalized like domains, and may start with "*."; empty ones are ignored.
+func newDomainMatcher(suffixes []string, patterns []*regexp.Regexp) domainMatcher {
+	m := domainMatcher{patterns: patterns}
+	for _, s := range suffixes {
+		if s = normalizeDomain(strings.TrimPrefix(strings.TrimSpace(s), "*.")); s != "" {
+			m.suffixes = append(m.suffixes, s)
+		}
+	}
+	return m
+}
+
+// Returns the domain in lower case, without surrounding space or a
+// trailing dot.
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// Returns what the normalized domain matches, e.g. "suffix corp.example"
+// if it's corp.example or under it, or "pattern ^ip-"; or "" if nothing.
+func (m domainMatcher) match(domain string) string {
+	for _, s := range m.suffixes {
+		if domain == s || strings.HasSuffix(domain, "."+s) {
+			return "suffix " + s
+		}
+	}
+	for _, re := range m.patterns {
+		if re.MatchString(domain) {
+			return "pattern " + re.String()
+		}
+	}
+	return ""
+}
+
+func (m domainMatcher) empty() bool {
+	return len(m.suffixes) == 0 && len(m.patterns) == 0
+}
@@ -0,0 +1,392 @@
+package domainstats
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The environment variable which, if set, overrides OpenSearch.Password
+const OpenSearchPasswordEnv = "DOMAINSTATS_OPENSEARCH_PASSWORD"
+
+const (
+	defaultOpenSearchIndex      = "domainstats-{date}"
+	defaultOpenSearchBatchSize  = 500
+	defaultOpenSearchMaxRetries = 3
+)
+
+// Settings for the "opensearch" output format, which also works with
+// Elasticsearch.
+type OpenSearchConfig struct {
+	// The cluster's URL, e.g. "https://localhost:9200". If it is not set,
+	// the bulk requests are written to the output file instead, to be sent
+	// later.
+	URL string
+
+	// The index to write documents to. "{date}" is replaced with the date
+	// of the run, e.g. "2015.06.30", and "{year}", "{month}" and "{day}"
+	// with its parts. Defaults to "domainstats-{date}".
+	Index string
+
+	// Credentials for HTTP basic authentication, if the cluster needs them
+	Username string
+	Password string
+
+	// The number of documents sent in each bulk request; defaults to 500.
+	BatchSize int
+
+	// How many times documents which failed with a temporary error are
+	// sent again; defaults to 3.
+	MaxRetries int
+}
+
+func (osc *OpenSearchConfig) validate() error {
+	if osc.URL != "" {
+		u, err := url.Parse(osc.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("OpenSearch.URL is %q, but should be an http or https URL", osc.URL)
+		}
+	}
+	if osc.BatchSize < 0 {
+		return fmt.Errorf("OpenSearch.BatchSize should not be negative")
+	}
+	if osc.MaxRetries < 0 {
+		return fmt.Errorf("OpenSearch.MaxRetries should not be negative")
+	}
+	return nil
+}
+
+// Returns the OpenSearch settings, with defaults filled in.
+func (c *Config) openSearch() OpenSearchConfig {
+	osc := OpenSearchConfig{}
+	if c.OpenSearch != nil {
+		osc = *c.OpenSearch
+	}
+	if osc.Index == "" {
+		osc.Index = defaultOpenSearchIndex
+	}
+	if osc.BatchSize == 0 {
+		osc.BatchSize = defaultOpenSearchBatchSize
+	}
+	if osc.MaxRetries == 0 {
+		osc.MaxRetries = defaultOpenSearchMaxRetries
+	}
+	if password := os.Getenv(OpenSearchPasswordEnv); password != "" {
+		osc.Password = password
+	}
+	return osc
+}
+
+// Fills in the date placeholders in an index name template.
+func indexName(template string, date time.Time) string {
+	return strings.NewReplacer(
+		"{date}", date.Format("2006.01.02"),
+		"{year}", date.Format("2006"),
+		"{month}", date.Format("01"),
+		"{day}", date.Format("02"),
+	).Replace(template)
+}
+
+// A document waiting to be indexed, already encoded as a bulk request
+// action and source
+type bulkItem struct {
+	id   string
+	body []byte
+}
+
+// Writes a document per domain with the bulk API, either to a cluster, or
+// as NDJSON to a file.
+//
+// Document IDs are made from the domain and the date of the run, so
+// running the same list again on the same day updates the documents rather
+// than duplicating them.
+type openSearchSink struct {
+	conf    OpenSearchConfig
+	index   string
+	runDate time.Time
+	docs    *documentBuilder
+
+	// for writing to a file
+	w io.Writer
+
+	// for sending to a cluster. Documents which couldn't be sent are kept
+	// in the batch, up to twice the batch size, and sent again once retryAt
+	// has passed; dropped counts the ones given up on.
+	client       *http.Client
+	batch        []bulkItem
+	dropped      int
+	retryAt      time.Time
+	checkedIndex bool
+	retryDelay   time.Duration
+	retryAfter   time.Duration
+}
+
+// Returns a sink which indexes rows in OpenSearch or Elasticsearch. If the
+// config sets OpenSearch.URL, documents are sent there in batches, and w is
+// not used; otherwise, bulk requests are written to w as NDJSON.
+func NewOpenSearchSink(w io.Writer, c *Config) Sink {
+	conf := c.openSearch()
+	runDate := time.Now().UTC()
+	return &openSearchSink{
+		conf:       conf,
+		index:      indexName(conf.Index, runDate),
+		runDate:    runDate,
+		docs:       newDocumentBuilder(c),
+		w:          w,
+		client:     &http.Client{Timeout: 60 * time.Second},
+		retryDelay: 500 * time.Millisecond,
+		retryAfter: 10 * time.Second,
+	}
+}
+
+func (s *openSearchSink) WriteRow(row Row) error {
+	doc := s.docs.document(row)
+	doc["@timestamp"] = s.runDate.Format(time.RFC3339)
+
+	id := row.Domain + "_" + s.runDate.Format("2006-01-02")
+	action := map[string]interface{}{
+		"index": map[string]string{"_index": s.index, "_id": id},
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	if err := enc.Encode(action); err != nil {
+		return err
+	}
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	if s.conf.URL == "" {
+		_, err := s.w.Write(body.Bytes())
+		return err
+	}
+
+	s.batch = append(s.batch, bulkItem{id, body.Bytes()})
+	if max := 2 * s.conf.BatchSize; len(s.batch) > max {
+		s.dropped += len(s.batch) - max
+		s.batch = s.batch[len(s.batch)-max:]
+	}
+	if len(s.batch) >= s.conf.BatchSize {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *openSearchSink) Close() error {
+	if s.conf.URL == "" {
+		return nil
+	}
+	var err error
+	if len(s.batch) != 0 {
+		err = s.send()
+	}
+
+	// nothing is sent after this, so documents which are still kept are lost
+	if dropped := s.dropped + len(s.batch); dropped != 0 {
+		if err != nil {
+			return fmt.Errorf("%d document(s) couldn't be indexed, and were dropped: %v", dropped, err)
+		}
+		return fmt.Errorf("%d document(s) couldn't be indexed, and were dropped", dropped)
+	}
+	return err
+}
+
+// Sends the batch, retrying the documents which fail with a temporary
+// error, e.g. because the cluster is overloaded. Documents which still
+// can't be sent are kept, and sent along with a later batch once
+// retryAfter has passed; documents the cluster rejects are dropped.
+func (s *openSearchSink) Flush() error {
+	if len(s.batch) == 0 || time.Now().Before(s.retryAt) {
+		return nil
+	}
+	return s.send()
+}
+
+func (s *openSearchSink) send() error {
+	if !s.checkedIndex {
+		if err := s.createIndex(); err != nil {
+			s.retryAt = time.Now().Add(s.retryAfter)
+			return fmt.Errorf("%v; %d document(s) are kept to send again", err, len(s.batch))
+		}
+		s.checkedIndex = true
+	}
+
+	items := s.batch
+	var failed []string
+	for attempt := 0; ; attempt++ {
+		retry, newFailed, cause, err := s.sendBulk(items)
+		if err != nil {
+			s.dropped += len(items)
+			s.batch = nil
+			return fmt.Errorf("%v; %d document(s) were dropped", err, len(items)+len(failed))
+		}
+		failed = append(failed, newFailed...)
+		s.dropped += len(newFailed)
+		if len(retry) == 0 {
+			break
+		}
+		if attempt >= s.conf.MaxRetries {
+			s.batch = retry
+			s.retryAt = time.Now().Add(s.retryAfter)
+			if cause == nil {
+				cause = errors.New("the cluster was busy")
+			}
+			err := fmt.Errorf("%d document(s) were not indexed: %v (after %d retries); "+
+				"they are kept to send again", len(retry), cause, s.conf.MaxRetries)
+			if len(failed) != 0 {
+				err = fmt.Errorf("%v; %s", err, rejectedDocuments(failed))
+			}
+			return err
+		}
+		items = retry
+		time.Sleep(s.retryDelay << uint(attempt))
+	}
+
+	s.batch = nil
+	s.retryAt = time.Time{}
+	if len(failed) != 0 {
+		return errors.New(rejectedDocuments(failed))
+	}
+	return nil
+}
+
+// Describes the documents the cluster rejected, with the first few reasons.
+func rejectedDocuments(failed []string) string {
+	const maxReasons = 3
+	desc := fmt.Sprintf("%d document(s) were rejected and dropped: ", len(failed))
+	if len(failed) > maxReasons {
+		return desc + strings.Join(failed[:maxReasons], "; ") +
+			fmt.Sprintf("; and %d more", len(failed)-maxReasons)
+	}
+	return desc + strings.Join(failed, "; ")
+}
+
+func (s *openSearchSink) newRequest(method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(s.conf.URL, "/")+path,
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.conf.Username != "" {
+		req.SetBasicAuth(s.conf.Username, s.conf.Password)
+	}
+	return req, nil
+}
+
+// Creates the index with the mapping for the config, unless it exists.
+func (s *openSearchSink) createIndex() error {
+	req, err := s.newRequest("HEAD", "/"+url.PathEscape(s.index), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("checking index %s: %s", s.index, resp.Status)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"mappings": s.docs.mapping()})
+	if err != nil {
+		return err
+	}
+	req, err = s.newRequest("PUT", "/"+url.PathEscape(s.index), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// another run may have created it in the meantime
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK &&
+		!bytes.Contains(respBody, []byte("resource_already_exists_exception")) {
+		return fmt.Errorf("creating index %s: %s: %s", s.index, resp.Status, respBody)
+	}
+	return nil
+}
+
+// The parts of a bulk API response which are needed to find failures
+type bulkResponse struct {
+	Errors bool
+	Items  []map[string]struct {
+		ID     string `json:"_id"`
+		Status int
+		Error  json.RawMessage
+	}
+}
+
+// Sends the items in a single bulk request. Returns the items which should
+// be retried, and descriptions of the ones which failed for good. If the
+// whole request fails temporarily, all of the items are retried, and cause
+// says why.
+func (s *openSearchSink) sendBulk(items []bulkItem) (retry []bulkItem, failed []string, cause, err error) {
+	var body bytes.Buffer
+	for _, item := range items {
+		body.Write(item.body)
+	}
+
+	req, err := s.newRequest("POST", "/_bulk", body.Bytes())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return items, nil, err, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return items, nil, fmt.Errorf("bulk request failed: %s", resp.Status), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, nil, nil, fmt.Errorf("bulk request failed: %s: %s", resp.Status, respBody)
+	}
+
+	var bulkResp bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&bulkResp); err != nil {
+		return nil, nil, nil, fmt.Errorf("reading bulk response: %v", err)
+	}
+	if !bulkResp.Errors {
+		return nil, nil, nil, nil
+	}
+	if len(bulkResp.Items) != len(items) {
+		return nil, nil, nil, fmt.Errorf("bulk response has %d items, but %d were sent",
+			len(bulkResp.Items), len(items))
+	}
+
+	// items in the response are in the same order as in the request
+	for i, result := range bulkResp.Items {
+		for _, r := range result {
+			switch {
+			case r.Status < 300:
+			case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+				retry = append(retry, items[i])
+			default:
+				failed = append(failed, fmt.Sprintf("%s: %s", items[i].id, r.Error))
+			}
+		}
+	}
+	return retry, failed, nil, nil
+}
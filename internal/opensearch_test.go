@@ -0,0 +1,277 @@
+package domainstats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+func TestIndexName(t *testing.T) {
+	t.Parallel()
+	date := time.Date(2015, 6, 30, 12, 0, 0, 0, time.UTC)
+	ref := "domainstats-2015.06.30-2015-06"
+	test := indexName("domainstats-{date}-{year}-{month}", date)
+	if ref != test {
+		t.Fatalf("%v != %v", ref, test)
+	}
+}
+
+func TestIndexMapping(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{Cooccurrences: DomainScoreConfig{Domain: true, Score: true}}
+	varConfig.Security.DGAScore = true
+
+	props := varConfig.IndexMapping()["properties"].(map[string]interface{})
+	ref := map[string]string{
+		"@timestamp":    "date",
+		"Domain":        "keyword",
+		"DGAScore":      "double",
+		"Cooccurrences": "nested",
+	}
+	for name, refType := range ref {
+		test := props[name].(map[string]interface{})["type"]
+		if test != refType {
+			t.Fatalf("%s is mapped as %v, but should be %v", name, test, refType)
+		}
+	}
+}
+
+func TestOpenSearchSinkFile(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{Status: true, OpenSearch: &OpenSearchConfig{Index: "ds-{year}"}}
+	buf := new(bytes.Buffer)
+	sink := NewOpenSearchSink(buf, &varConfig)
+	err := sink.WriteRow(Row{Domain: "a.com", Responses: []interface{}{
+		&goinvestigate.DomainCategorization{Status: -1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("there should be an action and a document line: %q", buf.String())
+	}
+
+	var action map[string]map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &action); err != nil {
+		t.Fatal(err)
+	}
+	year := sink.(*openSearchSink).runDate.Format("2006")
+	if action["index"]["_index"] != "ds-"+year ||
+		!strings.HasPrefix(action["index"]["_id"], "a.com_"+year) {
+		t.Fatalf("unexpected action %v", action)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["Domain"] != "a.com" || doc["Status"] != float64(-1) || doc["@timestamp"] == nil {
+		t.Fatalf("unexpected document %v", doc)
+	}
+}
+
+// A stand-in for a cluster, which fails the first attempt to index each
+// document with the given status.
+type bulkServer struct {
+	sync.Mutex
+	failStatus   int
+	indexCreated bool
+	attempts     map[string]int
+	indexed      []string
+}
+
+func (s *bulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch {
+	case r.Method == "HEAD":
+		if !s.indexCreated {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		s.indexCreated = bytes.Contains(body, []byte(`"mappings"`))
+		w.Write([]byte(`{"acknowledged": true}`))
+	case r.URL.Path == "/_bulk":
+		type result struct {
+			Status int                    `json:"status"`
+			Error  map[string]interface{} `json:"error,omitempty"`
+		}
+		items := []map[string]result{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+
+			id := action["index"]["_id"]
+			s.attempts[id]++
+			if s.attempts[id] == 1 {
+				items = append(items, map[string]result{"index": {s.failStatus,
+					map[string]interface{}{"type": "failure", "reason": "failed"}}})
+			} else {
+				s.indexed = append(s.indexed, id)
+				items = append(items, map[string]result{"index": {Status: 201}})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestOpenSearchSinkRetry(t *testing.T) {
+	t.Parallel()
+	server := &bulkServer{failStatus: http.StatusTooManyRequests, attempts: make(map[string]int)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	varConfig := Config{OpenSearch: &OpenSearchConfig{URL: ts.URL, BatchSize: 2}}
+	sink := NewOpenSearchSink(nil, &varConfig)
+	sink.(*openSearchSink).retryDelay = 0
+
+	for _, domain := range []string{"a.com", "b.com", "c.com"} {
+		if err := sink.WriteRow(Row{Domain: domain}); err != nil {
+			t.Fatal(err)
+		}
+		// flushing sends a partial batch, as -follow does after each row
+		if domain == "a.com" {
+			if err := sink.(Flusher).Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if len(server.indexed) != 1 {
+				t.Fatalf("a.com should have been indexed when flushed: %v", server.indexed)
+			}
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !server.indexCreated {
+		t.Fatal("the index should have been created with a mapping")
+	}
+	if len(server.indexed) != 3 {
+		t.Fatalf("all documents should have been indexed after a retry: %v", server.indexed)
+	}
+}
+
+func TestOpenSearchSinkFailure(t *testing.T) {
+	t.Parallel()
+	server := &bulkServer{failStatus: http.StatusBadRequest, attempts: make(map[string]int)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	varConfig := Config{OpenSearch: &OpenSearchConfig{URL: ts.URL}}
+	sink := NewOpenSearchSink(nil, &varConfig)
+	if err := sink.WriteRow(Row{Domain: "a.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err == nil {
+		t.Fatal("documents which failed with a client error should be reported")
+	}
+	id := "a.com_" + sink.(*openSearchSink).runDate.Format("2006-01-02")
+	if server.attempts[id] != 1 {
+		t.Fatalf("client errors should not be retried: %v", server.attempts)
+	}
+}
+
+func TestOpenSearchSinkTransportError(t *testing.T) {
+	t.Parallel()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			return
+		}
+		// drop the connection without answering
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer ts.Close()
+
+	varConfig := Config{OpenSearch: &OpenSearchConfig{URL: ts.URL, MaxRetries: 1}}
+	sink := NewOpenSearchSink(nil, &varConfig)
+	sink.(*openSearchSink).retryDelay = 0
+	if err := sink.WriteRow(Row{Domain: "a.com"}); err != nil {
+		t.Fatal(err)
+	}
+	err := sink.Close()
+	if err == nil || !strings.Contains(err.Error(), "/_bulk") {
+		t.Fatalf("the error should say why the request failed: %v", err)
+	}
+}
+
+func TestOpenSearchSinkFailedBatches(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	bulkRequests := 0
+	indexed := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// a busy cluster for both attempts of the first request, then a
+		// rejected request
+		bulkRequests++
+		switch bulkRequests {
+		case 1, 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case 3:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]string
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+			indexed = append(indexed, action["index"]["_id"])
+		}
+		w.Write([]byte(`{"errors": false, "items": []}`))
+	}))
+	defer ts.Close()
+
+	varConfig := Config{OpenSearch: &OpenSearchConfig{URL: ts.URL, MaxRetries: 1}}
+	sink := NewOpenSearchSink(nil, &varConfig)
+	sink.(*openSearchSink).retryDelay = 0
+	sink.(*openSearchSink).retryAfter = 0
+	day := "_" + sink.(*openSearchSink).runDate.Format("2006-01-02")
+
+	// the busy cluster's batch is kept
+	sink.WriteRow(Row{Domain: "a.com"})
+	if err := sink.(Flusher).Flush(); err == nil {
+		t.Fatal("a batch which couldn't be sent should return an error")
+	}
+	// and the rejected one, sent along with it, is dropped
+	sink.WriteRow(Row{Domain: "b.com"})
+	if err := sink.(Flusher).Flush(); err == nil {
+		t.Fatal("a rejected batch should return an error")
+	}
+	sink.WriteRow(Row{Domain: "c.com"})
+	err := sink.Close()
+	if err == nil || !strings.Contains(err.Error(), "2 document(s)") {
+		t.Fatalf("the dropped documents should be reported: %v", err)
+	}
+	if ref := []string{"c.com" + day}; !strSliceEq(ref, indexed) {
+		t.Fatalf("%v != %v", ref, indexed)
+	}
+}
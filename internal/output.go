@@ -0,0 +1,239 @@
+package domainstats
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// The results for a single domain, tagged with the line number of the
+// domain in the input file it was generated from.
+//
+// Fields holds the CSV row built from the config's enabled fields; see
+// ProjectRow. Responses holds the goinvestigate responses the row was
+// built from, in the order the queries were made, for sinks which want
+// typed data. Fields is nil if the domain was dropped because one of its
+// queries failed, and Err is why. Queries holds how often the domain was
+// queried, if it was read from a resolver log. Skipped is why the domain
+// wasn't queried, if it was on a skip list; such rows have no responses.
+type Row struct {
+	Line      int
+	Domain    string
+	Fields    []string
+	Responses []interface{}
+	Queries   *QueryStats
+	Skipped   string
+	Err       error
+}
+
+// A Sink is where output rows are written to.
+type Sink interface {
+	// Writes the results for a single domain. Domains whose queries failed
+	// are never written.
+	WriteRow(row Row) error
+
+	// Writes out anything still buffered. The sink can't be used afterwards.
+	// Closing the underlying writer, if needed, is up to the caller.
+	Close() error
+}
+
+// The output formats which NewSink supports
+var OutputFormats = []string{"tsv", "xlsx", "parquet", "opensearch", "splunk", "syslog"}
+
+// Returns an error if the given output format is not supported.
+func CheckOutputFormat(format string) error {
+	for _, f := range OutputFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q (formats: %s)",
+		format, strings.Join(OutputFormats, ", "))
+}
+
+// Reports whether the given format sends rows somewhere other than the
+// output file, with the config's settings. Such formats don't need an
+// output file.
+func (c *Config) RemoteFormat(format string) bool {
+	switch format {
+	case "opensearch":
+		return c.OpenSearch != nil && c.OpenSearch.URL != ""
+	case "splunk", "syslog":
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns a sink which writes rows to w in the given format. For remote
+// formats (see RemoteFormat), w may be nil.
+func NewSink(format string, w io.Writer, c *Config) (Sink, error) {
+	switch format {
+	case "tsv":
+		return NewTSVSink(w, c), nil
+	case "xlsx":
+		return NewXLSXSink(w, c), nil
+	case "parquet":
+		return NewParquetSink(w, c), nil
+	case "opensearch":
+		return NewOpenSearchSink(w, c), nil
+	case "splunk":
+		return NewSplunkSink(c)
+	case "syslog":
+		return NewSyslogSink(c)
+	default:
+		return nil, CheckOutputFormat(format)
+	}
+}
+
+// Implemented by sinks which buffer rows before writing them, to write out
+// the rows so far.
+type Flusher interface {
+	Flush() error
+}
+
+// Reports whether a request a sink made failed in a way which may succeed
+// later: it ran out of retries, or the server was busy.
+func retryableSendError(err error) bool {
+	switch err := err.(type) {
+	case *goinvestigate.RetryError:
+		return true
+	case *goinvestigate.StatusError:
+		return err.Temporary() || err.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// Returns a sink which writes each row to all of the given sinks. Nil
+// sinks are skipped; if there are none left, it returns nil.
+func MultiSink(sinks ...Sink) Sink {
+	ms := multiSink{}
+	for _, s := range sinks {
+		if s != nil {
+			ms = append(ms, s)
+		}
+	}
+	switch len(ms) {
+	case 0:
+		return nil
+	case 1:
+		return ms[0]
+	default:
+		return ms
+	}
+}
+
+type multiSink []Sink
+
+// Writes the row to every sink, even if one of them fails, and returns the
+// first error.
+func (ms multiSink) WriteRow(row Row) error {
+	var first error
+	for _, s := range ms {
+		if err := s.WriteRow(row); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ms multiSink) Flush() error {
+	var first error
+	for _, s := range ms {
+		if f, ok := s.(Flusher); ok {
+			if err := f.Flush(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func (ms multiSink) Close() error {
+	var first error
+	for _, s := range ms {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// The type of the values in a column
+type ColumnType int
+
+const (
+	StringColumn ColumnType = iota
+	IntColumn
+	FloatColumn
+	BoolColumn
+)
+
+// A column of the output, as returned by OutputColumns
+type OutputColumn struct {
+	Path   string
+	Header string
+	Type   ColumnType
+}
+
+// Returns the columns of the output, in the order they are written, with
+// the type of their values. The values of a row from ProjectRow match up
+// with these columns.
+func (c *Config) OutputColumns() []OutputColumn {
+	cols := c.columns()
+	if c.selected != nil {
+		paths := make(map[int]string)
+		for i, col := range cols {
+			paths[i] = col.Path
+		}
+		cols = []column{}
+		for _, sc := range c.selected {
+			cols = append(cols, column{paths[sc.Index], sc.Header})
+		}
+	}
+
+	outCols := []OutputColumn{}
+	for _, col := range cols {
+		outCols = append(outCols, OutputColumn{col.Path, col.Header, columnType(col.Path)})
+	}
+	return outCols
+}
+
+// Returns the type of the values in the column at the given path, based on
+// the type of the field in the goinvestigate response it comes from.
+// Fields which are lists are written as strings.
+func columnType(path string) ColumnType {
+	var respType reflect.Type
+	var field string
+	switch {
+	case path == "Line" || path == "Status" || path == "QueryLog.QueryCount":
+		return IntColumn
+	case strings.HasPrefix(path, "Security."):
+		respType = reflect.TypeOf(goinvestigate.SecurityFeatures{})
+		field = strings.TrimPrefix(path, "Security.")
+	case strings.HasPrefix(path, "DomainRRHistory.Features."):
+		respType = reflect.TypeOf(goinvestigate.DomainResourceRecordFeatures{})
+		field = strings.TrimPrefix(path, "DomainRRHistory.Features.")
+	default:
+		return StringColumn
+	}
+
+	f, ok := respType.FieldByName(field)
+	if !ok {
+		return StringColumn
+	}
+	switch f.Type.Kind() {
+	case reflect.Int:
+		return IntColumn
+	case reflect.Float64:
+		return FloatColumn
+	case reflect.Bool:
+		return BoolColumn
+	default:
+		return StringColumn
+	}
+}
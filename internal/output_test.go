@@ -0,0 +1,76 @@
+package domainstats
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestColumnType(t *testing.T) {
+	t.Parallel()
+	ref := map[string]ColumnType{
+		"Domain":                               StringColumn,
+		"Status":                               IntColumn,
+		"Line":                                 IntColumn,
+		"Security.DGAScore":                    FloatColumn,
+		"Security.Fastflux":                    BoolColumn,
+		"Security.ThreatType":                  StringColumn,
+		"Security.GeodiversityNormalized":      StringColumn,
+		"DomainRRHistory.Features.Age":         IntColumn,
+		"DomainRRHistory.Features.ASNs":        StringColumn,
+		"DomainRRHistory.Features.TTLsMean":    FloatColumn,
+		"DomainRRHistory.Features.NonRoutable": BoolColumn,
+		"Cooccurrences":                        StringColumn,
+	}
+	for path, refType := range ref {
+		if test := columnType(path); test != refType {
+			t.Fatalf("columnType(%q) = %v, but should = %v", path, test, refType)
+		}
+	}
+}
+
+func TestOutputColumns(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{Status: true, Columns: []string{"Security.DGAScore as DGA", "Domain"}}
+	if err := varConfig.resolveColumns(); err != nil {
+		t.Fatal(err)
+	}
+
+	test := varConfig.OutputColumns()
+	ref := []OutputColumn{
+		{"Security.DGAScore", "DGA", FloatColumn},
+		{"Domain", "Domain", StringColumn},
+	}
+	if len(test) != len(ref) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+	for i := range ref {
+		if test[i] != ref[i] {
+			t.Fatalf("%v != %v", ref, test)
+		}
+	}
+}
+
+func TestTSVSink(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{Status: true}
+	buf := new(bytes.Buffer)
+	sink, err := NewSink("tsv", buf, &varConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteRow(Row{Line: 1, Domain: "a.com", Fields: []string{"a.com", "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ref := "Domain\tStatus\na.com\t1\n"
+	if buf.String() != ref {
+		t.Fatalf("%q != %q", ref, buf.String())
+	}
+
+	if _, err := NewSink("json", buf, &varConfig); err == nil {
+		t.Fatal("unknown formats should return an error")
+	}
+}
@@ -0,0 +1,732 @@
+package domainstats
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// The number of rows buffered in memory before they are written out as a
+// row group
+const parquetRowGroupRows = 10000
+
+// A node of a Parquet schema. Leaves have a physical Type; groups have
+// Children instead.
+//
+// Values are given to the schema as Go values: bool, int64, float64 or
+// string for leaves, []interface{} of the children's values for groups,
+// and []interface{} of the items for repeated nodes. nil is null.
+type parquetNode struct {
+	Name          string
+	Repetition    int32
+	Type          int32
+	ConvertedType int32
+	Children      []*parquetNode
+
+	// the columns under this node, and the repetition level of its values
+	leaves   []*parquetLeaf
+	repLevel int
+}
+
+// The values of a single column of the file, with their repetition and
+// definition levels, for the row group being built
+type parquetLeaf struct {
+	node   *parquetNode
+	path   []string
+	maxDef int
+	maxRep int
+
+	values    []interface{}
+	repLevels []int
+	defLevels []int
+}
+
+func (l *parquetLeaf) add(value interface{}, rep, def int) {
+	l.repLevels = append(l.repLevels, rep)
+	l.defLevels = append(l.defLevels, def)
+	if def == l.maxDef {
+		l.values = append(l.values, value)
+	}
+}
+
+func (l *parquetLeaf) reset() {
+	l.values, l.repLevels, l.defLevels = nil, nil, nil
+}
+
+func parquetLeafNode(name string, rep, typ int32) *parquetNode {
+	n := &parquetNode{Name: name, Repetition: rep, Type: typ, ConvertedType: parquetNoConvertedType}
+	if typ == parquetByteArray {
+		n.ConvertedType = parquetUTF8
+	}
+	return n
+}
+
+func parquetGroupNode(name string, rep int32, children ...*parquetNode) *parquetNode {
+	return &parquetNode{Name: name, Repetition: rep, ConvertedType: parquetNoConvertedType,
+		Children: children}
+}
+
+// Returns a node for a list of elem, with the standard three-level
+// structure. Its values are built with parquetListValue.
+func parquetListNode(name string, rep int32, elem *parquetNode) *parquetNode {
+	elem.Name = "element"
+	list := parquetGroupNode("list", parquetRepeated, elem)
+	return &parquetNode{Name: name, Repetition: rep, ConvertedType: parquetList,
+		Children: []*parquetNode{list}}
+}
+
+// Returns the value of a list node with the given elements
+func parquetListValue(elems []interface{}) interface{} {
+	items := make([]interface{}, len(elems))
+	for i, elem := range elems {
+		items[i] = []interface{}{elem}
+	}
+	return []interface{}{items}
+}
+
+// Returns a node for values of the given Go type. Structs become groups,
+// and slices become lists.
+func parquetNodeForType(name string, rep int32, t reflect.Type) *parquetNode {
+	switch t.Kind() {
+	case reflect.Bool:
+		return parquetLeafNode(name, rep, parquetBoolean)
+	case reflect.Int, reflect.Int64:
+		return parquetLeafNode(name, rep, parquetInt64)
+	case reflect.Float64:
+		return parquetLeafNode(name, rep, parquetDouble)
+	case reflect.Slice:
+		return parquetListNode(name, rep, parquetNodeForType("element", parquetRequired, t.Elem()))
+	case reflect.Struct:
+		group := parquetGroupNode(name, rep)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			group.Children = append(group.Children,
+				parquetNodeForType(f.Name, parquetRequired, f.Type))
+		}
+		return group
+	default:
+		return parquetLeafNode(name, rep, parquetByteArray)
+	}
+}
+
+// Returns the value of v for the node returned by parquetNodeForType.
+func parquetValueOf(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int64:
+		return v.Int()
+	case reflect.Float64:
+		return v.Float()
+	case reflect.Slice:
+		elems := make([]interface{}, v.Len())
+		for i := range elems {
+			elems[i] = parquetValueOf(v.Index(i))
+		}
+		return parquetListValue(elems)
+	case reflect.Struct:
+		fields := make([]interface{}, v.NumField())
+		for i := range fields {
+			fields[i] = parquetValueOf(v.Field(i))
+		}
+		return fields
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// Sets up the levels and columns of the schema rooted at n.
+func (n *parquetNode) build(path []string, maxDef, maxRep int) []*parquetLeaf {
+	if n.Repetition != parquetRequired {
+		maxDef++
+	}
+	if n.Repetition == parquetRepeated {
+		maxRep++
+	}
+	n.repLevel = maxRep
+
+	if n.Children == nil {
+		n.leaves = []*parquetLeaf{{node: n, path: path, maxDef: maxDef, maxRep: maxRep}}
+		return n.leaves
+	}
+
+	for _, child := range n.Children {
+		childPath := append(append([]string{}, path...), child.Name)
+		n.leaves = append(n.leaves, child.build(childPath, maxDef, maxRep)...)
+	}
+	return n.leaves
+}
+
+// Adds a value of this node to its columns, starting at the given
+// repetition level, with the given definition level of its parent.
+func (n *parquetNode) shred(value interface{}, rep, def int) {
+	switch n.Repetition {
+	case parquetRepeated:
+		items, _ := value.([]interface{})
+		if len(items) == 0 {
+			n.null(rep, def)
+			return
+		}
+		for i, item := range items {
+			if i > 0 {
+				rep = n.repLevel
+			}
+			n.shredPresent(item, rep, def+1)
+		}
+	case parquetOptional:
+		if value == nil {
+			n.null(rep, def)
+			return
+		}
+		n.shredPresent(value, rep, def+1)
+	default:
+		n.shredPresent(value, rep, def)
+	}
+}
+
+func (n *parquetNode) shredPresent(value interface{}, rep, def int) {
+	if n.Children == nil {
+		n.leaves[0].add(value, rep, def)
+		return
+	}
+	fields := value.([]interface{})
+	for i, child := range n.Children {
+		child.shred(fields[i], rep, def)
+	}
+}
+
+func (n *parquetNode) null(rep, def int) {
+	for _, leaf := range n.leaves {
+		leaf.add(nil, rep, def)
+	}
+}
+
+// A top-level field of the file, and how to get its value from a row
+type parquetField struct {
+	node  *parquetNode
+	value func(row Row) interface{}
+}
+
+// Returns the top-level fields for the config's output columns. Unlike
+// the TSV output, list-valued fields are written as lists, and structured
+// results, like RR periods, as lists of groups.
+func (c *Config) parquetFields() []parquetField {
+	fields := []parquetField{}
+	for _, col := range c.OutputColumns() {
+		name := parquetName(col.Header)
+		var field parquetField
+		switch {
+		case col.Path == "Domain":
+			field = parquetField{parquetLeafNode(name, parquetRequired, parquetByteArray),
+				func(row Row) interface{} { return row.Domain }}
+		case col.Path == "Line":
+			field = parquetField{parquetLeafNode(name, parquetRequired, parquetInt64),
+				func(row Row) interface{} { return int64(row.Line) }}
+		case col.Path == "RegisteredDomain" || col.Path == "TLD":
+			suffix := RegisteredDomain
+			if col.Path == "TLD" {
+				suffix = PublicSuffix
+			}
+			field = parquetField{parquetLeafNode(name, parquetOptional, parquetByteArray),
+				func(row Row) interface{} {
+					if s := suffix(row.Domain); s != "" {
+						return s
+					}
+					return nil
+				}}
+		case strings.HasPrefix(col.Path, "QueryLog."):
+			field = queryStatsParquetField(name, strings.TrimPrefix(col.Path, "QueryLog."))
+		case col.Path == "Skipped":
+			field = parquetField{parquetLeafNode(name, parquetOptional, parquetByteArray),
+				func(row Row) interface{} {
+					if row.Skipped == "" {
+						return nil
+					}
+					return row.Skipped
+				}}
+		case col.Path == "Status":
+			field = reflectParquetField(name,
+				reflect.TypeOf(&goinvestigate.DomainCategorization{}), "Status")
+		case strings.HasPrefix(col.Path, "Categories."):
+			field = reflectParquetField(name, reflect.TypeOf(&goinvestigate.DomainCategorization{}),
+				strings.TrimPrefix(col.Path, "Categories."))
+		case col.Path == "Cooccurrences":
+			field = scoredDomainsParquetField(name, c.Cooccurrences, func(row Row) ([]scoredDomain, bool) {
+				resp, ok := findResponse(row, []goinvestigate.Cooccurrence(nil)).([]goinvestigate.Cooccurrence)
+				return cooccurrenceResults(resp), ok
+			})
+		case col.Path == "Related":
+			field = scoredDomainsParquetField(name, c.Related, func(row Row) ([]scoredDomain, bool) {
+				resp, ok := findResponse(row, []goinvestigate.RelatedDomain(nil)).([]goinvestigate.RelatedDomain)
+				return relatedDomainResults(resp), ok
+			})
+		case strings.HasPrefix(col.Path, "Security."):
+			field = reflectParquetField(name, reflect.TypeOf(&goinvestigate.SecurityFeatures{}),
+				strings.TrimPrefix(col.Path, "Security."))
+		case col.Path == "TaggingDates":
+			field = c.tagsParquetField(name)
+		case col.Path == "DomainRRHistory.Periods":
+			field = c.rrPeriodsParquetField(name)
+		case strings.HasPrefix(col.Path, "DomainRRHistory.Features."):
+			field = reflectParquetField(name, reflect.TypeOf(&goinvestigate.DomainRRHistory{}),
+				"RRFeatures", strings.TrimPrefix(col.Path, "DomainRRHistory.Features."))
+		case strings.HasPrefix(col.Path, "Whois."):
+			field = c.whoisParquetField(name, strings.TrimPrefix(col.Path, "Whois."))
+		default:
+			provider, index, ok := c.providerColumn(col.Path)
+			if !ok {
+				continue
+			}
+			field = providerParquetField(name, provider, index)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// Parquet readers are picky about column names, so anything but letters,
+// digits and underscores is replaced with an underscore.
+func parquetName(header string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return '_'
+	}, header)
+}
+
+// Returns the row's response with the same type as example, or nil if
+// there is none.
+func findResponse(row Row, example interface{}) interface{} {
+	for _, resp := range row.Responses {
+		if reflect.TypeOf(resp) == reflect.TypeOf(example) {
+			return resp
+		}
+	}
+	return nil
+}
+
+// Returns a field for a field of a response struct, found by following
+// fieldPath from the response, with a schema based on the field's type.
+// The field is null if there is no response of that type.
+func reflectParquetField(name string, respType reflect.Type, fieldPath ...string) parquetField {
+	t := respType.Elem()
+	for _, f := range fieldPath {
+		sf, _ := t.FieldByName(f)
+		t = sf.Type
+	}
+
+	return parquetField{parquetNodeForType(name, parquetOptional, t), func(row Row) interface{} {
+		resp := reflect.ValueOf(findResponse(row, reflect.Zero(respType).Interface()))
+		if !resp.IsValid() || resp.IsNil() {
+			return nil
+		}
+		v := resp.Elem()
+		for _, f := range fieldPath {
+			v = v.FieldByName(f)
+		}
+		return parquetValueOf(v)
+	}}
+}
+
+// Returns a field for one of the Whois columns. Empty strings are null, and
+// the columns with several values are lists.
+func (c *Config) whoisParquetField(name, column string) parquetField {
+	wc := c.Whois
+	isList := false
+	switch column {
+	case "NameServers", "Emails", "EmailDomains":
+		isList = true
+	}
+
+	node := parquetLeafNode(name, parquetOptional, parquetByteArray)
+	if isList {
+		node = parquetListNode(name, parquetOptional,
+			parquetLeafNode("element", parquetRequired, parquetByteArray))
+	}
+	return parquetField{node, func(row Row) interface{} {
+		resp, ok := findResponse(row, (*WhoisResponse)(nil)).(*WhoisResponse)
+		if !ok || resp == nil {
+			return nil
+		}
+		switch v := wc.value(resp, column).(type) {
+		case []string:
+			elems := []interface{}{}
+			for _, s := range v {
+				elems = append(elems, s)
+			}
+			return parquetListValue(elems)
+		case string:
+			if v != "" {
+				return v
+			}
+		}
+		return nil
+	}}
+}
+
+// Returns a field for one of a provider's columns, from the index'th of
+// the fields in its response. Empty fields are null.
+func providerParquetField(name, provider string, index int) parquetField {
+	return parquetField{parquetLeafNode(name, parquetOptional, parquetByteArray),
+		func(row Row) interface{} {
+			for _, resp := range row.Responses {
+				if pr, ok := resp.(*ProviderResponse); ok && pr.Provider == provider &&
+					index < len(pr.Fields) && pr.Fields[index] != "" {
+					return pr.Fields[index]
+				}
+			}
+			return nil
+		}}
+}
+
+// Returns a field for one of the QueryLog columns. Times are written as
+// timestamps, in milliseconds since the epoch.
+func queryStatsParquetField(name, stat string) parquetField {
+	if stat == "QueryCount" {
+		return parquetField{parquetLeafNode(name, parquetOptional, parquetInt64),
+			func(row Row) interface{} {
+				if row.Queries == nil {
+					return nil
+				}
+				return int64(row.Queries.Count)
+			}}
+	}
+
+	node := parquetLeafNode(name, parquetOptional, parquetInt64)
+	node.ConvertedType = parquetTimestampMillis
+	return parquetField{node, func(row Row) interface{} {
+		if row.Queries == nil {
+			return nil
+		}
+		t := row.Queries.FirstSeen
+		if stat == "LastSeen" {
+			t = row.Queries.LastSeen
+		}
+		if t.IsZero() {
+			return nil
+		}
+		return t.UnixNano() / int64(time.Millisecond)
+	}}
+}
+
+// Returns a field for cooccurrences or related domains: a list of groups
+// with the domain and score, whichever are enabled, after filtering.
+func scoredDomainsParquetField(name string, dsc DomainScoreConfig,
+	results func(row Row) ([]scoredDomain, bool)) parquetField {
+	elem := parquetGroupNode("element", parquetRequired)
+	if dsc.Domain {
+		elem.Children = append(elem.Children, parquetLeafNode("Domain", parquetRequired, parquetByteArray))
+	}
+	if dsc.Score {
+		elem.Children = append(elem.Children, parquetLeafNode("Score", parquetRequired, parquetDouble))
+	}
+
+	return parquetField{parquetListNode(name, parquetOptional, elem), func(row Row) interface{} {
+		sds, ok := results(row)
+		if !ok {
+			return nil
+		}
+		elems := []interface{}{}
+		for _, sd := range dsc.filter(sds) {
+			fields := []interface{}{}
+			if dsc.Domain {
+				fields = append(fields, sd.Domain)
+			}
+			if dsc.Score {
+				fields = append(fields, sd.Score)
+			}
+			elems = append(elems, fields)
+		}
+		return parquetListValue(elems)
+	}}
+}
+
+func (c *Config) tagsParquetField(name string) parquetField {
+	tdc := c.TaggingDates
+	elem := parquetGroupNode("element", parquetRequired)
+	for _, f := range []struct {
+		name string
+		cond bool
+	}{{"Begin", tdc.Begin}, {"End", tdc.End}, {"Category", tdc.Category}, {"Url", tdc.Url}} {
+		if f.cond {
+			elem.Children = append(elem.Children, parquetLeafNode(f.name, parquetRequired, parquetByteArray))
+		}
+	}
+
+	return parquetField{parquetListNode(name, parquetOptional, elem), func(row Row) interface{} {
+		resp, ok := findResponse(row, []goinvestigate.DomainTag(nil)).([]goinvestigate.DomainTag)
+		if !ok {
+			return nil
+		}
+		elems := []interface{}{}
+		for _, dt := range resp {
+			fields := []interface{}{}
+			fields = appendValueIf(fields, dt.Period.Begin, tdc.Begin)
+			fields = appendValueIf(fields, dt.Period.End, tdc.End)
+			fields = appendValueIf(fields, dt.Category, tdc.Category)
+			fields = appendValueIf(fields, dt.Url, tdc.Url)
+			elems = append(elems, fields)
+		}
+		return parquetListValue(elems)
+	}}
+}
+
+// Returns a field for the RR periods: a list of periods, each with a list
+// of their resource records.
+func (c *Config) rrPeriodsParquetField(name string) parquetField {
+	pc := c.DomainRRHistory.Periods
+	rrElem := parquetGroupNode("element", parquetRequired)
+	for _, f := range []struct {
+		name string
+		typ  int32
+		cond bool
+	}{
+		{"Name", parquetByteArray, pc.Name},
+		{"TTL", parquetInt64, pc.TTL},
+		{"Class", parquetByteArray, pc.Class},
+		{"Type", parquetByteArray, pc.Type},
+		{"RR", parquetByteArray, pc.RR},
+	} {
+		if f.cond {
+			rrElem.Children = append(rrElem.Children, parquetLeafNode(f.name, parquetRequired, f.typ))
+		}
+	}
+	hasRRs := len(rrElem.Children) != 0
+
+	elem := parquetGroupNode("element", parquetRequired)
+	if pc.FirstSeen {
+		elem.Children = append(elem.Children, parquetLeafNode("FirstSeen", parquetRequired, parquetByteArray))
+	}
+	if pc.LastSeen {
+		elem.Children = append(elem.Children, parquetLeafNode("LastSeen", parquetRequired, parquetByteArray))
+	}
+	if hasRRs {
+		elem.Children = append(elem.Children, parquetListNode("RRs", parquetRequired, rrElem))
+	}
+
+	return parquetField{parquetListNode(name, parquetOptional, elem), func(row Row) interface{} {
+		resp, ok := findResponse(row, (*goinvestigate.DomainRRHistory)(nil)).(*goinvestigate.DomainRRHistory)
+		if !ok || resp == nil {
+			return nil
+		}
+		periods := []interface{}{}
+		for _, p := range resp.RRPeriods {
+			fields := []interface{}{}
+			fields = appendValueIf(fields, p.FirstSeen, pc.FirstSeen)
+			fields = appendValueIf(fields, p.LastSeen, pc.LastSeen)
+			if hasRRs {
+				rrs := []interface{}{}
+				for _, rr := range p.RRs {
+					rrFields := []interface{}{}
+					rrFields = appendValueIf(rrFields, rr.Name, pc.Name)
+					rrFields = appendValueIf(rrFields, int64(rr.TTL), pc.TTL)
+					rrFields = appendValueIf(rrFields, rr.Class, pc.Class)
+					rrFields = appendValueIf(rrFields, rr.Type, pc.Type)
+					rrFields = appendValueIf(rrFields, rr.RR, pc.RR)
+					rrs = append(rrs, rrFields)
+				}
+				fields = append(fields, parquetListValue(rrs))
+			}
+			periods = append(periods, fields)
+		}
+		return parquetListValue(periods)
+	}}
+}
+
+func appendValueIf(values []interface{}, value interface{}, cond bool) []interface{} {
+	if cond {
+		return append(values, value)
+	}
+	return values
+}
+
+// the metadata of a column chunk which has been written out
+type parquetChunk struct {
+	leaf      *parquetLeaf
+	offset    int64
+	numValues int
+	size      int
+}
+
+type parquetRowGroup struct {
+	chunks  []parquetChunk
+	numRows int
+	size    int64
+}
+
+// Writes rows as a Parquet file, with a schema built from the config's
+// output columns. Rows are buffered in memory, and written out as a row
+// group every parquetRowGroupRows rows; the file metadata is written when
+// the sink is closed. Column chunks are not compressed.
+type parquetSink struct {
+	w      io.Writer
+	offset int64
+	err    error
+
+	fields []parquetField
+	root   *parquetNode
+	leaves []*parquetLeaf
+
+	numRows   int
+	totalRows int64
+	rowGroups []parquetRowGroup
+}
+
+// Returns a sink which writes rows to a Parquet file.
+func NewParquetSink(w io.Writer, c *Config) Sink {
+	s := &parquetSink{w: w, fields: c.parquetFields()}
+	s.root = parquetGroupNode("schema", parquetRequired)
+	for _, f := range s.fields {
+		s.root.Children = append(s.root.Children, f.node)
+	}
+	s.leaves = s.root.build(nil, 0, 0)
+	s.write([]byte(parquetMagic))
+	return s
+}
+
+// Writes to the underlying writer, keeping track of the offset. After an
+// error, nothing more is written.
+func (s *parquetSink) write(b []byte) {
+	if s.err != nil {
+		return
+	}
+	n, err := s.w.Write(b)
+	s.offset += int64(n)
+	s.err = err
+}
+
+func (s *parquetSink) WriteRow(row Row) error {
+	values := make([]interface{}, len(s.fields))
+	for i, f := range s.fields {
+		values[i] = f.value(row)
+	}
+	s.root.shredPresent(values, 0, 0)
+
+	s.numRows++
+	if s.numRows >= parquetRowGroupRows {
+		s.flushRowGroup()
+	}
+	return s.err
+}
+
+func (s *parquetSink) flushRowGroup() {
+	if s.numRows == 0 {
+		return
+	}
+
+	rg := parquetRowGroup{numRows: s.numRows}
+	for _, leaf := range s.leaves {
+		page := []byte{}
+		if leaf.maxRep > 0 {
+			page = append(page, encodeLevels(leaf.repLevels, leaf.maxRep)...)
+		}
+		if leaf.maxDef > 0 {
+			page = append(page, encodeLevels(leaf.defLevels, leaf.maxDef)...)
+		}
+		page = append(page, encodePlain(leaf.node.Type, leaf.values)...)
+
+		header := newThriftWriter()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structBegin(5)
+		header.i32(1, int32(len(leaf.defLevels)))
+		header.i32(2, parquetPlainEncoding)
+		header.i32(3, parquetRLEEncoding)
+		header.i32(4, parquetRLEEncoding)
+		header.structEnd()
+		header.structEnd()
+
+		chunk := parquetChunk{leaf, s.offset, len(leaf.defLevels), header.buf.Len() + len(page)}
+		s.write(header.buf.Bytes())
+		s.write(page)
+		rg.chunks = append(rg.chunks, chunk)
+		rg.size += int64(chunk.size)
+		leaf.reset()
+	}
+
+	s.rowGroups = append(s.rowGroups, rg)
+	s.totalRows += int64(s.numRows)
+	s.numRows = 0
+}
+
+func (s *parquetSink) Close() error {
+	s.flushRowGroup()
+
+	footer := newThriftWriter()
+	footer.i32(1, 1)
+
+	schema := []*parquetNode{}
+	var walk func(n *parquetNode)
+	walk = func(n *parquetNode) {
+		schema = append(schema, n)
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(s.root)
+	footer.list(2, thriftStruct, len(schema))
+	for _, n := range schema {
+		footer.listStruct()
+		if n.Children == nil {
+			footer.i32(1, n.Type)
+		}
+		if n != s.root {
+			footer.i32(3, n.Repetition)
+		}
+		footer.binary(4, n.Name)
+		if n.Children != nil {
+			footer.i32(5, int32(len(n.Children)))
+		}
+		if n.ConvertedType != parquetNoConvertedType {
+			footer.i32(6, n.ConvertedType)
+		}
+		footer.structEnd()
+	}
+
+	footer.i64(3, s.totalRows)
+
+	footer.list(4, thriftStruct, len(s.rowGroups))
+	for _, rg := range s.rowGroups {
+		footer.listStruct()
+		footer.list(1, thriftStruct, len(rg.chunks))
+		for _, chunk := range rg.chunks {
+			footer.listStruct()
+			footer.i64(2, chunk.offset)
+			footer.structBegin(3)
+			footer.i32(1, chunk.leaf.node.Type)
+			footer.list(2, thriftI32, 2)
+			footer.listI32(parquetPlainEncoding)
+			footer.listI32(parquetRLEEncoding)
+			footer.list(3, thriftBinary, len(chunk.leaf.path))
+			for _, p := range chunk.leaf.path {
+				footer.listString(p)
+			}
+			footer.i32(4, 0) // uncompressed
+			footer.i64(5, int64(chunk.numValues))
+			footer.i64(6, int64(chunk.size))
+			footer.i64(7, int64(chunk.size))
+			footer.i64(9, chunk.offset)
+			footer.structEnd()
+			footer.structEnd()
+		}
+		footer.i64(2, rg.size)
+		footer.i64(3, int64(rg.numRows))
+		footer.structEnd()
+	}
+
+	footer.binary(6, "domainstats")
+	footer.structEnd()
+
+	length := footer.buf.Len()
+	s.write(footer.buf.Bytes())
+	s.write([]byte{byte(length), byte(length >> 8), byte(length >> 16), byte(length >> 24)})
+	s.write([]byte(parquetMagic))
+	return s.err
+}
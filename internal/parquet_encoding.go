@@ -0,0 +1,203 @@
+package domainstats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// This file has the low-level parts of the Parquet file format which
+// parquetSink needs: the Thrift compact protocol used for the file and page
+// metadata, and the PLAIN and RLE encodings used for values and levels.
+// Only what is needed to write uncompressed files with one data page per
+// column chunk is implemented. See https://github.com/apache/parquet-format
+
+const parquetMagic = "PAR1"
+
+// physical types
+const (
+	parquetBoolean   int32 = 0
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// field repetition types
+const (
+	parquetRequired int32 = 0
+	parquetOptional int32 = 1
+	parquetRepeated int32 = 2
+)
+
+// converted types; parquetNoConvertedType means the field has none
+const (
+	parquetNoConvertedType int32 = -1
+	parquetUTF8            int32 = 0
+	parquetList            int32 = 3
+	parquetTimestampMillis int32 = 9
+)
+
+// encodings and page types
+const (
+	parquetPlainEncoding int32 = 0
+	parquetRLEEncoding   int32 = 3
+	parquetDataPage      int32 = 0
+)
+
+// Thrift compact protocol types
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// Writes Thrift structs with the compact protocol. The caller writes the
+// fields of each struct in increasing order of id, and ends each struct,
+// including the outermost one, with structEnd.
+type thriftWriter struct {
+	buf bytes.Buffer
+
+	// the id of the last field written in each struct being written
+	lastIDs []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{lastIDs: []int16{0}}
+}
+
+func (w *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *thriftWriter) varint(v int64) {
+	w.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.lastIDs[len(w.lastIDs)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) binary(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.listString(s)
+}
+
+// Starts a list field of n elements of the given type. The elements are
+// written with the list* methods, or, for structs, with listStruct and
+// structEnd.
+func (w *thriftWriter) list(id int16, elemType byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xF0 | elemType)
+		w.uvarint(uint64(n))
+	}
+}
+
+func (w *thriftWriter) listI32(v int32) {
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) listString(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *thriftWriter) listStruct() {
+	w.lastIDs = append(w.lastIDs, 0)
+}
+
+func (w *thriftWriter) structBegin(id int16) {
+	w.field(id, thriftStruct)
+	w.lastIDs = append(w.lastIDs, 0)
+}
+
+func (w *thriftWriter) structEnd() {
+	w.buf.WriteByte(0)
+	w.lastIDs = w.lastIDs[:len(w.lastIDs)-1]
+}
+
+// Returns the number of bits needed to store levels up to maxLevel
+func levelBitWidth(maxLevel int) int {
+	return bits.Len(uint(maxLevel))
+}
+
+// Encodes repetition or definition levels with the RLE/bit-packing hybrid
+// encoding, as written in data pages: prefixed by their length. Only RLE
+// runs are used, which suits levels well, since they are mostly repeats.
+func encodeLevels(levels []int, maxLevel int) []byte {
+	byteWidth := (levelBitWidth(maxLevel) + 7) / 8
+	var runs bytes.Buffer
+	var b [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		runs.Write(b[:binary.PutUvarint(b[:], uint64(j-i)<<1)])
+		for k := 0; k < byteWidth; k++ {
+			runs.WriteByte(byte(levels[i] >> uint(8*k)))
+		}
+		i = j
+	}
+
+	out := make([]byte, 4, 4+runs.Len())
+	binary.LittleEndian.PutUint32(out, uint32(runs.Len()))
+	return append(out, runs.Bytes()...)
+}
+
+// Encodes values of the given physical type with the PLAIN encoding.
+func encodePlain(typ int32, values []interface{}) []byte {
+	var buf bytes.Buffer
+	var b [8]byte
+	switch typ {
+	case parquetBoolean:
+		packed := make([]byte, (len(values)+7)/8)
+		for i, v := range values {
+			if v.(bool) {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		buf.Write(packed)
+	case parquetInt64:
+		for _, v := range values {
+			binary.LittleEndian.PutUint64(b[:], uint64(v.(int64)))
+			buf.Write(b[:])
+		}
+	case parquetDouble:
+		for _, v := range values {
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(v.(float64)))
+			buf.Write(b[:])
+		}
+	case parquetByteArray:
+		for _, v := range values {
+			s := v.(string)
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+			buf.Write(b[:4])
+			buf.WriteString(s)
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,523 @@
+package domainstats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// Reads a Thrift compact protocol struct into a map of field ids to values.
+// Lists are read as []interface{}, and binary fields as strings.
+func readThriftStruct(t *testing.T, r *bytes.Reader) map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			v, _ := binary.ReadVarint(r)
+			id = int16(v)
+		}
+		last = id
+		fields[id] = readThriftValue(t, r, b&0x0F)
+	}
+}
+
+func readThriftValue(t *testing.T, r *bytes.Reader, typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	case thriftBinary:
+		n, _ := binary.ReadUvarint(r)
+		b := make([]byte, n)
+		if _, err := r.Read(b); err != nil && n != 0 {
+			t.Fatal(err)
+		}
+		return string(b)
+	case thriftList:
+		header, _ := r.ReadByte()
+		n := uint64(header >> 4)
+		if n == 15 {
+			n, _ = binary.ReadUvarint(r)
+		}
+		list := []interface{}{}
+		for i := uint64(0); i < n; i++ {
+			list = append(list, readThriftValue(t, r, header&0x0F))
+		}
+		return list
+	case thriftStruct:
+		return readThriftStruct(t, r)
+	}
+	t.Fatalf("unexpected Thrift type %d", typ)
+	return nil
+}
+
+// A value read back from a column chunk, with its levels
+type parquetTestValue struct {
+	rep, def int
+	value    interface{}
+}
+
+// Reads every column of a Parquet file, following the format's spec rather
+// than the writer's code: the schema's levels are worked out from the
+// repetition types, levels are read with both RLE and bit-packed runs, and
+// the values are read as PLAIN. Columns are keyed by their dotted path.
+func readParquetColumns(t *testing.T, data []byte) map[string][]parquetTestValue {
+	if string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatal("the file should start and end with PAR1")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := readThriftStruct(t, bytes.NewReader(data[len(data)-8-footerLen:len(data)-8]))
+
+	type column struct {
+		typ            int64
+		maxDef, maxRep int
+	}
+	schema := footer[2].([]interface{})
+	columns := make(map[string]column)
+	paths := []string{}
+	var walk func(i int, path string, maxDef, maxRep int) int
+	walk = func(i int, path string, maxDef, maxRep int) int {
+		elem := schema[i].(map[int16]interface{})
+		if i > 0 {
+			path = strings.TrimPrefix(path+"."+elem[4].(string), ".")
+			switch elem[3].(int64) {
+			case 1:
+				maxDef++
+			case 2:
+				maxDef++
+				maxRep++
+			}
+		}
+		i++
+		if elem[5] == nil {
+			columns[path] = column{elem[1].(int64), maxDef, maxRep}
+			paths = append(paths, path)
+			return i
+		}
+		for n := int64(0); n < elem[5].(int64); n++ {
+			i = walk(i, path, maxDef, maxRep)
+		}
+		return i
+	}
+	if n := walk(0, "", 0, 0); n != len(schema) {
+		t.Fatalf("the schema has %d elements, but only %d are in the tree", len(schema), n)
+	}
+
+	values := make(map[string][]parquetTestValue)
+	for _, rg := range footer[4].([]interface{}) {
+		chunks := rg.(map[int16]interface{})[1].([]interface{})
+		if len(chunks) != len(paths) {
+			t.Fatalf("there are %d column chunks, but %d columns", len(chunks), len(paths))
+		}
+		for i, chunk := range chunks {
+			meta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			path := []string{}
+			for _, p := range meta[3].([]interface{}) {
+				path = append(path, p.(string))
+			}
+			if ref, test := paths[i], strings.Join(path, "."); ref != test {
+				t.Fatalf("%v != %v", ref, test)
+			}
+			col := columns[paths[i]]
+			if meta[1].(int64) != col.typ || meta[4].(int64) != 0 {
+				t.Fatalf("%s should be uncompressed, of type %d: %v", paths[i], col.typ, meta)
+			}
+
+			r := bytes.NewReader(data[meta[9].(int64):])
+			header := readThriftStruct(t, r)
+			if header[1].(int64) != 0 || header[2] != header[3] {
+				t.Fatalf("%s should have an uncompressed data page: %v", paths[i], header)
+			}
+			pageHeader := header[5].(map[int16]interface{})
+			n := int(pageHeader[1].(int64))
+			if int64(n) != meta[5].(int64) {
+				t.Fatalf("%s: the page has %d values, but the chunk %d", paths[i], n, meta[5])
+			}
+			page := make([]byte, header[2].(int64))
+			if _, err := io.ReadFull(r, page); err != nil {
+				t.Fatal(err)
+			}
+
+			pr := bytes.NewReader(page)
+			reps, defs := make([]int, n), make([]int, n)
+			if col.maxRep > 0 {
+				reps = readParquetLevels(t, pr, col.maxRep, n)
+			}
+			if col.maxDef > 0 {
+				defs = readParquetLevels(t, pr, col.maxDef, n)
+			}
+			present := 0
+			for _, def := range defs {
+				if def == col.maxDef {
+					present++
+				}
+			}
+			plain := readParquetPlain(t, pr, col.typ, present)
+			for j := 0; j < n; j++ {
+				v := parquetTestValue{rep: reps[j], def: defs[j]}
+				if defs[j] == col.maxDef {
+					v.value, plain = plain[0], plain[1:]
+				}
+				values[paths[i]] = append(values[paths[i]], v)
+			}
+			if pr.Len() != 0 {
+				t.Fatalf("%s: %d bytes are left over in the page", paths[i], pr.Len())
+			}
+		}
+	}
+	return values
+}
+
+// Reads n levels written with the RLE/bit-packing hybrid encoding,
+// prefixed by their length
+func readParquetLevels(t *testing.T, r *bytes.Reader, maxLevel, n int) []int {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatal(err)
+	}
+	bitWidth := 0
+	for maxLevel>>uint(bitWidth) != 0 {
+		bitWidth++
+	}
+	levels := decodeHybrid(t, bytes.NewReader(data), bitWidth)
+	if len(levels) < n {
+		t.Fatalf("there should be %d levels, but there are %d", n, len(levels))
+	}
+	return levels[:n]
+}
+
+// Decodes runs of the RLE/bit-packing hybrid encoding until r is empty.
+// Bit-packed runs are padded to groups of 8 values, so there may be more
+// values than were written.
+func decodeHybrid(t *testing.T, r *bytes.Reader, bitWidth int) []int {
+	values := []int{}
+	for r.Len() > 0 {
+		header, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header&1 == 0 {
+			b := make([]byte, (bitWidth+7)/8)
+			if _, err := io.ReadFull(r, b); err != nil {
+				t.Fatal(err)
+			}
+			v := 0
+			for i, x := range b {
+				v |= int(x) << uint(8*i)
+			}
+			for i := uint64(0); i < header>>1; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+
+		// bit-packed groups of 8 values, from the least significant bit
+		b := make([]byte, int(header>>1)*bitWidth)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(b)*8/bitWidth; i++ {
+			v := 0
+			for j := 0; j < bitWidth; j++ {
+				bit := i*bitWidth + j
+				v |= int(b[bit/8]>>uint(bit%8)&1) << uint(j)
+			}
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Reads n PLAIN values of the given physical type
+func readParquetPlain(t *testing.T, r *bytes.Reader, typ int64, n int) []interface{} {
+	values := []interface{}{}
+	if typ == 0 {
+		// booleans are bit-packed, from the least significant bit
+		b := make([]byte, (n+7)/8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			values = append(values, b[i/8]>>uint(i%8)&1 == 1)
+		}
+		return values
+	}
+
+	for i := 0; i < n; i++ {
+		var err error
+		switch typ {
+		case 2:
+			var v int64
+			err = binary.Read(r, binary.LittleEndian, &v)
+			values = append(values, v)
+		case 5:
+			var v float64
+			err = binary.Read(r, binary.LittleEndian, &v)
+			values = append(values, v)
+		case 6:
+			var length uint32
+			if err = binary.Read(r, binary.LittleEndian, &length); err == nil {
+				b := make([]byte, length)
+				_, err = io.ReadFull(r, b)
+				values = append(values, string(b))
+			}
+		default:
+			t.Fatalf("unexpected physical type %d", typ)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return values
+}
+
+func TestThriftWriter(t *testing.T) {
+	t.Parallel()
+	w := newThriftWriter()
+	w.i32(1, -1)
+	w.i64(20, 3)
+	w.structBegin(21)
+	w.binary(1, "ab")
+	w.structEnd()
+	w.structEnd()
+
+	ref := []byte{0x15, 0x01, 0x06, 0x28, 0x06, 0x1C, 0x18, 0x02, 'a', 'b', 0x00, 0x00}
+	if !bytes.Equal(ref, w.buf.Bytes()) {
+		t.Fatalf("%v != %v", ref, w.buf.Bytes())
+	}
+}
+
+func TestEncodeLevels(t *testing.T) {
+	t.Parallel()
+	ref := []byte{4, 0, 0, 0, 4, 0, 2, 1}
+	test := encodeLevels([]int{0, 0, 1}, 1)
+	if !bytes.Equal(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+}
+
+func TestEncodePlain(t *testing.T) {
+	t.Parallel()
+	ref := []byte{0x05}
+	test := encodePlain(parquetBoolean, []interface{}{true, false, true})
+	if !bytes.Equal(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	ref = []byte{1, 0, 0, 0, 'a', 0, 0, 0, 0}
+	test = encodePlain(parquetByteArray, []interface{}{"a", ""})
+	if !bytes.Equal(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+}
+
+func TestParquetShred(t *testing.T) {
+	t.Parallel()
+	root := parquetGroupNode("schema", parquetRequired,
+		parquetListNode("Tags", parquetOptional, parquetLeafNode("", parquetRequired, parquetByteArray)))
+	leaves := root.build(nil, 0, 0)
+	leaf := leaves[0]
+
+	if leaf.maxDef != 2 || leaf.maxRep != 1 {
+		t.Fatalf("maxDef, maxRep = %d, %d, but should = 2, 1", leaf.maxDef, leaf.maxRep)
+	}
+	if ref := []string{"Tags", "list", "element"}; !strSliceEq(ref, leaf.path) {
+		t.Fatalf("%v != %v", ref, leaf.path)
+	}
+
+	// null, an empty list, and a list with two items
+	root.shredPresent([]interface{}{nil}, 0, 0)
+	root.shredPresent([]interface{}{parquetListValue([]interface{}{})}, 0, 0)
+	root.shredPresent([]interface{}{parquetListValue([]interface{}{"a", "b"})}, 0, 0)
+
+	if ref := []int{0, 0, 0, 1}; !reflect.DeepEqual(ref, leaf.repLevels) {
+		t.Fatalf("%v != %v", ref, leaf.repLevels)
+	}
+	if ref := []int{0, 1, 2, 2}; !reflect.DeepEqual(ref, leaf.defLevels) {
+		t.Fatalf("%v != %v", ref, leaf.defLevels)
+	}
+	if ref := []interface{}{"a", "b"}; !reflect.DeepEqual(ref, leaf.values) {
+		t.Fatalf("%v != %v", ref, leaf.values)
+	}
+}
+
+func TestParquetSink(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{
+		Status:        true,
+		Cooccurrences: DomainScoreConfig{Domain: true, Score: true},
+	}
+	varConfig.Security.Geodiversity = true
+	varConfig.DomainRRHistory.Periods.FirstSeen = true
+	varConfig.DomainRRHistory.Periods.TTL = true
+
+	buf := new(bytes.Buffer)
+	sink := NewParquetSink(buf, &varConfig)
+	rows := []Row{
+		{Domain: "a.com", Responses: []interface{}{
+			&goinvestigate.DomainCategorization{Status: -1},
+			[]goinvestigate.Cooccurrence{{Domain: "b.com", Score: 0.5}},
+			&goinvestigate.SecurityFeatures{
+				Geodiversity: []goinvestigate.GeoFeatures{{CountryCode: "US", VisitRatio: 1}}},
+			&goinvestigate.DomainRRHistory{RRPeriods: []goinvestigate.ResourceRecordPeriod{
+				{FirstSeen: "2015-01-01", RRs: []goinvestigate.ResourceRecord{{TTL: 60}, {TTL: 300}}}}},
+		}},
+		{Domain: "b.com"},
+	}
+	for _, row := range rows {
+		if err := sink.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatal("the file should start and end with " + parquetMagic)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := readThriftStruct(t, bytes.NewReader(data[len(data)-8-footerLen:len(data)-8]))
+
+	if footer[3].(int64) != 2 {
+		t.Fatalf("num_rows = %v, but should = 2", footer[3])
+	}
+
+	names := []string{}
+	for _, elem := range footer[2].([]interface{}) {
+		names = append(names, elem.(map[int16]interface{})[4].(string))
+	}
+	refNames := []string{"schema", "Domain", "Status",
+		"Cooccurrences", "list", "element", "Domain", "Score",
+		"Geodiversity", "list", "element", "CountryCode", "VisitRatio",
+		"RR_Periods", "list", "element", "FirstSeen", "RRs", "list", "element", "TTL"}
+	if !strSliceEq(refNames, names) {
+		t.Fatalf("%v != %v", refNames, names)
+	}
+
+	// check the TTL column: two rows, the first with one period of two
+	// records, and the second null
+	rowGroups := footer[4].([]interface{})
+	chunks := rowGroups[0].(map[int16]interface{})[1].([]interface{})
+	if len(chunks) != 8 {
+		t.Fatalf("there should be 8 columns, but there are %d", len(chunks))
+	}
+	meta := chunks[7].(map[int16]interface{})[3].(map[int16]interface{})
+	if meta[1].(int64) != int64(parquetInt64) || meta[5].(int64) != 3 {
+		t.Fatalf("the TTL column should have 3 INT64 values: %v", meta)
+	}
+
+	r := bytes.NewReader(data[meta[9].(int64):])
+	pageHeader := readThriftStruct(t, r)
+	page := make([]byte, pageHeader[3].(int64))
+	r.Read(page)
+
+	refPage := append(encodeLevels([]int{0, 2, 0}, 2), encodeLevels([]int{3, 3, 0}, 3)...)
+	refPage = append(refPage, encodePlain(parquetInt64, []interface{}{int64(60), int64(300)})...)
+	if !bytes.Equal(refPage, page) {
+		t.Fatalf("%v != %v", refPage, page)
+	}
+}
+
+func TestParquetCooccurrencesOptions(t *testing.T) {
+	t.Parallel()
+	varConfig := &Config{Cooccurrences: DomainScoreConfig{
+		Domain: true, Score: true, Sort: "score", MaxResults: 2}}
+
+	row := Row{Domain: "a.com", Responses: []interface{}{[]goinvestigate.Cooccurrence{
+		{Domain: "b.com", Score: 0.25}, {Domain: "c.com", Score: 0.5}, {Domain: "d.com", Score: 0.4}}}}
+	fields := varConfig.parquetFields()
+	// the list is sorted and cut down like the CSV column
+	ref := parquetListValue([]interface{}{
+		[]interface{}{"c.com", 0.5},
+		[]interface{}{"d.com", 0.4},
+	})
+	if test := fields[1].value(row); !reflect.DeepEqual(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+}
+
+func TestParquetSinkColumns(t *testing.T) {
+	t.Parallel()
+	// the bit-packed example from the format's Encodings.md, to check that
+	// decodeHybrid follows it
+	ref := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	if test := decodeHybrid(t, bytes.NewReader([]byte{3, 0x88, 0xC6, 0xFA}), 3); !reflect.DeepEqual(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	varConfig := Config{
+		Status:        true,
+		Cooccurrences: DomainScoreConfig{Domain: true, Score: true},
+	}
+	varConfig.Security.Fastflux = true
+	varConfig.Security.Geodiversity = true
+	varConfig.DomainRRHistory.Periods.FirstSeen = true
+	varConfig.DomainRRHistory.Periods.TTL = true
+
+	buf := new(bytes.Buffer)
+	sink := NewParquetSink(buf, &varConfig)
+	rows := []Row{
+		{Domain: "a.com", Responses: []interface{}{
+			&goinvestigate.DomainCategorization{Status: -1},
+			[]goinvestigate.Cooccurrence{{Domain: "b.com", Score: 0.5}, {Domain: "c.com", Score: 0.25}},
+			&goinvestigate.SecurityFeatures{Fastflux: true,
+				Geodiversity: []goinvestigate.GeoFeatures{{CountryCode: "US", VisitRatio: 1}}},
+			&goinvestigate.DomainRRHistory{RRPeriods: []goinvestigate.ResourceRecordPeriod{
+				{FirstSeen: "2015-01-01", RRs: []goinvestigate.ResourceRecord{{TTL: 60}, {TTL: 300}}},
+				{FirstSeen: "2015-02-01"}}},
+		}},
+		// the security features are there, but without any geodiversity
+		{Domain: "b.com", Responses: []interface{}{&goinvestigate.SecurityFeatures{}}},
+	}
+	for _, row := range rows {
+		if err := sink.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the levels are worked out from the schema: lists are optional, with a
+	// repeated group of required elements
+	refColumns := map[string][]parquetTestValue{
+		"Domain": {{0, 0, "a.com"}, {0, 0, "b.com"}},
+		"Status": {{0, 1, int64(-1)}, {0, 0, nil}},
+		"Cooccurrences.list.element.Domain": {
+			{0, 2, "b.com"}, {1, 2, "c.com"}, {0, 0, nil}},
+		"Cooccurrences.list.element.Score": {
+			{0, 2, 0.5}, {1, 2, 0.25}, {0, 0, nil}},
+		"Fastflux":                              {{0, 1, true}, {0, 1, false}},
+		"Geodiversity.list.element.CountryCode": {{0, 2, "US"}, {0, 1, nil}},
+		"Geodiversity.list.element.VisitRatio":  {{0, 2, 1.0}, {0, 1, nil}},
+		"RR_Periods.list.element.FirstSeen": {
+			{0, 2, "2015-01-01"}, {1, 2, "2015-02-01"}, {0, 0, nil}},
+		"RR_Periods.list.element.RRs.list.element.TTL": {
+			{0, 3, int64(60)}, {2, 3, int64(300)}, {1, 2, nil}, {0, 0, nil}},
+	}
+	if test := readParquetColumns(t, buf.Bytes()); !reflect.DeepEqual(refColumns, test) {
+		t.Fatalf("%v != %v", refColumns, test)
+	}
+}
@@ -0,0 +1,588 @@
+package domainstats
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// the link-layer header types of the captures which can be read
+const (
+	linkTypeNull      = 0
+	linkTypeEthernet  = 1
+	linkTypeRaw       = 101
+	linkTypeLinuxSLL  = 113
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86DD
+)
+
+// pcapng block types, and the other values read from pcapng files
+const (
+	pcapngSectionHeader  = 0x0A0D0D0A
+	pcapngInterface      = 1
+	pcapngObsoletePacket = 2
+	pcapngSimplePacket   = 3
+	pcapngEnhancedPacket = 6
+	pcapngByteOrderMagic = 0x1A2B3C4D
+	pcapngOptionTSResol  = 9
+	pcapngDefaultTSUnits = 1000000
+)
+
+// the largest packet or block which is read, to not allocate too much for
+// a corrupt file
+const maxPcapBlock = 64 << 20
+
+// Reads the names looked up in a packet capture: the names asked about in
+// DNS queries and responses, over UDP or TCP on port 53, and the server
+// names (SNI) in TLS ClientHellos. Both pcap and pcapng files can be read.
+//
+// Packets are read one at a time, so IP fragments and TCP streams aren't
+// reassembled; DNS messages and ClientHellos which don't fit in one packet
+// are skipped.
+type PcapReader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	ng    bool
+
+	// for pcap files
+	linkType    int
+	unitsPerSec uint64
+
+	// for pcapng files, the interfaces of the current section
+	interfaces []pcapInterface
+
+	// names found in the last packet which haven't been returned yet
+	pending []LogQuery
+}
+
+type pcapInterface struct {
+	linkType    int
+	unitsPerSec uint64
+}
+
+// Returns a reader for the capture in r, after reading its header.
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	p := &PcapReader{r: bufio.NewReader(r)}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(p.r, magic); err != nil {
+		return nil, fmt.Errorf("reading capture header: %v", err)
+	}
+
+	if binary.BigEndian.Uint32(magic) == pcapngSectionHeader {
+		p.ng = true
+		if err := p.readSectionHeader(); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(magic) {
+		case 0xA1B2C3D4:
+			p.order, p.unitsPerSec = order, 1000000
+		case 0xA1B23C4D:
+			p.order, p.unitsPerSec = order, 1000000000
+		}
+	}
+	if p.order == nil {
+		return nil, errors.New("not a pcap or pcapng file")
+	}
+
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		return nil, fmt.Errorf("reading capture header: %v", err)
+	}
+	// the upper bits hold FCS information
+	p.linkType = int(p.order.Uint32(header[16:]) & 0x0FFFFFFF)
+	return p, nil
+}
+
+// Returns the next name looked up in the capture, with the time of the
+// packet it was in. At the end of the capture, it returns io.EOF, or
+// io.ErrUnexpectedEOF if the last packet was cut off, as happens when a
+// capture is still being written.
+func (p *PcapReader) Next() (LogQuery, error) {
+	for len(p.pending) == 0 {
+		data, linkType, t, err := p.readPacket()
+		if err != nil {
+			return LogQuery{}, err
+		}
+		for _, name := range packetNames(data, linkType) {
+			if name = queryName(name); name != "" {
+				p.pending = append(p.pending, LogQuery{name, t})
+			}
+		}
+	}
+	q := p.pending[0]
+	p.pending = p.pending[1:]
+	return q, nil
+}
+
+func (p *PcapReader) readPacket() (data []byte, linkType int, t time.Time, err error) {
+	if p.ng {
+		return p.readPcapngPacket()
+	}
+
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	capLen := p.order.Uint32(header[8:])
+	if capLen > maxPcapBlock {
+		return nil, 0, time.Time{}, fmt.Errorf("packet of %d bytes is too large", capLen)
+	}
+	data = make([]byte, capLen)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return nil, 0, time.Time{}, io.ErrUnexpectedEOF
+	}
+	secs := uint64(p.order.Uint32(header[0:]))
+	frac := uint64(p.order.Uint32(header[4:]))
+	return data, p.linkType, pcapTime(secs*p.unitsPerSec+frac, p.unitsPerSec), nil
+}
+
+// Returns the time of a timestamp in units of 1/unitsPerSec seconds since
+// the epoch.
+func pcapTime(ts, unitsPerSec uint64) time.Time {
+	secs, frac := ts/unitsPerSec, ts%unitsPerSec
+	return time.Unix(int64(secs), int64(frac*1000000000/unitsPerSec)).UTC()
+}
+
+// Reads the rest of a pcapng section header block, after its type, which
+// sets the byte order of the section.
+func (p *PcapReader) readSectionHeader() error {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	switch {
+	case binary.LittleEndian.Uint32(header[4:]) == pcapngByteOrderMagic:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(header[4:]) == pcapngByteOrderMagic:
+		p.order = binary.BigEndian
+	default:
+		return errors.New("pcapng section header has no byte-order magic")
+	}
+
+	length := p.order.Uint32(header)
+	if length < 28 || length%4 != 0 || length > maxPcapBlock {
+		return fmt.Errorf("pcapng section header has a bad length %d", length)
+	}
+	if _, err := io.CopyN(ioutil.Discard, p.r, int64(length-12)); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	p.interfaces = nil
+	return nil
+}
+
+func (p *PcapReader) readPcapngPacket() (data []byte, linkType int, t time.Time, err error) {
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(p.r, header[:4]); err != nil {
+			return nil, 0, time.Time{}, err
+		}
+		// a new section, which may have another byte order
+		if binary.BigEndian.Uint32(header) == pcapngSectionHeader {
+			if err := p.readSectionHeader(); err != nil {
+				return nil, 0, time.Time{}, err
+			}
+			continue
+		}
+		if _, err := io.ReadFull(p.r, header[4:]); err != nil {
+			return nil, 0, time.Time{}, io.ErrUnexpectedEOF
+		}
+
+		blockType := p.order.Uint32(header)
+		length := p.order.Uint32(header[4:])
+		if length < 12 || length%4 != 0 || length > maxPcapBlock {
+			return nil, 0, time.Time{}, fmt.Errorf("pcapng block has a bad length %d", length)
+		}
+		// the body, and the length again
+		block := make([]byte, length-8)
+		if _, err := io.ReadFull(p.r, block); err != nil {
+			return nil, 0, time.Time{}, io.ErrUnexpectedEOF
+		}
+		body := block[:len(block)-4]
+
+		switch blockType {
+		case pcapngInterface:
+			if len(body) < 8 {
+				continue
+			}
+			iface := pcapInterface{int(p.order.Uint16(body)), pcapngDefaultTSUnits}
+			forEachPcapngOption(body[8:], p.order, func(code uint16, value []byte) {
+				if code == pcapngOptionTSResol && len(value) == 1 {
+					iface.unitsPerSec = tsResolUnits(value[0])
+				}
+			})
+			p.interfaces = append(p.interfaces, iface)
+
+		case pcapngEnhancedPacket, pcapngObsoletePacket:
+			if len(body) < 20 {
+				continue
+			}
+			var id uint32
+			if blockType == pcapngEnhancedPacket {
+				id = p.order.Uint32(body)
+			} else {
+				id = uint32(p.order.Uint16(body))
+			}
+			if int(id) >= len(p.interfaces) {
+				continue
+			}
+			iface := p.interfaces[id]
+			ts := uint64(p.order.Uint32(body[4:]))<<32 | uint64(p.order.Uint32(body[8:]))
+			capLen := int(p.order.Uint32(body[12:]))
+			if capLen > len(body)-20 {
+				capLen = len(body) - 20
+			}
+			return body[20 : 20+capLen], iface.linkType, pcapTime(ts, iface.unitsPerSec), nil
+
+		case pcapngSimplePacket:
+			// these have no timestamp
+			if len(body) < 4 || len(p.interfaces) == 0 {
+				continue
+			}
+			data := body[4:]
+			if origLen := int(p.order.Uint32(body)); origLen < len(data) {
+				data = data[:origLen]
+			}
+			return data, p.interfaces[0].linkType, time.Time{}, nil
+		}
+	}
+}
+
+// Calls fn with each of the options at the end of a pcapng block.
+func forEachPcapngOption(opts []byte, order binary.ByteOrder, fn func(code uint16, value []byte)) {
+	for len(opts) >= 4 {
+		code, length := order.Uint16(opts), int(order.Uint16(opts[2:]))
+		if code == 0 || len(opts) < 4+length {
+			return
+		}
+		fn(code, opts[4:4+length])
+		// values are padded to 32 bits
+		opts = opts[4+(length+3)/4*4:]
+	}
+}
+
+// Returns the number of timestamp units per second for an if_tsresol
+// option: a power of 10, or of 2 if the high bit is set.
+func tsResolUnits(resol byte) uint64 {
+	if resol&0x80 != 0 {
+		return 1 << (resol & 0x7F)
+	}
+	units := uint64(1)
+	for i := byte(0); i < resol; i++ {
+		units *= 10
+	}
+	return units
+}
+
+// Returns the names looked up in a packet: the questions of a DNS message,
+// or the server name of a TLS ClientHello.
+func packetNames(data []byte, linkType int) []string {
+	var etherType int
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil
+		}
+		etherType, data = int(binary.BigEndian.Uint16(data[12:])), data[14:]
+		// VLAN tags
+		for etherType == 0x8100 || etherType == 0x88A8 {
+			if len(data) < 4 {
+				return nil
+			}
+			etherType, data = int(binary.BigEndian.Uint16(data[2:])), data[4:]
+		}
+	case linkTypeNull:
+		// the address family, in the byte order of the capturing host
+		if len(data) < 4 {
+			return nil
+		}
+		family := binary.LittleEndian.Uint32(data)
+		if family > 0xFFFF {
+			family = binary.BigEndian.Uint32(data)
+		}
+		switch family {
+		case 2:
+			etherType = etherTypeIPv4
+		case 24, 28, 30:
+			etherType = etherTypeIPv6
+		}
+		data = data[4:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		if len(data) == 0 {
+			return nil
+		}
+		switch data[0] >> 4 {
+		case 4:
+			etherType = etherTypeIPv4
+		case 6:
+			etherType = etherTypeIPv6
+		}
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil
+		}
+		etherType, data = int(binary.BigEndian.Uint16(data[14:])), data[16:]
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil
+		}
+		etherType, data = int(binary.BigEndian.Uint16(data)), data[20:]
+	default:
+		return nil
+	}
+
+	proto, segment, ok := ipPayload(etherType, data)
+	if !ok {
+		return nil
+	}
+
+	switch proto {
+	case 17: // UDP
+		if len(segment) < 8 {
+			return nil
+		}
+		if dnsPort(segment) {
+			return dnsQuestionNames(segment[8:])
+		}
+	case 6: // TCP
+		if len(segment) < 20 {
+			return nil
+		}
+		offset := int(segment[12]>>4) * 4
+		if offset < 20 || len(segment) < offset {
+			return nil
+		}
+		payload := segment[offset:]
+		if dnsPort(segment) {
+			// DNS over TCP has a length before each message
+			if len(payload) < 2 {
+				return nil
+			}
+			length := int(binary.BigEndian.Uint16(payload))
+			if len(payload) < 2+length {
+				return nil
+			}
+			return dnsQuestionNames(payload[2 : 2+length])
+		}
+		if name, ok := tlsServerName(payload); ok {
+			return []string{name}
+		}
+	}
+	return nil
+}
+
+// Reports whether a UDP or TCP segment is to or from the DNS port.
+func dnsPort(segment []byte) bool {
+	return binary.BigEndian.Uint16(segment) == 53 || binary.BigEndian.Uint16(segment[2:]) == 53
+}
+
+// Returns the protocol and payload of an IP packet. Fragments are skipped,
+// since they can't be read on their own.
+func ipPayload(etherType int, data []byte) (proto byte, payload []byte, ok bool) {
+	switch etherType {
+	case etherTypeIPv4:
+		if len(data) < 20 || data[0]>>4 != 4 {
+			return 0, nil, false
+		}
+		headerLen := int(data[0]&0x0F) * 4
+		// Ethernet frames may be padded past the end of the packet
+		if total := int(binary.BigEndian.Uint16(data[2:])); total < len(data) {
+			data = data[:total]
+		}
+		if headerLen < 20 || len(data) < headerLen {
+			return 0, nil, false
+		}
+		// more fragments, or a fragment offset
+		if binary.BigEndian.Uint16(data[6:])&0x3FFF != 0 {
+			return 0, nil, false
+		}
+		return data[9], data[headerLen:], true
+
+	case etherTypeIPv6:
+		if len(data) < 40 || data[0]>>4 != 6 {
+			return 0, nil, false
+		}
+		next := data[6]
+		if length := int(binary.BigEndian.Uint16(data[4:])); 40+length < len(data) {
+			data = data[:40+length]
+		}
+		data = data[40:]
+		for {
+			switch next {
+			case 0, 43, 60: // hop-by-hop, routing and destination options
+				if len(data) < 2 || len(data) < (int(data[1])+1)*8 {
+					return 0, nil, false
+				}
+				next, data = data[0], data[(int(data[1])+1)*8:]
+			case 51: // authentication header
+				if len(data) < 2 || len(data) < (int(data[1])+2)*4 {
+					return 0, nil, false
+				}
+				next, data = data[0], data[(int(data[1])+2)*4:]
+			case 44: // fragment
+				return 0, nil, false
+			default:
+				return next, data, true
+			}
+		}
+	}
+	return 0, nil, false
+}
+
+// Returns the names in the question section of a standard DNS query.
+// Responses repeat their query's question, so they're skipped, lest each
+// name be counted twice. Names which aren't printable ASCII are skipped.
+func dnsQuestionNames(msg []byte) []string {
+	if len(msg) < 12 {
+		return nil
+	}
+	if msg[2]&0x80 != 0 {
+		return nil
+	}
+	// only standard queries, not e.g. updates or notifies
+	if opcode := (msg[2] >> 3) & 0x0F; opcode != 0 {
+		return nil
+	}
+
+	names := []string{}
+	offset := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(msg[4:])); i++ {
+		name, next, ok := dnsName(msg, offset)
+		// the type and class follow the name
+		if !ok || next+4 > len(msg) {
+			break
+		}
+		offset = next + 4
+		if printableName(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Reads the possibly compressed name at offset in a DNS message, and
+// returns it with the offset just after it.
+func dnsName(msg []byte, offset int) (string, int, bool) {
+	name := []byte{}
+	end := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, false
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end == -1 {
+				end = offset + 1
+			}
+			return string(name), end, true
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(msg) || jumps > 16 {
+				return "", 0, false
+			}
+			if end == -1 {
+				end = offset + 2
+			}
+			jumps++
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+		case length&0xC0 != 0:
+			return "", 0, false
+		default:
+			if offset+1+length > len(msg) || len(name)+length > 255 {
+				return "", 0, false
+			}
+			if len(name) != 0 {
+				name = append(name, '.')
+			}
+			name = append(name, msg[offset+1:offset+1+length]...)
+			offset += 1 + length
+		}
+	}
+}
+
+func printableName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the server name in a TLS ClientHello, if data starts with one.
+func tlsServerName(data []byte) (string, bool) {
+	// a handshake record, then a ClientHello message
+	if len(data) < 9 || data[0] != 0x16 || data[1] != 3 || data[5] != 1 {
+		return "", false
+	}
+	data = data[9:]
+
+	// skips a field with a length of n bytes before it
+	skip := func(n int) bool {
+		if len(data) < n {
+			return false
+		}
+		length := 0
+		for _, b := range data[:n] {
+			length = length<<8 | int(b)
+		}
+		if len(data) < n+length {
+			return false
+		}
+		data = data[n+length:]
+		return true
+	}
+
+	// the version and random, then the session ID, cipher suites and
+	// compression methods
+	if len(data) < 34 {
+		return "", false
+	}
+	data = data[34:]
+	if !skip(1) || !skip(2) || !skip(1) || len(data) < 2 {
+		return "", false
+	}
+	data = data[2:]
+
+	for len(data) >= 4 {
+		extType := binary.BigEndian.Uint16(data)
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		if len(data) < 4+length {
+			return "", false
+		}
+		if extType == 0 {
+			// the server name list
+			list := data[4 : 4+length]
+			if len(list) < 2 {
+				return "", false
+			}
+			list = list[2:]
+			for len(list) >= 3 {
+				nameLen := int(binary.BigEndian.Uint16(list[1:]))
+				if len(list) < 3+nameLen {
+					return "", false
+				}
+				if name := string(list[3 : 3+nameLen]); list[0] == 0 && printableName(name) {
+					return name, true
+				}
+				list = list[3+nameLen:]
+			}
+			return "", false
+		}
+		data = data[4+length:]
+	}
+	return "", false
+}
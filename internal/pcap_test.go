@@ -0,0 +1,246 @@
+package domainstats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dnsQuery(name string) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0, 0, 1, 0, 1)
+}
+
+// Returns the response to dnsQuery(name), with the QR bit set
+func dnsResponse(name string) []byte {
+	msg := dnsQuery(name)
+	msg[2], msg[3] = 0x81, 0x80
+	return msg
+}
+
+func tlsClientHello(serverName string) []byte {
+	sni := []byte{0}
+	sni = binary.BigEndian.AppendUint16(sni, uint16(len(serverName)))
+	sni = append(sni, serverName...)
+	sni = append(binary.BigEndian.AppendUint16(nil, uint16(len(sni))), sni...)
+
+	// supported groups, then the server name
+	exts := []byte{0x00, 0x0a, 0, 2, 0, 0x1d, 0, 0}
+	exts = binary.BigEndian.AppendUint16(exts, uint16(len(sni)))
+	exts = append(exts, sni...)
+
+	hello := append([]byte{3, 3}, make([]byte, 32)...)
+	hello = append(hello, 0, 0, 2, 0x13, 0x01, 1, 0)
+	hello = binary.BigEndian.AppendUint16(hello, uint16(len(exts)))
+	hello = append(hello, exts...)
+
+	handshake := append([]byte{1, 0}, binary.BigEndian.AppendUint16(nil, uint16(len(hello)))...)
+	handshake = append(handshake, hello...)
+	record := []byte{0x16, 3, 1}
+	record = binary.BigEndian.AppendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...)
+}
+
+func transport(sport, dport uint16, tcp bool, payload []byte) []byte {
+	header := binary.BigEndian.AppendUint16(nil, sport)
+	header = binary.BigEndian.AppendUint16(header, dport)
+	if tcp {
+		header = append(header, make([]byte, 16)...)
+		header[12] = 5 << 4
+	} else {
+		header = binary.BigEndian.AppendUint16(header, uint16(8+len(payload)))
+		header = append(header, 0, 0)
+	}
+	return append(header, payload...)
+}
+
+func ipv4Packet(proto byte, payload []byte) []byte {
+	header := make([]byte, 20)
+	header[0] = 0x45
+	binary.BigEndian.PutUint16(header[2:], uint16(20+len(payload)))
+	header[8], header[9] = 64, proto
+	return append(header, payload...)
+}
+
+func ipv6Packet(next byte, payload []byte) []byte {
+	header := make([]byte, 40)
+	header[0] = 0x60
+	binary.BigEndian.PutUint16(header[4:], uint16(len(payload)))
+	header[6], header[7] = next, 64
+	return append(header, payload...)
+}
+
+func ethernetFrame(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 12)
+	frame = binary.BigEndian.AppendUint16(frame, etherType)
+	return append(frame, payload...)
+}
+
+// Returns a little-endian pcap file with microsecond timestamps.
+func pcapFile(linkType uint32, times []time.Time, packets [][]byte) []byte {
+	le := binary.LittleEndian
+	f := le.AppendUint32(nil, 0xA1B2C3D4)
+	f = le.AppendUint16(f, 2)
+	f = le.AppendUint16(f, 4)
+	f = append(f, make([]byte, 8)...)
+	f = le.AppendUint32(f, 65535)
+	f = le.AppendUint32(f, linkType)
+	for i, p := range packets {
+		f = le.AppendUint32(f, uint32(times[i].Unix()))
+		f = le.AppendUint32(f, uint32(times[i].Nanosecond()/1000))
+		f = le.AppendUint32(f, uint32(len(p)))
+		f = le.AppendUint32(f, uint32(len(p)))
+		f = append(f, p...)
+	}
+	return f
+}
+
+// Returns a big-endian pcapng block.
+func pcapngBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	be := binary.BigEndian
+	b := be.AppendUint32(nil, blockType)
+	b = be.AppendUint32(b, uint32(12+len(body)))
+	b = append(b, body...)
+	return be.AppendUint32(b, uint32(12+len(body)))
+}
+
+// Returns a big-endian pcapng file with nanosecond timestamps.
+func pcapngFile(linkType uint16, times []time.Time, packets [][]byte) []byte {
+	be := binary.BigEndian
+	shb := be.AppendUint32(nil, pcapngByteOrderMagic)
+	shb = append(shb, 0, 1, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	f := pcapngBlock(pcapngSectionHeader, shb)
+
+	idb := be.AppendUint16(nil, linkType)
+	idb = append(idb, 0, 0, 0, 0, 0, 0)
+	idb = append(idb, 0, pcapngOptionTSResol, 0, 1, 9, 0, 0, 0, 0, 0, 0, 0)
+	f = append(f, pcapngBlock(pcapngInterface, idb)...)
+
+	for i, p := range packets {
+		ts := uint64(times[i].UnixNano())
+		epb := be.AppendUint32(nil, 0)
+		epb = be.AppendUint32(epb, uint32(ts>>32))
+		epb = be.AppendUint32(epb, uint32(ts))
+		epb = be.AppendUint32(epb, uint32(len(p)))
+		epb = be.AppendUint32(epb, uint32(len(p)))
+		epb = append(epb, p...)
+		f = append(f, pcapngBlock(pcapngEnhancedPacket, epb)...)
+	}
+	return f
+}
+
+func readPcap(t *testing.T, capture []byte) ([]LogQuery, error) {
+	r, err := NewPcapReader(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []LogQuery{}
+	for {
+		q, err := r.Next()
+		if err == io.EOF {
+			return queries, nil
+		}
+		if err != nil {
+			return queries, err
+		}
+		queries = append(queries, q)
+	}
+}
+
+func TestPcapReader(t *testing.T) {
+	t.Parallel()
+	t1 := time.Date(2015, 6, 30, 12, 0, 0, 123456000, time.UTC)
+	t2 := t1.Add(time.Second)
+	times := []time.Time{t1, t2, t2, t2}
+	packets := [][]byte{
+		ethernetFrame(etherTypeIPv4, ipv4Packet(17, transport(50000, 53, false, dnsQuery("Example.COM")))),
+		ethernetFrame(etherTypeIPv6, ipv6Packet(6, transport(50001, 443, true, tlsClientHello("sni.example.org")))),
+		// not DNS
+		ethernetFrame(etherTypeIPv4, ipv4Packet(17, transport(50000, 123, false, dnsQuery("ntp.org")))),
+		// VLAN tagged, and DNS over TCP
+		ethernetFrame(0x8100, append([]byte{0, 1, 0x08, 0x00}, ipv4Packet(6, transport(53, 50002, true,
+			append([]byte{0, byte(len(dnsQuery("tcp.example.com")))}, dnsQuery("tcp.example.com")...)))...)),
+	}
+
+	queries, err := readPcap(t, pcapFile(linkTypeEthernet, times, packets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := []LogQuery{{"example.com", t1}, {"sni.example.org", t2}, {"tcp.example.com", t2}}
+	checkQueries(t, ref, queries)
+}
+
+func TestPcapngReader(t *testing.T) {
+	t.Parallel()
+	t1 := time.Date(2015, 6, 30, 12, 0, 0, 123456789, time.UTC)
+	sll := func(payload []byte) []byte {
+		header := make([]byte, 14)
+		return append(binary.BigEndian.AppendUint16(header, etherTypeIPv4), payload...)
+	}
+	packets := [][]byte{sll(ipv4Packet(17, transport(53, 50000, false, dnsQuery("a.example.com"))))}
+	capture := pcapngFile(linkTypeLinuxSLL, []time.Time{t1}, packets)
+
+	queries, err := readPcap(t, capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkQueries(t, []LogQuery{{"a.example.com", t1}}, queries)
+
+	// a capture which is cut off still has the names before the end
+	capture = pcapngFile(linkTypeLinuxSLL, []time.Time{t1, t1}, append(packets, packets[0]))
+	queries, err = readPcap(t, capture[:len(capture)-4])
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("%v != %v", io.ErrUnexpectedEOF, err)
+	}
+	checkQueries(t, []LogQuery{{"a.example.com", t1}}, queries)
+
+	if _, err := NewPcapReader(strings.NewReader("a.com\nb.com\n")); err == nil {
+		t.Fatal("a domain list should not be read as a capture")
+	}
+}
+
+func TestDNSName(t *testing.T) {
+	t.Parallel()
+	// "www" followed by a pointer to "example.com" at offset 12
+	msg := append(dnsQuery("example.com")[:25], 3, 'w', 'w', 'w', 0xC0, 12)
+	name, next, ok := dnsName(msg, 25)
+	if !ok || name != "www.example.com" || next != len(msg) {
+		t.Fatalf("unexpected name %q, %d, %v", name, next, ok)
+	}
+
+	// a pointer to itself
+	if _, _, ok := dnsName([]byte{0xC0, 0}, 0); ok {
+		t.Fatal("a pointer loop should not be read")
+	}
+}
+
+func TestPcapReaderSkipsResponses(t *testing.T) {
+	t.Parallel()
+	t1 := time.Date(2015, 6, 30, 12, 0, 0, 0, time.UTC)
+	packets := [][]byte{
+		ethernetFrame(etherTypeIPv4, ipv4Packet(17, transport(50000, 53, false, dnsQuery("example.com")))),
+		ethernetFrame(etherTypeIPv4, ipv4Packet(17, transport(53, 50000, false, dnsResponse("example.com")))),
+	}
+	queries, err := readPcap(t, pcapFile(linkTypeEthernet, []time.Time{t1, t1}, packets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agg := NewQueryAggregator()
+	for _, q := range queries {
+		agg.Add(q)
+	}
+	if stats := agg.Stats("example.com"); stats == nil || stats.Count != 1 {
+		t.Fatalf("a query and its response should be counted once: %+v", stats)
+	}
+}
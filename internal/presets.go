@@ -0,0 +1,191 @@
+package domainstats
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The prefix of an Include which names a built-in preset instead of a file
+const presetPrefix = "preset:"
+
+// Built-in sets of fields for common tasks. Each preset only sets fields;
+// other settings, like the API key, come from the config it is applied to.
+var presets = map[string]string{
+	// a quick look at whether domains are known to be malicious
+	"triage": `
+Status = true
+
+[Categories]
+  SecurityCategories = true
+  ContentCategories = true
+
+[Security]
+  DGAScore = true
+  SecureRank2 = true
+  Fastflux = true
+  Attack = true
+  ThreatType = true
+
+[TaggingDates]
+  Begin = true
+  End = true
+  Category = true
+`,
+
+	// where domains are hosted, and how that has changed over time
+	"infrastructure": `
+[Security]
+  ASNScore = true
+  PrefixScore = true
+  RIPScore = true
+  Geodiversity = true
+  Fastflux = true
+
+[DomainRRHistory]
+  [DomainRRHistory.Periods]
+    FirstSeen = true
+    LastSeen = true
+    Type = true
+    RR = true
+  [DomainRRHistory.Features]
+    Age = true
+    TTLsMin = true
+    TTLsMax = true
+    CountryCodes = true
+    ASNs = true
+    Prefixes = true
+    RIPSCount = true
+    NonRoutable = true
+    FFCandidate = true
+`,
+
+	// every field; the same as the generated default config
+	"full": presetFromConfig(defaultConfig()),
+}
+
+// Encodes the fields of a config as a preset. Only the fields which turn
+// columns on and off are included, so that the preset doesn't change
+// options like MaxResults.
+func presetFromConfig(c *Config) string {
+	var buf strings.Builder
+	if err := toml.NewEncoder(&buf).Encode(boolFields(reflect.ValueOf(c.fields()))); err != nil {
+		panic(err)
+	}
+	return buf.String()
+}
+
+// Returns the bool fields of a struct, with those of the structs in it as
+// tables of their own.
+func boolFields(v reflect.Value) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Bool:
+			fields[v.Type().Field(i).Name] = f.Bool()
+		case reflect.Struct:
+			fields[v.Type().Field(i).Name] = boolFields(f)
+		}
+	}
+	return fields
+}
+
+// Returns the bool fields of a struct which are set, with those of the
+// structs in it as tables of their own. Tables with none set are left out.
+func setBoolFields(v reflect.Value) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Bool:
+			if f.Bool() {
+				fields[v.Type().Field(i).Name] = true
+			}
+		case reflect.Struct:
+			if table := setBoolFields(f); len(table) != 0 {
+				fields[v.Type().Field(i).Name] = table
+			}
+		}
+	}
+	return fields
+}
+
+// Clears the bool fields of a struct, and of the structs in it.
+func clearBoolFields(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Bool:
+			f.SetBool(false)
+		case reflect.Struct:
+			clearBoolFields(f)
+		}
+	}
+}
+
+// The subset of Config which presets set
+type fieldsConfig struct {
+	Status          bool
+	Categories      CategoriesConfig
+	Cooccurrences   DomainScoreConfig
+	Related         DomainScoreConfig
+	Security        SecurityConfig
+	TaggingDates    TaggingDatesConfig
+	DomainRRHistory DomainRRHistoryConfig
+	Whois           WhoisConfig
+}
+
+func (c *Config) fields() fieldsConfig {
+	return fieldsConfig{
+		c.Status, c.Categories, c.Cooccurrences, c.Related, c.Security,
+		c.TaggingDates, c.DomainRRHistory, c.Whois,
+	}
+}
+
+// Returns the names of the built-in presets, sorted
+func PresetNames() []string {
+	names := []string{}
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sets the fields of the config from the given preset, on top of whatever
+// is already set.
+func (c *Config) includePreset(name string) error {
+	preset, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("no built-in preset named %q (presets: %s)",
+			name, strings.Join(PresetNames(), ", "))
+	}
+	_, err := toml.Decode(preset, c)
+	return err
+}
+
+// Replaces the fields of the config, including any Columns list, with the
+// given preset.
+func (c *Config) applyPreset(name string) error {
+	if _, ok := presets[name]; !ok {
+		return fmt.Errorf("no built-in preset named %q (presets: %s)",
+			name, strings.Join(PresetNames(), ", "))
+	}
+
+	// only the fields presets set are cleared, so that options like
+	// MaxResults are kept
+	c.Columns = nil
+	v := reflect.ValueOf(c).Elem()
+	fields := reflect.TypeOf(fieldsConfig{})
+	for i := 0; i < fields.NumField(); i++ {
+		f := v.FieldByName(fields.Field(i).Name)
+		if f.Kind() == reflect.Bool {
+			f.SetBool(false)
+		} else {
+			clearBoolFields(f)
+		}
+	}
+
+	return c.includePreset(name)
+}
@@ -0,0 +1,144 @@
+package domainstats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestPresetsDecode(t *testing.T) {
+	t.Parallel()
+	for _, name := range PresetNames() {
+		var c Config
+		md, err := toml.Decode(presets[name], &c)
+		if err != nil {
+			t.Fatalf("preset %q: %v", name, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) != 0 {
+			t.Fatalf("preset %q has unknown keys: %v", name, undecoded)
+		}
+		if len(c.QueryPlan()) == 0 {
+			t.Fatalf("preset %q doesn't enable any fields", name)
+		}
+	}
+
+	var full Config
+	full.includePreset("full")
+	refHeader := defaultConfig().DeriveHeader()
+	if testHeader := full.DeriveHeader(); !strSliceEq(refHeader, testHeader) {
+		t.Fatalf("testHeader = %v, but should = %v", testHeader, refHeader)
+	}
+}
+
+func TestApplyPreset(t *testing.T) {
+	t.Parallel()
+	varConfig := defaultConfig()
+	varConfig.APIKey = "test"
+	varConfig.Columns = []string{"Domain", "Age"}
+	if err := varConfig.applyPreset("triage"); err != nil {
+		t.Fatal(err)
+	}
+
+	if varConfig.Columns != nil || any(varConfig.DomainRRHistory.Features) ||
+		any(varConfig.Cooccurrences) {
+		t.Fatalf("fields not in the preset should be cleared: %+v", varConfig)
+	}
+	if !varConfig.Security.ThreatType || varConfig.APIKey != "test" {
+		t.Fatalf("preset fields and other settings should be set: %+v", varConfig)
+	}
+
+	// options which aren't columns are kept, whatever the preset
+	varConfig.Cooccurrences = DomainScoreConfig{MaxResults: 3, Sort: "score", Exclude: []string{"a.com"}}
+	varConfig.Related.MinScore = 0.5
+	varConfig.Whois.MaxEmailDomains = 10
+	for _, name := range []string{"full", "triage"} {
+		if err := varConfig.applyPreset(name); err != nil {
+			t.Fatal(err)
+		}
+		dsc := varConfig.Cooccurrences
+		if dsc.MaxResults != 3 || dsc.Sort != "score" || len(dsc.Exclude) != 1 ||
+			varConfig.Related.MinScore != 0.5 || varConfig.Whois.MaxEmailDomains != 10 {
+			t.Fatalf("preset %q should keep the options: %+v", name, varConfig)
+		}
+	}
+	if any(varConfig.Cooccurrences) {
+		t.Fatalf("fields not in the preset should be cleared: %+v", varConfig.Cooccurrences)
+	}
+
+	if err := varConfig.applyPreset("nope"); err == nil {
+		t.Fatal("unknown presets should return an error")
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	t.Setenv(APIKeyEnv, "")
+	dir, err := ioutil.TempDir("", "domainstats-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, contents string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	write("key", "file-key\n")
+	write("base.toml", `
+Include = "preset:triage"
+APIKeyFile = "key"
+Stauts = true
+
+[Security]
+  DGAScore = false
+`)
+	os.Mkdir(filepath.Join(dir, "sub"), 0700)
+	top := write("sub/top.toml", `
+Include = "../base.toml"
+Status = false
+
+[Security]
+  PageRank = true
+`)
+
+	testConfig, warnings, err := LoadConfig(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown keys in included files are still reported
+	if len(warnings) != 1 {
+		t.Fatalf("warnings = %v, but there should be 1", warnings)
+	}
+
+	// the key file is relative to the file which set it
+	if testConfig.APIKey != "file-key" {
+		t.Fatalf("APIKey = %q, but should = %q", testConfig.APIKey, "file-key")
+	}
+
+	refHeader := []string{
+		"Domain", "SecurityCategories", "ContentCategories", "SecureRank2",
+		"PageRank", "Fastflux", "Attack", "ThreatType", "TaggingDates",
+	}
+	if testHeader := testConfig.DeriveHeader(); !strSliceEq(refHeader, testHeader) {
+		t.Fatalf("testHeader = %v, but should = %v", testHeader, refHeader)
+	}
+
+	// include cycles should be caught
+	write("a.toml", "Include = \"b.toml\"\nAPIKey = \"test\"\n")
+	cycle := write("b.toml", "Include = \"a.toml\"\n")
+	if _, _, err := LoadConfig(cycle); err == nil {
+		t.Fatal("include cycles should return an error")
+	}
+
+	missing := write("missing.toml", "Include = \"nope.toml\"\nAPIKey = \"test\"\n")
+	if _, _, err := LoadConfig(missing); err == nil {
+		t.Fatal("missing includes should return an error")
+	}
+}
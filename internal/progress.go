@@ -0,0 +1,154 @@
+package domainstats
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The names of the Investigate endpoints in progress lines, in the order
+// they're shown
+var endpointNames = []string{"categorization", "cooccurrences", "related", "security",
+	"tags", "rr-history", "whois"}
+
+// Returns the name of the Investigate endpoint a query is sent to.
+func endpointName(q DomainQueryType) string {
+	switch q := q.(type) {
+	case *CategorizationQuery:
+		return "categorization"
+	case *CooccurrencesQuery:
+		return "cooccurrences"
+	case *RelatedQuery:
+		return "related"
+	case *SecurityQuery:
+		return "security"
+	case *DomainTagsQuery:
+		return "tags"
+	case *DomainRRHistoryQuery:
+		return "rr-history"
+	case *WhoisQuery:
+		return "whois"
+	case *ProviderQuery:
+		return q.Provider
+	}
+	return "other"
+}
+
+// Tracks how far along a run is: how many domains there are, how many have
+// been written, and how many queries have been made to each endpoint. It
+// is safe for concurrent use.
+type Progress struct {
+	mu         sync.Mutex
+	start      time.Time
+	total      int
+	totalKnown bool
+	read       int
+	done       int
+	last       string
+	queries    map[string]int
+}
+
+func NewProgress() *Progress {
+	return &Progress{start: time.Now(), queries: make(map[string]int)}
+}
+
+// Sets how many domains there are, if that's known before they're read.
+func (p *Progress) SetTotal(n int) {
+	p.mu.Lock()
+	p.total, p.totalKnown = n, true
+	p.mu.Unlock()
+}
+
+// Counts a domain which was read from the input.
+func (p *Progress) Read() {
+	p.mu.Lock()
+	p.read++
+	p.mu.Unlock()
+}
+
+// Counts a domain whose row was written, or which failed.
+func (p *Progress) Done(domain string) {
+	p.mu.Lock()
+	p.done++
+	p.last = domain
+	p.mu.Unlock()
+}
+
+// Counts a query which was made.
+func (p *Progress) Queried(q DomainQueryType) {
+	p.mu.Lock()
+	p.queries[endpointName(q)]++
+	p.mu.Unlock()
+}
+
+// Returns a one-line description of the progress, e.g.
+//
+//	120/1000 domains (12.0%), 35.2 queries/s, ETA 2m5s [categorization 60, security 58]
+//
+// If the total isn't known, it is the number of domains read so far, and
+// there is no ETA.
+func (p *Progress) Line() string {
+	return p.line(time.Now())
+}
+
+func (p *Progress) line(now time.Time) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := p.read
+	if p.totalKnown {
+		total = p.total
+	}
+	parts := []string{fmt.Sprintf("%d/%d domains", p.done, total)}
+	if p.totalKnown && total > 0 {
+		parts[0] += fmt.Sprintf(" (%.1f%%)", 100*float64(p.done)/float64(total))
+	}
+
+	elapsed := now.Sub(p.start)
+	numQueries := 0
+	for _, n := range p.queries {
+		numQueries += n
+	}
+	if elapsed > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f queries/s", float64(numQueries)/elapsed.Seconds()))
+	}
+	if p.totalKnown && p.done > 0 && p.done < total {
+		eta := time.Duration(float64(elapsed) * float64(total-p.done) / float64(p.done))
+		parts = append(parts, "ETA "+eta.Round(time.Second).String())
+	}
+
+	line := strings.Join(parts, ", ")
+	// Investigate's endpoints come first, then providers and anything else
+	// by name
+	known := make(map[string]bool)
+	for _, name := range endpointNames {
+		known[name] = true
+	}
+	others := []string{}
+	for name := range p.queries {
+		if !known[name] {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+
+	endpoints := []string{}
+	for _, name := range append(append([]string{}, endpointNames...), others...) {
+		if n := p.queries[name]; n > 0 {
+			endpoints = append(endpoints, fmt.Sprintf("%s %d", name, n))
+		}
+	}
+	if len(endpoints) > 0 {
+		line += " [" + strings.Join(endpoints, ", ") + "]"
+	}
+	return line
+}
+
+// Returns the domain which was done last.
+func (p *Progress) Last() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.last
+}
@@ -0,0 +1,40 @@
+package domainstats
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressLine(t *testing.T) {
+	t.Parallel()
+	p := NewProgress()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Read()
+			p.Queried(&CategorizationQuery{})
+			p.Queried(&SecurityQuery{})
+			p.Done("a.com")
+		}()
+	}
+	wg.Wait()
+
+	// the total isn't known until it's set
+	ref := "4/4 domains, 4.0 queries/s [categorization 4, security 4]"
+	if test := p.line(p.start.Add(2 * time.Second)); test != ref {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	p.SetTotal(10)
+	ref = "4/10 domains (40.0%), 4.0 queries/s, ETA 3s [categorization 4, security 4]"
+	if test := p.line(p.start.Add(2 * time.Second)); test != ref {
+		t.Fatalf("%v != %v", ref, test)
+	}
+	if test := p.Last(); test != "a.com" {
+		t.Fatalf("%v != %v", "a.com", test)
+	}
+}
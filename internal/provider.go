@@ -0,0 +1,223 @@
+package domainstats
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A source of data about domains besides Investigate, like a GeoIP
+// database, a passive DNS file or an HTTP service. Once a provider is
+// registered with RegisterProvider, config files can have a table named
+// after it, and its columns can be turned on like any others.
+type Provider interface {
+	// The name of the provider's config table, which also starts the paths
+	// of its columns, e.g. "GeoIP" for "GeoIP.Country".
+	Name() string
+
+	// The names of the provider's columns, in the order they're written.
+	Columns() []string
+
+	// Returns a pointer to a new settings struct, which the provider's
+	// config table is decoded into. Each of the provider's columns is
+	// turned on by a bool field with the column's name.
+	NewSettings() interface{}
+
+	// Checks the settings, and returns a source which looks up the given
+	// columns. It is called when the config is loaded, and only if at
+	// least one of the provider's columns is on.
+	Open(settings interface{}, columns []string) (ProviderSource, error)
+}
+
+// Looks up domains for a provider. It has to be safe for concurrent use.
+type ProviderSource interface {
+	Lookup(domain string) (*ProviderResponse, error)
+}
+
+// What a provider found out about a domain
+type ProviderResponse struct {
+	// The name of the provider; set by the query, not the source.
+	Provider string
+
+	// The fields for the columns the source was opened with, in order
+	Fields []string
+
+	// Anything else the provider wants to pass on to library users and
+	// custom sinks
+	Data interface{}
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// Makes a provider available to config files. It should be called before
+// any config is loaded, e.g. from an init function. It panics if the
+// provider's name is taken, like database/sql.Register does.
+func RegisterProvider(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	name := p.Name()
+	if _, ok := providers[name]; ok {
+		panic("domainstats: provider " + name + " is registered twice")
+	}
+	if reflect.ValueOf(Config{}).FieldByName(name).IsValid() {
+		panic("domainstats: provider " + name + " has the same name as a config option")
+	}
+	providers[name] = p
+}
+
+// Returns the registered providers, sorted by name.
+func registeredProviders() []Provider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	ps := []Provider{}
+	for _, p := range providers {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Name() < ps[j].Name() })
+	return ps
+}
+
+func lookupProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// Returns the provider's settings from the config, creating them if the
+// config doesn't have any yet. This modifies the config, so it's only used
+// while loading it.
+func (c *Config) providerSettings(p Provider) reflect.Value {
+	if c.providers == nil {
+		c.providers = make(map[string]interface{})
+	}
+	settings, ok := c.providers[p.Name()]
+	if !ok {
+		settings = p.NewSettings()
+		c.providers[p.Name()] = settings
+	}
+	return reflect.ValueOf(settings).Elem()
+}
+
+// Returns the names of the provider's columns which are on in the config.
+func (c *Config) providerColumns(p Provider) (cols []string) {
+	settings, ok := c.providers[p.Name()]
+	if !ok {
+		return nil
+	}
+	v := reflect.ValueOf(settings).Elem()
+	for _, name := range p.Columns() {
+		if f := v.FieldByName(name); f.Kind() == reflect.Bool && f.Bool() {
+			cols = append(cols, name)
+		}
+	}
+	return cols
+}
+
+// Decodes the tables of registered providers from a config file's
+// contents, and returns an error for each key in them which doesn't match
+// a setting.
+func (c *Config) decodeProviders(contents string) (errs []error, err error) {
+	tables := make(map[string]toml.Primitive)
+	md, err := toml.Decode(contents, &tables)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range registeredProviders() {
+		table, ok := tables[p.Name()]
+		if !ok {
+			continue
+		}
+		if err := md.PrimitiveDecode(table, c.providerSettings(p).Addr().Interface()); err != nil {
+			return nil, fmt.Errorf("%s: %v", p.Name(), err)
+		}
+	}
+
+	for _, key := range md.Undecoded() {
+		if _, ok := lookupProvider(key[0]); ok && len(key) > 1 {
+			errs = append(errs, &UnknownKeyError{key.String(), ""})
+		}
+	}
+	return errs, nil
+}
+
+// Opens a source for each provider with columns on in the config.
+func (c *Config) openProviders() error {
+	c.sources = nil
+	for _, p := range registeredProviders() {
+		cols := c.providerColumns(p)
+		if len(cols) == 0 {
+			continue
+		}
+		src, err := p.Open(c.providers[p.Name()], cols)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p.Name(), err)
+		}
+		c.sources = append(c.sources, providerSource{p.Name(), len(cols), src})
+	}
+	return nil
+}
+
+// an open source, along with how many columns it was opened with
+type providerSource struct {
+	name    string
+	columns int
+	source  ProviderSource
+}
+
+// A query to a provider's source
+type ProviderQuery struct {
+	DomainQuery
+	Provider string
+	source   ProviderSource
+}
+
+func (q *ProviderQuery) Query() DomainQueryResponse {
+	if err := q.blocked(); err != nil {
+		return DomainQueryResponse{Err: err}
+	}
+	resp, err := q.source.Lookup(q.Domain)
+	if err != nil {
+		return DomainQueryResponse{Err: err}
+	}
+	if resp == nil {
+		resp = &ProviderResponse{}
+	}
+	resp.Provider = q.Provider
+	return DomainQueryResponse{Resp: resp}
+}
+
+// Returns the fields of a provider's response, with one per column it was
+// opened with, whatever the source returned.
+func (c *Config) extractProviderInfo(resp *ProviderResponse) []string {
+	n := 0
+	for _, s := range c.sources {
+		if s.name == resp.Provider {
+			n = s.columns
+		}
+	}
+	row := make([]string, n)
+	copy(row, resp.Fields)
+	return row
+}
+
+// Returns the provider and index among its enabled columns of a provider
+// column's path, or false if the path isn't a provider column.
+func (c *Config) providerColumn(path string) (provider string, index int, ok bool) {
+	for _, p := range registeredProviders() {
+		for i, name := range c.providerColumns(p) {
+			if path == p.Name()+"."+name {
+				return p.Name(), i, true
+			}
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,146 @@
+package domainstats
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A provider which looks domains up without going anywhere
+type echoProvider struct{}
+
+type echoSettings struct {
+	Prefix   string
+	Length   bool
+	Reversed bool
+}
+
+type echoSource struct {
+	prefix  string
+	columns []string
+}
+
+func (echoProvider) Name() string             { return "Echo" }
+func (echoProvider) Columns() []string        { return []string{"Length", "Reversed"} }
+func (echoProvider) NewSettings() interface{} { return &echoSettings{} }
+
+func (echoProvider) Open(settings interface{}, columns []string) (ProviderSource, error) {
+	s := settings.(*echoSettings)
+	if s.Prefix == "fail" {
+		return nil, errors.New("can't open")
+	}
+	return &echoSource{s.Prefix, columns}, nil
+}
+
+func (s *echoSource) Lookup(domain string) (*ProviderResponse, error) {
+	resp := &ProviderResponse{Data: len(domain)}
+	for _, col := range s.columns {
+		switch col {
+		case "Length":
+			resp.Fields = append(resp.Fields, s.prefix+strings.Repeat("#", len(domain)))
+		case "Reversed":
+			runes := []rune(domain)
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			resp.Fields = append(resp.Fields, s.prefix+string(runes))
+		}
+	}
+	return resp, nil
+}
+
+func init() {
+	RegisterProvider(echoProvider{})
+}
+
+func loadTestConfig(t *testing.T, contents string) (*Config, []error, error) {
+	t.Setenv(APIKeyEnv, "test-key")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func TestProvider(t *testing.T) {
+	testConfig, warnings, err := loadTestConfig(t, `
+[Echo]
+  Prefix = "x-"
+  Reversed = true
+  Bogus = 1
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), "Echo.Bogus") {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+
+	ref := []string{"Domain", "Reversed"}
+	if test := testConfig.DeriveHeader(); !strSliceEq(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+	if test := testConfig.QueryPlan(); !strSliceEq([]string{"Echo"}, test) {
+		t.Fatalf("%v != %v", []string{"Echo"}, test)
+	}
+
+	msgs := testConfig.DeriveMessages(nil, "abc.com")
+	resp := msgs[0].Q.Query()
+	if resp.Err != nil {
+		t.Fatal(resp.Err)
+	}
+	if pr := resp.Resp.(*ProviderResponse); pr.Provider != "Echo" || pr.Data != 7 {
+		t.Fatalf("unexpected response %+v", pr)
+	}
+	row, err := testConfig.ExtractCSVSubRow(resp.Resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref := []string{"x-moc.cba"}; !strSliceEq(ref, row) {
+		t.Fatalf("%v != %v", ref, row)
+	}
+
+	// the egress policy applies to providers too
+	if resp := testConfig.DeriveMessages(nil, "intranet")[0].Q.Query(); resp.Err == nil {
+		t.Fatal("a single-label name should have been blocked")
+	}
+}
+
+func TestProviderColumns(t *testing.T) {
+	testConfig, _, err := loadTestConfig(t, `
+Columns = ["Domain", "Echo.Length as Len"]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := []string{"Domain", "Len"}
+	if test := testConfig.DeriveHeader(); !strSliceEq(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	found := false
+	for _, path := range KnownColumns() {
+		found = found || path == "Echo.Reversed"
+	}
+	if !found {
+		t.Fatal("Echo.Reversed should be a known column")
+	}
+
+	fields := testConfig.parquetFields()
+	if len(fields) != 2 {
+		t.Fatalf("%v != %v", 2, len(fields))
+	}
+	row := Row{Domain: "ab.com", Responses: []interface{}{
+		&ProviderResponse{Provider: "Echo", Fields: []string{"######"}}}}
+	if test := fields[1].value(row); test != "######" {
+		t.Fatalf("%v != %v", "######", test)
+	}
+}
+
+func TestProviderOpenError(t *testing.T) {
+	if _, _, err := loadTestConfig(t, "[Echo]\n  Prefix = \"fail\"\n  Length = true\n"); err == nil {
+		t.Fatal("a provider which can't be opened should make the config unusable")
+	}
+}
@@ -0,0 +1,237 @@
+package domainstats
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// The Public Suffix List (https://publicsuffix.org/list/), both its ICANN
+// and private sections. To update it, replace public_suffix_list.dat with
+// the latest copy. Embedding it needs Go 1.16 or later.
+//
+//go:embed public_suffix_list.dat
+var publicSuffixList string
+
+// the kinds of rule for a suffix, which may be combined
+const (
+	suffixRule    = 1 << iota // e.g. "co.uk"
+	wildcardRule              // e.g. "*.ck", stored under "ck"
+	exceptionRule             // e.g. "!www.ck", stored under "www.ck"
+)
+
+var (
+	suffixRulesOnce sync.Once
+	suffixRules     map[string]int
+)
+
+// Parses the Public Suffix List. Rules with non-ASCII labels are stored in
+// their punycode form.
+func loadSuffixRules() {
+	suffixRules = make(map[string]int)
+	for _, line := range strings.Split(publicSuffixList, "\n") {
+		rule := strings.TrimSpace(line)
+		if rule == "" || strings.HasPrefix(rule, "//") {
+			continue
+		}
+		// anything after whitespace is a comment
+		if i := strings.IndexAny(rule, " \t"); i != -1 {
+			rule = rule[:i]
+		}
+
+		kind := suffixRule
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			kind, rule = exceptionRule, rule[1:]
+		case strings.HasPrefix(rule, "*."):
+			kind, rule = wildcardRule, rule[2:]
+		}
+		suffixRules[asciiDomain(strings.ToLower(rule))] |= kind
+	}
+}
+
+// Returns the domain's public suffix (its effective TLD), e.g. "co.uk" for
+// "www.example.co.uk", by the rules of the Public Suffix List. A domain with
+// a TLD which isn't on the list has that TLD as its public suffix.
+func PublicSuffix(domain string) string {
+	labels := domainLabels(domain)
+	if len(labels) == 0 {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-publicSuffixLabels(labels):], ".")
+}
+
+// Returns the domain's registered domain (eTLD+1), its public suffix and
+// the label before it, e.g. "example.co.uk" for "www.example.co.uk". It is
+// "" if the domain is itself a public suffix.
+func RegisteredDomain(domain string) string {
+	labels := domainLabels(domain)
+	n := publicSuffixLabels(labels) + 1
+	if n > len(labels) {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+// Splits a domain into labels, lowercased and without a trailing dot.
+func domainLabels(domain string) []string {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" {
+		return nil
+	}
+	return strings.Split(domain, ".")
+}
+
+// Returns the number of labels at the end of labels which make up its
+// public suffix.
+func publicSuffixLabels(labels []string) int {
+	suffixRulesOnce.Do(loadSuffixRules)
+
+	ascii := make([]string, len(labels))
+	for i, l := range labels {
+		ascii[i] = asciiLabel(l)
+	}
+
+	// the longest matching rule wins, but an exception beats the rules it
+	// is an exception to
+	for i := range ascii {
+		kind := suffixRules[strings.Join(ascii[i:], ".")]
+		switch {
+		case kind&exceptionRule != 0:
+			return len(ascii) - i - 1
+		case kind&suffixRule != 0:
+			return len(ascii) - i
+		case i+1 < len(ascii) && suffixRules[strings.Join(ascii[i+1:], ".")]&wildcardRule != 0:
+			return len(ascii) - i
+		}
+	}
+	// the implicit "*" rule
+	if len(labels) == 0 {
+		return 0
+	}
+	return 1
+}
+
+func asciiDomain(domain string) string {
+	labels := strings.Split(domain, ".")
+	for i, l := range labels {
+		labels[i] = asciiLabel(l)
+	}
+	return strings.Join(labels, ".")
+}
+
+// Returns the punycode ("xn--") form of a label with non-ASCII characters,
+// so that IDNs match whichever form they're written in.
+func asciiLabel(label string) string {
+	for i := 0; i < len(label); i++ {
+		if label[i] >= utf8.RuneSelf {
+			return "xn--" + punycode(label)
+		}
+	}
+	return label
+}
+
+// punycode parameters, from RFC 3492
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// Encodes a string with punycode (RFC 3492).
+func punycode(s string) string {
+	runes := []rune(s)
+	out := []byte{}
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(punyInitialN), 0, punyInitialBias
+	for handled := basic; handled < len(runes); {
+		// the smallest code point which hasn't been handled yet
+		m := rune(utf8.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > (punyBase-punyTMin)*punyTMax/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+// Returns the fields for the RegisteredDomain and TLD columns, whichever
+// are enabled in the config.
+func (c *Config) ExtractSuffixSubRow(domain string) []string {
+	row := []string{}
+	if c.RegisteredDomain {
+		row = append(row, RegisteredDomain(domain))
+	}
+	if c.TLD {
+		row = append(row, PublicSuffix(domain))
+	}
+	return row
+}
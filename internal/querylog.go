@@ -0,0 +1,363 @@
+package domainstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The formats the domain list can be read in. "list" is a plain list of
+// domains, one per line, and "pcap" is a packet capture (see PcapReader);
+// the others are resolver logs, from which the queried names are read.
+var InputFormats = []string{"list", "zeek", "bind", "dnsmasq", "pcap"}
+
+// Which columns with the queries of each name to write, when the input is a
+// resolver log or packet capture. Each is empty for a plain list of domains.
+type QueryLogConfig struct {
+	// The number of times the name was queried
+	QueryCount bool
+
+	// When the name was first and last queried
+	FirstSeen bool
+	LastSeen  bool
+}
+
+// A DNS query read from a resolver log. Time is zero if the log doesn't
+// say when the query was made.
+type LogQuery struct {
+	Name string
+	Time time.Time
+}
+
+// Reads queries from the lines of a resolver log.
+type QueryLogParser interface {
+	// Returns the query on the line, or false if it doesn't have one, like
+	// a comment or another kind of message.
+	ParseLine(line string) (LogQuery, bool)
+}
+
+// Returns a parser for the given input format, or nil for the formats which
+// aren't resolver logs: "list" and "pcap".
+func NewQueryLogParser(format string) (QueryLogParser, error) {
+	switch format {
+	case "list", "pcap":
+		return nil, nil
+	case "zeek":
+		return &zeekParser{separator: "\t"}, nil
+	case "bind":
+		return bindParser{}, nil
+	case "dnsmasq":
+		return dnsmasqParser{now: time.Now}, nil
+	default:
+		return nil, fmt.Errorf("unknown input format %q (formats: %s)",
+			format, strings.Join(InputFormats, ", "))
+	}
+}
+
+// Normalizes a queried name, so that queries of the same name are counted
+// together. It returns "" for names which can't be looked up.
+func queryName(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	if name == "" || name == "-" || name == "(empty)" {
+		return ""
+	}
+	return name
+}
+
+// Reads Zeek dns.log files, either as TSV, with the header Zeek writes, or
+// as JSON, one object per line.
+type zeekParser struct {
+	separator string
+
+	// the columns of the timestamp and query, from the #fields header
+	tsIndex, queryIndex int
+	haveFields          bool
+}
+
+func (p *zeekParser) ParseLine(line string) (LogQuery, bool) {
+	switch {
+	case strings.HasPrefix(line, "{"):
+		return p.parseJSON(line)
+	case strings.HasPrefix(line, "#"):
+		p.parseHeader(line)
+		return LogQuery{}, false
+	case !p.haveFields:
+		return LogQuery{}, false
+	}
+
+	fields := strings.Split(line, p.separator)
+	if p.queryIndex >= len(fields) {
+		return LogQuery{}, false
+	}
+	name := queryName(fields[p.queryIndex])
+	if name == "" {
+		return LogQuery{}, false
+	}
+	q := LogQuery{Name: name}
+	if p.tsIndex != -1 && p.tsIndex < len(fields) {
+		q.Time = parseEpoch(fields[p.tsIndex])
+	}
+	return q, true
+}
+
+func (p *zeekParser) parseHeader(line string) {
+	switch {
+	case strings.HasPrefix(line, "#separator "):
+		sep := strings.TrimPrefix(line, "#separator ")
+		if unquoted, err := strconv.Unquote(`"` + sep + `"`); err == nil {
+			sep = unquoted
+		}
+		p.separator = sep
+	case strings.HasPrefix(line, "#fields"):
+		p.tsIndex, p.queryIndex = -1, -1
+		for i, f := range strings.Split(line, p.separator)[1:] {
+			switch f {
+			case "ts":
+				p.tsIndex = i
+			case "query":
+				p.queryIndex = i
+			}
+		}
+		p.haveFields = p.queryIndex != -1
+	}
+}
+
+func (p *zeekParser) parseJSON(line string) (LogQuery, bool) {
+	var entry struct {
+		TS    json.RawMessage
+		Query string
+	}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return LogQuery{}, false
+	}
+	name := queryName(entry.Query)
+	if name == "" {
+		return LogQuery{}, false
+	}
+
+	// the timestamp is either seconds since the epoch or, with
+	// JSON::TS_ISO8601, a string
+	q := LogQuery{Name: name}
+	var iso string
+	if err := json.Unmarshal(entry.TS, &iso); err == nil {
+		q.Time, _ = time.Parse(time.RFC3339Nano, iso)
+	} else {
+		q.Time = parseEpoch(string(entry.TS))
+	}
+	return q, true
+}
+
+// Parses seconds since the epoch, with a fraction, as Zeek writes times.
+// The fraction is parsed as digits, since a float64 can't hold a
+// nanosecond-precision time.
+func parseEpoch(s string) time.Time {
+	secs, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		secs, frac = s[:i], s[i+1:]
+	}
+	if len(frac) > 9 {
+		frac = frac[:9]
+	}
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	nsec, err := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, nsec).UTC()
+}
+
+// Reads BIND query logs, with lines like
+//
+//	30-Jun-2015 12:00:00.123 queries: info: client @0x7f 10.0.0.1#53421 (example.com): query: example.com IN A +E(0)K (10.0.0.53)
+//
+// The timestamp is only read if BIND wrote it (print-time yes); lines which
+// came through syslog have no time.
+type bindParser struct{}
+
+const bindTimeLayout = "02-Jan-2006 15:04:05.000"
+
+func (bindParser) ParseLine(line string) (LogQuery, bool) {
+	i := strings.Index(line, " query: ")
+	if i == -1 {
+		return LogQuery{}, false
+	}
+	fields := strings.Fields(line[i+len(" query: "):])
+	if len(fields) == 0 {
+		return LogQuery{}, false
+	}
+	name := queryName(fields[0])
+	if name == "" {
+		return LogQuery{}, false
+	}
+
+	q := LogQuery{Name: name}
+	if len(line) >= len(bindTimeLayout) {
+		q.Time, _ = time.ParseInLocation(bindTimeLayout, line[:len(bindTimeLayout)], time.Local)
+	}
+	return q, true
+}
+
+// Reads dnsmasq logs (log-queries), with lines like
+//
+//	Jun 30 12:00:00 dnsmasq[1234]: query[A] example.com from 10.0.0.1
+//
+// Syslog timestamps have no year, so the current one is assumed, unless
+// that would put the query in the future.
+type dnsmasqParser struct {
+	now func() time.Time
+}
+
+func (p dnsmasqParser) ParseLine(line string) (LogQuery, bool) {
+	i := strings.Index(line, " query[")
+	if i == -1 {
+		return LogQuery{}, false
+	}
+	rest := line[i+len(" query["):]
+	j := strings.Index(rest, "] ")
+	if j == -1 {
+		return LogQuery{}, false
+	}
+	fields := strings.Fields(rest[j+2:])
+	if len(fields) == 0 {
+		return LogQuery{}, false
+	}
+	name := queryName(fields[0])
+	if name == "" {
+		return LogQuery{}, false
+	}
+	return LogQuery{Name: name, Time: parseSyslogTime(line, p.now())}, true
+}
+
+const syslogTimeLayout = "Jan _2 15:04:05"
+
+// Parses the timestamp at the start of a syslog line, which has no year.
+func parseSyslogTime(line string, now time.Time) time.Time {
+	if len(line) < len(syslogTimeLayout) {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation(syslogTimeLayout, line[:len(syslogTimeLayout)], time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	t = t.AddDate(now.Year(), 0, 0)
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// The queries of a name seen in a resolver log
+type QueryStats struct {
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+func (s *QueryStats) add(t time.Time) {
+	s.Count++
+	if t.IsZero() {
+		return
+	}
+	if s.FirstSeen.IsZero() || t.Before(s.FirstSeen) {
+		s.FirstSeen = t
+	}
+	if t.After(s.LastSeen) {
+		s.LastSeen = t
+	}
+}
+
+// Counts the queries of each name in a resolver log.
+type QueryAggregator struct {
+	stats map[string]*QueryStats
+
+	// the names, in the order they were first seen
+	names []string
+
+	// when each name was last added, for Prune
+	added     map[string]time.Time
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func NewQueryAggregator() *QueryAggregator {
+	return &QueryAggregator{
+		stats:     make(map[string]*QueryStats),
+		added:     make(map[string]time.Time),
+		lastPrune: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Adds the query to its name's stats, and returns a copy of them.
+func (a *QueryAggregator) Add(q LogQuery) QueryStats {
+	s, ok := a.stats[q.Name]
+	if !ok {
+		s = &QueryStats{}
+		a.stats[q.Name] = s
+		a.names = append(a.names, q.Name)
+	}
+	s.add(q.Time)
+	a.added[q.Name] = a.now()
+	return *s
+}
+
+// Forgets the names which haven't been added for maxAge, so that following
+// a log doesn't make the stats grow forever. The names are only looked
+// through once per maxAge; if it isn't positive, every name is forgotten.
+func (a *QueryAggregator) Prune(maxAge time.Duration) {
+	now := a.now()
+	if now.Sub(a.lastPrune) < maxAge {
+		return
+	}
+	names := a.names[:0]
+	for _, name := range a.names {
+		if now.Sub(a.added[name]) >= maxAge {
+			delete(a.stats, name)
+			delete(a.added, name)
+		} else {
+			names = append(names, name)
+		}
+	}
+	a.names = names
+	a.lastPrune = now
+}
+
+// Returns the names queried, in the order they were first seen.
+func (a *QueryAggregator) Names() []string {
+	return a.names
+}
+
+// Returns the stats of the name, or nil if it wasn't queried.
+func (a *QueryAggregator) Stats(name string) *QueryStats {
+	return a.stats[name]
+}
+
+// Returns the fields for the QueryLog columns enabled in the config. They
+// are empty if stats is nil, as for a plain list of domains.
+func (c *Config) ExtractQueryStatsSubRow(stats *QueryStats) []string {
+	count, first, last := "", "", ""
+	if stats != nil {
+		count = strconv.Itoa(stats.Count)
+		if !stats.FirstSeen.IsZero() {
+			first = stats.FirstSeen.UTC().Format(time.RFC3339)
+			last = stats.LastSeen.UTC().Format(time.RFC3339)
+		}
+	}
+
+	row := []string{}
+	if c.QueryLog.QueryCount {
+		row = append(row, count)
+	}
+	if c.QueryLog.FirstSeen {
+		row = append(row, first)
+	}
+	if c.QueryLog.LastSeen {
+		row = append(row, last)
+	}
+	return row
+}
@@ -0,0 +1,152 @@
+package domainstats
+
+import (
+	"testing"
+	"time"
+)
+
+func parseLines(t *testing.T, format string, lines []string) []LogQuery {
+	parser, err := NewQueryLogParser(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []LogQuery{}
+	for _, line := range lines {
+		if q, ok := parser.ParseLine(line); ok {
+			queries = append(queries, q)
+		}
+	}
+	return queries
+}
+
+func checkQueries(t *testing.T, ref, test []LogQuery) {
+	if len(ref) != len(test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+	for i := range ref {
+		if ref[i].Name != test[i].Name || !ref[i].Time.Equal(test[i].Time) {
+			t.Fatalf("%v != %v", ref[i], test[i])
+		}
+	}
+}
+
+func TestZeekParser(t *testing.T) {
+	t.Parallel()
+	lines := []string{
+		`#separator \x09`,
+		`#set_separator	,`,
+		"#fields\tts\tuid\tid.orig_h\tquery\tqtype_name",
+		"#types\ttime\tstring\taddr\tstring\tstring",
+		"1435665600.500000\tCa1\t10.0.0.1\tWWW.Example.com.\tA",
+		"1435665601.000000\tCa2\t10.0.0.1\t-\tA",
+		"#close\t2015-06-30-12-00-01",
+		`{"ts":1435665602.25,"uid":"Ca3","query":"bad.com","qtype_name":"A"}`,
+		`{"ts":"2015-06-30T12:00:03.000000Z","uid":"Ca4","query":"bad.com"}`,
+		`{"ts":1435665604.0,"uid":"Ca5"}`,
+	}
+	ref := []LogQuery{
+		{"www.example.com", time.Unix(1435665600, 5e8)},
+		{"bad.com", time.Unix(1435665602, 25e7)},
+		{"bad.com", time.Unix(1435665603, 0)},
+	}
+	checkQueries(t, ref, parseLines(t, "zeek", lines))
+
+	// TSV lines can't be read without the #fields header
+	checkQueries(t, []LogQuery{}, parseLines(t, "zeek", lines[4:5]))
+}
+
+func TestBINDParser(t *testing.T) {
+	t.Parallel()
+	lines := []string{
+		"30-Jun-2015 12:00:00.123 queries: info: client @0x7f0a 10.0.0.1#53421 " +
+			"(Example.com): query: Example.com IN A +E(0)K (10.0.0.53)",
+		"Jun 30 12:00:01 ns1 named[99]: client 10.0.0.2#1234: query: bad.com IN AAAA +",
+		"30-Jun-2015 12:00:02.000 general: info: zone example.com/IN: loaded serial 1",
+	}
+	ref := []LogQuery{
+		{"example.com", time.Date(2015, 6, 30, 12, 0, 0, 123e6, time.Local)},
+		{"bad.com", time.Time{}},
+	}
+	checkQueries(t, ref, parseLines(t, "bind", lines))
+}
+
+func TestDnsmasqParser(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, 1, 2, 0, 0, 0, 0, time.Local)
+	p := dnsmasqParser{now: func() time.Time { return now }}
+
+	test := []LogQuery{}
+	for _, line := range []string{
+		"Jan  1 12:00:00 dnsmasq[1234]: query[A] example.com from 10.0.0.1",
+		"Jan  1 12:00:00 dnsmasq[1234]: forwarded example.com to 8.8.8.8",
+		"Dec 31 23:59:59 dnsmasq[1234]: query[AAAA] bad.com from 10.0.0.2",
+	} {
+		if q, ok := p.ParseLine(line); ok {
+			test = append(test, q)
+		}
+	}
+
+	// December is last year's, since this year's would be in the future
+	ref := []LogQuery{
+		{"example.com", time.Date(2015, 1, 1, 12, 0, 0, 0, time.Local)},
+		{"bad.com", time.Date(2014, 12, 31, 23, 59, 59, 0, time.Local)},
+	}
+	checkQueries(t, ref, test)
+}
+
+func TestQueryAggregator(t *testing.T) {
+	t.Parallel()
+	t1 := time.Date(2015, 6, 30, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	a := NewQueryAggregator()
+	a.Add(LogQuery{"b.com", t2})
+	a.Add(LogQuery{"a.com", time.Time{}})
+	if s := a.Add(LogQuery{"b.com", t1}); s.Count != 2 {
+		t.Fatalf("%v != %v", 2, s.Count)
+	}
+
+	if ref, test := []string{"b.com", "a.com"}, a.Names(); !strSliceEq(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	varConfig := Config{QueryLog: QueryLogConfig{QueryCount: true, FirstSeen: true, LastSeen: true}}
+	for _, c := range []struct {
+		stats *QueryStats
+		ref   []string
+	}{
+		{a.Stats("b.com"), []string{"2", "2015-06-30T12:00:00Z", "2015-06-30T13:00:00Z"}},
+		{a.Stats("a.com"), []string{"1", "", ""}},
+		{nil, []string{"", "", ""}},
+	} {
+		if test := varConfig.ExtractQueryStatsSubRow(c.stats); !strSliceEq(c.ref, test) {
+			t.Fatalf("%v != %v", c.ref, test)
+		}
+	}
+}
+
+func TestQueryAggregatorPrune(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2015, 6, 30, 12, 0, 0, 0, time.UTC)
+	a := NewQueryAggregator()
+	a.now = func() time.Time { return now }
+	a.lastPrune = now
+
+	a.Add(LogQuery{"a.com", time.Time{}})
+	now = now.Add(30 * time.Minute)
+	a.Add(LogQuery{"b.com", time.Time{}})
+	now = now.Add(30 * time.Minute)
+	a.Prune(time.Hour)
+	if a.Stats("a.com") != nil || a.Stats("b.com") == nil {
+		t.Fatalf("only a.com should have been forgotten: %v", a.Names())
+	}
+	if ref, test := []string{"b.com"}, a.Names(); !strSliceEq(ref, test) {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	// without a window, nothing is kept
+	a.Prune(0)
+	if len(a.Names()) != 0 || len(a.stats) != 0 {
+		t.Fatalf("every name should have been forgotten: %v", a.Names())
+	}
+}
@@ -0,0 +1,40 @@
+package domainstats
+
+// Puts rows which may arrive in any order back into input order.
+//
+// The buffer itself is unbounded; callers are expected to bound it by
+// limiting how many lines can be in flight at once.
+type ReorderBuffer struct {
+	next    int
+	pending map[int]Row
+}
+
+// Returns a new ReorderBuffer which expects the first row to have the
+// given line number.
+func NewReorderBuffer(first int) *ReorderBuffer {
+	return &ReorderBuffer{
+		next:    first,
+		pending: make(map[int]Row),
+	}
+}
+
+// Adds a row to the buffer and returns the rows which are now ready to be
+// written, in order. The returned slice is empty if row is not the next
+// expected line.
+func (b *ReorderBuffer) Push(row Row) (ready []Row) {
+	b.pending[row.Line] = row
+	for {
+		r, ok := b.pending[b.next]
+		if !ok {
+			return ready
+		}
+		delete(b.pending, b.next)
+		ready = append(ready, r)
+		b.next++
+	}
+}
+
+// Returns the number of rows waiting for an earlier line to arrive.
+func (b *ReorderBuffer) Len() int {
+	return len(b.pending)
+}
@@ -0,0 +1,41 @@
+package domainstats
+
+import "testing"
+
+func TestReorderBuffer(t *testing.T) {
+	t.Parallel()
+	b := NewReorderBuffer(1)
+
+	lines := func(rows []Row) []int {
+		ls := []int{}
+		for _, r := range rows {
+			ls = append(ls, r.Line)
+		}
+		return ls
+	}
+	verify := func(test []Row, ref ...int) {
+		testLines := lines(test)
+		if len(testLines) != len(ref) {
+			t.Fatalf("%v != %v", testLines, ref)
+		}
+		for i := range ref {
+			if testLines[i] != ref[i] {
+				t.Fatalf("%v != %v", testLines, ref)
+			}
+		}
+	}
+
+	verify(b.Push(Row{Line: 3, Fields: []string{"c.com"}}))
+	verify(b.Push(Row{Line: 2, Fields: []string{"b.com"}}))
+	if b.Len() != 2 {
+		t.Fatalf("b.Len() = %d, but should = 2", b.Len())
+	}
+	verify(b.Push(Row{Line: 1, Fields: []string{"a.com"}}), 1, 2, 3)
+	verify(b.Push(Row{Line: 4}), 4)
+	verify(b.Push(Row{Line: 6, Fields: []string{"f.com"}}))
+	verify(b.Push(Row{Line: 5, Fields: []string{"e.com"}}), 5, 6)
+
+	if b.Len() != 0 {
+		t.Fatalf("b.Len() = %d, but should = 0", b.Len())
+	}
+}
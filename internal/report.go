@@ -0,0 +1,381 @@
+package domainstats
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// how many entries the top lists of a report have
+const reportTopN = 10
+
+// how many failed domains the text report lists
+const reportMaxFailedText = 20
+
+// how many bins the distributions of a report have
+const reportBins = 10
+
+// An end-of-run summary of the rows which were written: how many domains
+// were queried, skipped or failed, and what Investigate said about them.
+// It isn't safe for concurrent use.
+type Report struct {
+	domains, skipped, blocked int
+	status                    map[int]int
+
+	securityCategories counter
+	contentCategories  counter
+	asns               counter
+	countries          counter
+	cooccurrences      counter
+
+	secureRank2 []float64
+	dgaScore    []float64
+
+	failed []FailedDomain
+}
+
+// A domain whose queries failed
+type FailedDomain struct {
+	Domain string
+	Error  string
+}
+
+type counter map[string]int
+
+func NewReport() *Report {
+	return &Report{
+		status:             make(map[int]int),
+		securityCategories: make(counter),
+		contentCategories:  make(counter),
+		asns:               make(counter),
+		countries:          make(counter),
+		cooccurrences:      make(counter),
+	}
+}
+
+// Adds a row to the report, including rows whose queries failed.
+func (r *Report) Add(row Row) {
+	r.domains++
+	switch {
+	case strings.HasPrefix(row.Skipped, BlockedReasonPrefix):
+		r.blocked++
+		return
+	case row.Skipped != "":
+		r.skipped++
+		return
+	case row.Fields == nil:
+		errStr := "unknown error"
+		if row.Err != nil {
+			errStr = row.Err.Error()
+		}
+		r.failed = append(r.failed, FailedDomain{row.Domain, errStr})
+		return
+	}
+
+	for _, resp := range row.Responses {
+		switch resp := resp.(type) {
+		case *goinvestigate.DomainCategorization:
+			r.status[resp.Status]++
+			r.securityCategories.addAll(resp.SecurityCategories)
+			r.contentCategories.addAll(resp.ContentCategories)
+		case *goinvestigate.SecurityFeatures:
+			r.secureRank2 = append(r.secureRank2, resp.SecureRank2)
+			r.dgaScore = append(r.dgaScore, resp.DGAScore)
+		case *goinvestigate.DomainRRHistory:
+			asns := []string{}
+			for _, asn := range resp.RRFeatures.ASNs {
+				asns = append(asns, "AS"+strconv.Itoa(asn))
+			}
+			r.asns.addAll(asns)
+			r.countries.addAll(resp.RRFeatures.CountryCodes)
+		case []goinvestigate.Cooccurrence:
+			domains := []string{}
+			for _, cooc := range resp {
+				domains = append(domains, cooc.Domain)
+			}
+			r.cooccurrences.addAll(domains)
+		}
+	}
+}
+
+// Counts each distinct value once.
+func (c counter) addAll(values []string) {
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			c[v]++
+		}
+	}
+}
+
+// Returns the n values with the highest counts, highest first, with ties
+// broken by name.
+func (c counter) top(n int) []reportCount {
+	counts := []reportCount{}
+	for name, count := range c {
+		counts = append(counts, reportCount{Name: name, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return scaleCounts(counts)
+}
+
+// A count in a report, with its size relative to the largest one in its
+// list, in percent, for drawing bars.
+type reportCount struct {
+	Name  string
+	Count int
+	Bar   float64
+}
+
+func scaleCounts(counts []reportCount) []reportCount {
+	largest := 0
+	for _, c := range counts {
+		if c.Count > largest {
+			largest = c.Count
+		}
+	}
+	for i := range counts {
+		if largest > 0 {
+			counts[i].Bar = 100 * float64(counts[i].Count) / float64(largest)
+		}
+	}
+	return counts
+}
+
+type reportList struct {
+	Title  string
+	Counts []reportCount
+}
+
+type reportDistribution struct {
+	Title                      string
+	Count                      int
+	Min, P25, Median, P75, Max float64
+	Bins                       []reportCount
+}
+
+// Summarizes a list of values with their quantiles and a histogram of
+// equal-width bins.
+func newReportDistribution(title string, values []float64) reportDistribution {
+	d := reportDistribution{Title: title, Count: len(values)}
+	if len(values) == 0 {
+		return d
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	d.Min, d.Max = sorted[0], sorted[len(sorted)-1]
+	d.P25, d.Median, d.P75 = quantile(sorted, 0.25), quantile(sorted, 0.5), quantile(sorted, 0.75)
+
+	bins := reportBins
+	if d.Min == d.Max {
+		bins = 1
+	}
+	width := (d.Max - d.Min) / float64(bins)
+	counts := make([]int, bins)
+	for _, v := range sorted {
+		i := bins - 1
+		if width > 0 {
+			i = int(math.Min(float64(bins-1), (v-d.Min)/width))
+		}
+		counts[i]++
+	}
+	for i, count := range counts {
+		lo := d.Min + float64(i)*width
+		d.Bins = append(d.Bins, reportCount{
+			Name:  formatReportFloat(lo) + " to " + formatReportFloat(lo+width),
+			Count: count,
+		})
+	}
+	d.Bins = scaleCounts(d.Bins)
+	return d
+}
+
+// Returns the q quantile of sorted values, interpolating between the two
+// nearest values.
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	lo := int(pos)
+	if lo+1 >= len(sorted) {
+		return sorted[lo]
+	}
+	return sorted[lo] + (pos-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+func formatReportFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+// everything a report shows, ready to be rendered
+type reportView struct {
+	Generated     string
+	Domains       int
+	Queried       int
+	Skipped       int
+	Blocked       int
+	Status        []reportCount
+	Lists         []reportList
+	Distributions []reportDistribution
+	Failed        []FailedDomain
+}
+
+var statusNames = map[int]string{-1: "malicious", 0: "unclassified", 1: "benign"}
+
+func (r *Report) view() reportView {
+	v := reportView{
+		Generated: time.Now().Format(time.RFC1123),
+		Domains:   r.domains,
+		Queried:   r.domains - r.skipped - r.blocked - len(r.failed),
+		Skipped:   r.skipped,
+		Blocked:   r.blocked,
+		Failed:    r.failed,
+	}
+
+	statuses := []int{}
+	for status := range r.status {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		name := strconv.Itoa(status)
+		if n, ok := statusNames[status]; ok {
+			name += " (" + n + ")"
+		}
+		v.Status = append(v.Status, reportCount{Name: name, Count: r.status[status]})
+	}
+	v.Status = scaleCounts(v.Status)
+
+	for _, l := range []struct {
+		title string
+		c     counter
+	}{
+		{"Top security categories", r.securityCategories},
+		{"Top content categories", r.contentCategories},
+		{"Top ASNs", r.asns},
+		{"Top countries", r.countries},
+		{"Most co-occurring domains", r.cooccurrences},
+	} {
+		if len(l.c) > 0 {
+			v.Lists = append(v.Lists, reportList{l.title, l.c.top(reportTopN)})
+		}
+	}
+
+	for _, d := range []reportDistribution{
+		newReportDistribution("SecureRank2", r.secureRank2),
+		newReportDistribution("DGAScore", r.dgaScore),
+	} {
+		if d.Count > 0 {
+			v.Distributions = append(v.Distributions, d)
+		}
+	}
+	return v
+}
+
+// Writes the report as plain text, for a terminal.
+func (r *Report) WriteText(w io.Writer) error {
+	v := r.view()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Summary of %d domains\n", v.Domains)
+	fmt.Fprintf(tw, "  queried: %d, skipped: %d, blocked: %d, failed: %d\n",
+		v.Queried, v.Skipped, v.Blocked, len(v.Failed))
+
+	lists := v.Lists
+	if len(v.Status) > 0 {
+		lists = append([]reportList{{"Status", v.Status}}, lists...)
+	}
+	for _, l := range lists {
+		fmt.Fprintf(tw, "\n%s\n", l.Title)
+		for _, c := range l.Counts {
+			fmt.Fprintf(tw, "  %s\t%d\n", c.Name, c.Count)
+		}
+	}
+
+	for _, d := range v.Distributions {
+		fmt.Fprintf(tw, "\n%s (%d domains)\n", d.Title, d.Count)
+		fmt.Fprintf(tw, "  min %s\tp25 %s\tmedian %s\tp75 %s\tmax %s\n",
+			formatReportFloat(d.Min), formatReportFloat(d.P25), formatReportFloat(d.Median),
+			formatReportFloat(d.P75), formatReportFloat(d.Max))
+	}
+
+	if len(v.Failed) > 0 {
+		fmt.Fprintf(tw, "\nFailed domains\n")
+		for i, f := range v.Failed {
+			if i == reportMaxFailedText {
+				fmt.Fprintf(tw, "  ... and %d more\n", len(v.Failed)-i)
+				break
+			}
+			fmt.Fprintf(tw, "  %s\t%s\n", f.Domain, f.Error)
+		}
+	}
+	return tw.Flush()
+}
+
+// Writes the report as a self-contained HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r.view())
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"float": formatReportFloat,
+	"bar":   func(f float64) template.CSS { return template.CSS(fmt.Sprintf("width: %.1f%%", f)) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>domainstats report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h2 { margin-top: 1.5em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: left; vertical-align: middle; }
+td.count { text-align: right; }
+td.bar { width: 20em; }
+td.bar div { background: #4a7ebb; height: 0.8em; }
+.lists { display: flex; flex-wrap: wrap; gap: 0 3em; }
+</style>
+</head>
+<body>
+<h1>domainstats report</h1>
+<p>{{.Generated}}</p>
+<table>
+<tr><th>Domains</th><td class="count">{{.Domains}}</td></tr>
+<tr><th>Queried</th><td class="count">{{.Queried}}</td></tr>
+<tr><th>Skipped</th><td class="count">{{.Skipped}}</td></tr>
+<tr><th>Blocked</th><td class="count">{{.Blocked}}</td></tr>
+<tr><th>Failed</th><td class="count">{{len .Failed}}</td></tr>
+</table>
+{{define "counts"}}<table>
+{{range .}}<tr><td>{{.Name}}</td><td class="count">{{.Count}}</td><td class="bar"><div style="{{bar .Bar}}"></div></td></tr>
+{{end}}</table>{{end}}
+<div class="lists">
+{{if .Status}}<div><h2>Status</h2>{{template "counts" .Status}}</div>{{end}}
+{{range .Lists}}<div><h2>{{.Title}}</h2>{{template "counts" .Counts}}</div>
+{{end}}</div>
+{{range .Distributions}}<h2>{{.Title}}</h2>
+<p>{{.Count}} domains: min {{float .Min}}, p25 {{float .P25}}, median {{float .Median}}, p75 {{float .P75}}, max {{float .Max}}</p>
+{{template "counts" .Bins}}
+{{end}}
+{{if .Failed}}<h2>Failed domains</h2>
+<table>
+{{range .Failed}}<tr><td>{{.Domain}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,122 @@
+package domainstats
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+func testReport() *Report {
+	report := NewReport()
+	for i, status := range []int{-1, -1, 1} {
+		report.Add(Row{Domain: "d.com", Fields: []string{"d.com"}, Responses: []interface{}{
+			&goinvestigate.DomainCategorization{Status: status,
+				SecurityCategories: []string{"Malware", "Malware"}, ContentCategories: []string{"Blogs"}},
+			&goinvestigate.SecurityFeatures{SecureRank2: float64(i * 10), DGAScore: -float64(i)},
+			&goinvestigate.DomainRRHistory{RRFeatures: goinvestigate.DomainResourceRecordFeatures{
+				ASNs: []int{36692}, CountryCodes: []string{"US"}}},
+			[]goinvestigate.Cooccurrence{{Domain: "evil.com", Score: 0.5}},
+		}})
+	}
+	report.Add(Row{Domain: "google.com", Fields: []string{"google.com"}, Skipped: "rank 1"})
+	report.Add(Row{Domain: "intranet", Fields: []string{"intranet"},
+		Skipped: BlockedReasonPrefix + "single-label name"})
+	report.Add(Row{Domain: "<b>fail.com</b>", Err: errors.New("timeout")})
+	return report
+}
+
+func TestReportText(t *testing.T) {
+	t.Parallel()
+	buf := &bytes.Buffer{}
+	if err := testReport().WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	for _, ref := range []string{
+		"Summary of 6 domains\n",
+		"queried: 3, skipped: 1, blocked: 1, failed: 1\n",
+		"  -1 (malicious)  2\n",
+		"  1 (benign)      1\n",
+		"Top security categories\n  Malware  3\n",
+		"Top ASNs\n  AS36692  3\n",
+		"Top countries\n  US  3\n",
+		"Most co-occurring domains\n  evil.com  3\n",
+		"SecureRank2 (3 domains)\n  min 0.00  p25 5.00  median 10.00  p75 15.00  max 20.00\n",
+		"Failed domains\n  <b>fail.com</b>  timeout\n",
+	} {
+		if !strings.Contains(text, ref) {
+			t.Fatalf("%q is not in the report:\n%s", ref, text)
+		}
+	}
+}
+
+func TestReportHTML(t *testing.T) {
+	t.Parallel()
+	buf := &bytes.Buffer{}
+	if err := testReport().WriteHTML(buf); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	if !strings.Contains(html, "&lt;b&gt;fail.com&lt;/b&gt;") {
+		t.Fatalf("failed domains should be escaped:\n%s", html)
+	}
+	// the page shouldn't need anything else to be shown
+	for _, s := range []string{"<script", "<link", "src="} {
+		if strings.Contains(html, s) {
+			t.Fatalf("%q should not be in the report", s)
+		}
+	}
+	if !strings.Contains(html, `style="width: 100.0%"`) {
+		t.Fatalf("the largest count should have a full bar:\n%s", html)
+	}
+}
+
+func TestReportDistribution(t *testing.T) {
+	t.Parallel()
+	d := newReportDistribution("DGAScore", []float64{-100, 0, -50, -100})
+	if d.Min != -100 || d.Max != 0 || d.Median != -75 {
+		t.Fatalf("unexpected distribution %+v", d)
+	}
+	counts := []int{}
+	for _, b := range d.Bins {
+		counts = append(counts, b.Count)
+	}
+	ref := []int{2, 0, 0, 0, 0, 1, 0, 0, 0, 1}
+	if len(counts) != len(ref) {
+		t.Fatalf("%v != %v", ref, counts)
+	}
+	for i := range ref {
+		if ref[i] != counts[i] {
+			t.Fatalf("%v != %v", ref, counts)
+		}
+	}
+
+	// a single value goes in a single bin
+	if d := newReportDistribution("DGAScore", []float64{1, 1}); len(d.Bins) != 1 || d.Bins[0].Count != 2 {
+		t.Fatalf("unexpected distribution %+v", d)
+	}
+}
+
+func TestReportCooccurrences(t *testing.T) {
+	t.Parallel()
+	report := NewReport()
+	for _, cooc := range [][]goinvestigate.Cooccurrence{
+		{{Domain: "b.com", Score: 0.25}, {Domain: "c.com", Score: 0.5}, {Domain: "c.com", Score: 0.1}},
+		{{Domain: "c.com", Score: 0.3}},
+	} {
+		report.Add(Row{Domain: "a.com", Fields: []string{"a.com"}, Responses: []interface{}{cooc}})
+	}
+
+	buf := &bytes.Buffer{}
+	if err := report.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+	// each domain is counted once per row, however often it's listed
+	ref := "Most co-occurring domains\n  c.com  2\n  b.com  1\n"
+	if text := buf.String(); !strings.Contains(text, ref) {
+		t.Fatalf("%q is not in the report:\n%s", ref, text)
+	}
+}
@@ -0,0 +1,138 @@
+package domainstats
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dead10ck/goinvestigate"
+)
+
+// Returned by GenerateDefaultConfig if the default config already exists
+// and it was not told to overwrite it.
+var ErrConfigExists = errors.New("config file already exists")
+
+// An Investigate endpoint which can be turned on as a whole during setup
+type Endpoint struct {
+	Name        string
+	Description string
+	enable      func(c *Config)
+}
+
+// Turns on every field of the endpoint in the given config.
+func (e Endpoint) Enable(c *Config) {
+	e.enable(c)
+}
+
+// Returns the endpoints which can be queried, in the order they are queried.
+func Endpoints() []Endpoint {
+	all := defaultConfig()
+	return []Endpoint{
+		{"Categorization", "status and security/content categories",
+			func(c *Config) { c.Status, c.Categories = true, all.Categories }},
+		{"Cooccurrences", "domains queried around the same time",
+			func(c *Config) { c.Cooccurrences = all.Cooccurrences }},
+		{"Related", "related domains",
+			func(c *Config) { c.Related = all.Related }},
+		{"Security", "security scores, e.g. SecureRank2 and DGA score",
+			func(c *Config) { c.Security = all.Security }},
+		{"TaggingDates", "when the domain was tagged as malicious",
+			func(c *Config) { c.TaggingDates = all.TaggingDates }},
+		{"DomainRRHistory", "DNS record history and features",
+			func(c *Config) { c.DomainRRHistory = all.DomainRRHistory }},
+		{"Whois", "registrant, registrar and registration dates",
+			func(c *Config) { c.Whois = all.Whois }},
+	}
+}
+
+// Returns a config with just the fields of the given built-in preset set.
+func PresetConfig(name string) (*Config, error) {
+	c := new(Config)
+	if err := c.applyPreset(name); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Generates a default config and writes it to DefaultConfigPath, with the
+// fields set in the given config, or those of the triage preset if it is
+// nil. Only the fields which are set are written.
+//
+// The API key is not written to the config itself, but to a file next to
+// it, which only the current user can read. A different key already in the
+// file is kept as a .bak file.
+//
+// If the default config already exists, ErrConfigExists is returned unless
+// force is set, in which case the old config is kept as a .bak file.
+func GenerateDefaultConfig(apiKey string, fields *Config, force bool) error {
+	if _, err := os.Stat(DefaultConfigPath); err == nil && !force {
+		return ErrConfigExists
+	}
+
+	err := os.MkdirAll(ConfigDir, 0700)
+
+	if err != nil {
+		return err
+	}
+
+	keyPath := filepath.Join(ConfigDir, defaultAPIKeyFile)
+	if old, err := ioutil.ReadFile(keyPath); err == nil && firstLine(old) != apiKey {
+		if err := os.Rename(keyPath, keyPath+".bak"); err != nil {
+			return err
+		}
+	}
+	err = writeAPIKeyFile(keyPath, apiKey)
+	if err != nil {
+		return err
+	}
+
+	if fields == nil {
+		if fields, err = PresetConfig("triage"); err != nil {
+			return err
+		}
+	}
+	config := setBoolFields(reflect.ValueOf(fields.fields()))
+	config["APIKeyFile"] = defaultAPIKeyFile
+
+	// write to a temporary file first, so that a failure part-way through
+	// can't leave a truncated config behind
+	tmpFile, err := ioutil.TempFile(ConfigDir, "default.toml.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	tomlEncoder := toml.NewEncoder(tmpFile)
+	err = tomlEncoder.Encode(config)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(DefaultConfigPath); err == nil {
+		if err := os.Rename(DefaultConfigPath, DefaultConfigPath+".bak"); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmpFile.Name(), DefaultConfigPath)
+}
+
+// Makes a single query with the given API key to check that it works.
+func CheckAPIKey(apiKey string) error {
+	inv := goinvestigate.New(apiKey)
+	inv.SetRetryPolicy(goinvestigate.RetryPolicy{MaxAttempts: 1})
+
+	_, err := inv.Categorization("www.opendns.com", false)
+	if statusErr, ok := err.(*goinvestigate.StatusError); ok &&
+		(statusErr.StatusCode == 401 || statusErr.StatusCode == 403) {
+		return fmt.Errorf("the API key was rejected (%s)", statusErr.Status)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package domainstats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestConfigDir(t *testing.T) {
+	t.Parallel()
+	home, err := ioutil.TempDir("", "domainstats-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	verify := func(xdgConfigHome, ref string) {
+		if test := configDir(home, xdgConfigHome); test != ref {
+			t.Fatalf("configDir(%q) = %q, but should = %q", xdgConfigHome, test, ref)
+		}
+	}
+
+	verify("", filepath.Join(home, ".config/domainstats"))
+	verify("/xdg", "/xdg/domainstats")
+	verify("relative/xdg", filepath.Join(home, ".config/domainstats"))
+
+	// configs set up by older versions should keep working
+	os.Mkdir(filepath.Join(home, ".domainstats"), 0700)
+	verify("/xdg", filepath.Join(home, ".domainstats"))
+}
+
+func TestGenerateDefaultConfig(t *testing.T) {
+	t.Setenv(APIKeyEnv, "")
+	dir, err := ioutil.TempDir("", "domainstats-setup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldConfigDir, oldDefaultConfigPath := ConfigDir, DefaultConfigPath
+	ConfigDir = filepath.Join(dir, "domainstats")
+	DefaultConfigPath = filepath.Join(ConfigDir, "default.toml")
+	defer func() {
+		ConfigDir, DefaultConfigPath = oldConfigDir, oldDefaultConfigPath
+	}()
+
+	triage, err := PresetConfig("triage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateDefaultConfig("first-key", triage, false); err != nil {
+		t.Fatal(err)
+	}
+
+	testConfig, warnings, err := LoadConfig(DefaultConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("generated config should not have warnings: %v", warnings)
+	}
+	if testConfig.APIKey != "first-key" {
+		t.Fatalf("APIKey = %q, but should = %q", testConfig.APIKey, "first-key")
+	}
+	refHeader := triage.DeriveHeader()
+	if testHeader := testConfig.DeriveHeader(); !strSliceEq(refHeader, testHeader) {
+		t.Fatalf("testHeader = %v, but should = %v", testHeader, refHeader)
+	}
+
+	// the key should not be in the config itself, and neither should
+	// anything else which wasn't chosen
+	contents, _ := ioutil.ReadFile(DefaultConfigPath)
+	var raw map[string]interface{}
+	if _, err := toml.Decode(string(contents), &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"APIKey", "APIKeyCommand", "Include", "Related"} {
+		if _, ok := raw[key]; ok {
+			t.Fatalf("%s should not be written to the config:\n%s", key, contents)
+		}
+	}
+
+	// an existing config should not be clobbered
+	if err := GenerateDefaultConfig("second-key", nil, false); err != ErrConfigExists {
+		t.Fatalf("err = %v, but should = %v", err, ErrConfigExists)
+	}
+	if after, _ := ioutil.ReadFile(DefaultConfigPath); string(after) != string(contents) {
+		t.Fatal("existing config was modified")
+	}
+
+	// unless forced, in which case it and the old key are backed up
+	if err := GenerateDefaultConfig("second-key", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if backup, _ := ioutil.ReadFile(DefaultConfigPath + ".bak"); string(backup) != string(contents) {
+		t.Fatal("old config should be kept as a .bak file")
+	}
+	keyPath := filepath.Join(ConfigDir, defaultAPIKeyFile)
+	if backup, _ := ioutil.ReadFile(keyPath + ".bak"); firstLine(backup) != "first-key" {
+		t.Fatal("old API key should be kept as a .bak file")
+	}
+	testConfig, _, err = LoadConfig(DefaultConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without fields, the triage preset is used
+	if testConfig.APIKey != "second-key" {
+		t.Fatalf("APIKey = %q, but should = %q", testConfig.APIKey, "second-key")
+	}
+	if testHeader := testConfig.DeriveHeader(); !strSliceEq(refHeader, testHeader) {
+		t.Fatalf("testHeader = %v, but should = %v", testHeader, refHeader)
+	}
+}
@@ -0,0 +1,131 @@
+package domainstats
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Settings for skipping domains which aren't worth querying, like popular
+// sites and internal domains. Skipped domains are still written, without
+// any Investigate data; the Skipped column says why they were skipped.
+type SkipConfig struct {
+	// A CSV file ranking popular domains, like the Tranco or Alexa top
+	// lists, with a rank and a domain on each line ("1,google.com"). A
+	// domain is skipped if it, or its registered domain, is ranked MaxRank
+	// or better. If MaxRank isn't set, every domain in the file is skipped.
+	RankingFile string
+	MaxRank     int
+
+	// Domains which are one of these, or under one of them, are skipped,
+	// e.g. "corp.example.com".
+	Suffixes []string
+
+	// Domains which match any of these regular expressions are skipped.
+	Patterns []string
+}
+
+func (sc *SkipConfig) validate() error {
+	if sc.MaxRank < 0 {
+		return fmt.Errorf("Skip.MaxRank should not be negative")
+	}
+	for _, p := range sc.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("Skip.Patterns: %v", err)
+		}
+	}
+	return nil
+}
+
+// Decides which domains to skip, from the Skip table of a config.
+type SkipList struct {
+	ranks map[string]int
+	domainMatcher
+}
+
+// Returns the skip list for the config, after reading its ranking file. It
+// returns nil if there's nothing to skip.
+func (c *Config) NewSkipList() (*SkipList, error) {
+	if c.Skip == nil {
+		return nil, nil
+	}
+	var patterns []*regexp.Regexp
+	for _, p := range c.Skip.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Skip.Patterns: %v", err)
+		}
+		patterns = append(patterns, re)
+	}
+	sl := &SkipList{domainMatcher: newDomainMatcher(c.Skip.Suffixes, patterns)}
+	if c.Skip.RankingFile != "" {
+		ranks, err := readRanking(expandPath(c.Skip.RankingFile, c.skipDir), c.Skip.MaxRank)
+		if err != nil {
+			return nil, err
+		}
+		sl.ranks = ranks
+	}
+
+	if len(sl.ranks) == 0 && sl.empty() {
+		return nil, nil
+	}
+	return sl, nil
+}
+
+// Reads the domains ranked maxRank or better from a ranking CSV. Lines
+// without a rank, like a header, are ignored; lines with only a domain are
+// ranked by their position.
+func readRanking(path string, maxRank int) (map[string]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Skip.RankingFile: %v", err)
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ",")
+		rank, domain := n, fields[0]
+		if len(fields) > 1 {
+			r, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+			if err != nil {
+				continue
+			}
+			rank, domain = r, fields[1]
+		}
+		if maxRank > 0 && rank > maxRank {
+			continue
+		}
+		domain = normalizeDomain(domain)
+		if domain == "" {
+			continue
+		}
+		if r, ok := ranks[domain]; !ok || rank < r {
+			ranks[domain] = rank
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Skip.RankingFile: %v", err)
+	}
+	return ranks, nil
+}
+
+// Returns why the domain should be skipped, or "" if it shouldn't be: e.g.
+// "rank 1", "suffix corp.example.com" or "pattern ^ip-".
+func (sl *SkipList) Reason(domain string) string {
+	if sl == nil {
+		return ""
+	}
+	domain = normalizeDomain(domain)
+
+	for _, d := range []string{domain, RegisteredDomain(domain)} {
+		if rank, ok := sl.ranks[d]; ok {
+			return "rank " + strconv.Itoa(rank)
+		}
+	}
+	return sl.match(domain)
+}
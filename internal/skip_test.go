@@ -0,0 +1,85 @@
+package domainstats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSkipList(t *testing.T) {
+	t.Setenv(APIKeyEnv, "test-key")
+	dir := t.TempDir()
+	ranking := "rank,domain\n1,google.com\n2,github.io\n3,example.org\n"
+	if err := os.WriteFile(filepath.Join(dir, "top.csv"), []byte(ranking), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(configPath, []byte(`
+Skipped = true
+
+[Skip]
+  RankingFile = "top.csv"
+  MaxRank = 2
+  Suffixes = ["Corp.Example.com."]
+  Patterns = ["^ip-[0-9-]+\\."]
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testConfig, _, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skips, err := testConfig.NewSkipList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, ref := range map[string]string{
+		"google.com":             "rank 1",
+		"mail.google.com":        "rank 1",
+		"github.io":              "rank 2",
+		"evil.github.io":         "",
+		"example.org":            "",
+		"corp.example.com":       "suffix corp.example.com",
+		"WWW.CORP.example.com":   "suffix corp.example.com",
+		"notcorp.example.com":    "",
+		"ip-10-0-0-1.compute.io": `pattern ^ip-[0-9-]+\.`,
+		"evil.com":               "",
+	} {
+		if test := skips.Reason(domain); test != ref {
+			t.Fatalf("%s: %v != %v", domain, ref, test)
+		}
+	}
+
+	// a skipped domain's row is padded out to the enabled columns
+	ref := len(testConfig.columns())
+	if test := len(testConfig.PadRow([]string{"google.com", "rank 1"})); test != ref {
+		t.Fatalf("%v != %v", ref, test)
+	}
+}
+
+func TestSkipListEmpty(t *testing.T) {
+	t.Parallel()
+	skips, err := (&Config{Skip: &SkipConfig{}}).NewSkipList()
+	if err != nil || skips != nil {
+		t.Fatalf("unexpected skip list %v, %v", skips, err)
+	}
+	// a nil skip list skips nothing
+	if test := skips.Reason("google.com"); test != "" {
+		t.Fatalf("%q != %q", "", test)
+	}
+
+	if _, err := (&Config{Skip: &SkipConfig{RankingFile: "nope.csv"}}).NewSkipList(); err == nil {
+		t.Fatal("a missing ranking file should be an error")
+	}
+}
+
+func TestValidateSkip(t *testing.T) {
+	t.Parallel()
+	for _, sc := range []SkipConfig{{MaxRank: -1}, {Patterns: []string{"("}}} {
+		if err := sc.validate(); err == nil {
+			t.Fatalf("%+v should not be valid", sc)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package domainstats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// The environment variable which, if set, overrides Splunk.Token
+const SplunkTokenEnv = "DOMAINSTATS_SPLUNK_TOKEN"
+
+const (
+	defaultSplunkSourcetype = "domainstats"
+	defaultSplunkBatchSize  = 100
+	defaultSplunkMaxRetries = 3
+	defaultSplunkAckTimeout = "2m"
+
+	splunkEventPath = "/services/collector/event"
+	splunkAckPath   = "/services/collector/ack"
+)
+
+// Settings for the "splunk" output format, which sends results to a Splunk
+// HTTP Event Collector (HEC).
+type SplunkConfig struct {
+	// The HEC's base URL, e.g. "https://splunk.example.com:8088"
+	URL string
+
+	// The HEC token. Rather than putting it in the config, it can be set
+	// with the DOMAINSTATS_SPLUNK_TOKEN environment variable, which is used
+	// instead if it is set.
+	Token string
+
+	// The index, sourcetype and source of the events. If Index isn't set,
+	// the token's default index is used. Sourcetype defaults to
+	// "domainstats".
+	Index      string
+	Sourcetype string
+	Source     string
+
+	// The number of events sent in each request; defaults to 100.
+	BatchSize int
+
+	// "gzip" (the default) or "none"
+	Compression string
+
+	// Whether to wait for Splunk to acknowledge that the events were
+	// indexed, which must be turned on for the token. AckTimeout is how
+	// long to wait for, e.g. "30s" or "5m"; it defaults to 2 minutes.
+	Acknowledge bool
+	AckTimeout  string
+
+	// How many times a request which failed because Splunk was busy or
+	// unreachable is sent again; defaults to 3.
+	MaxRetries int
+}
+
+func (sc *SplunkConfig) validate() error {
+	if sc.URL != "" {
+		u, err := url.Parse(sc.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Splunk.URL is %q, but should be an http or https URL", sc.URL)
+		}
+	}
+	switch sc.Compression {
+	case "", "gzip", "none":
+	default:
+		return fmt.Errorf("Splunk.Compression is %q, but should be \"gzip\" or \"none\"",
+			sc.Compression)
+	}
+	if sc.AckTimeout != "" {
+		if _, err := time.ParseDuration(sc.AckTimeout); err != nil {
+			return fmt.Errorf("Splunk.AckTimeout is %q, but should be a duration like \"30s\"",
+				sc.AckTimeout)
+		}
+	}
+	if sc.BatchSize < 0 {
+		return fmt.Errorf("Splunk.BatchSize should not be negative")
+	}
+	if sc.MaxRetries < 0 {
+		return fmt.Errorf("Splunk.MaxRetries should not be negative")
+	}
+	return nil
+}
+
+// Returns the Splunk settings, with defaults filled in.
+func (c *Config) splunk() SplunkConfig {
+	sc := SplunkConfig{}
+	if c.Splunk != nil {
+		sc = *c.Splunk
+	}
+	if sc.Sourcetype == "" {
+		sc.Sourcetype = defaultSplunkSourcetype
+	}
+	if sc.BatchSize == 0 {
+		sc.BatchSize = defaultSplunkBatchSize
+	}
+	if sc.Compression == "" {
+		sc.Compression = "gzip"
+	}
+	if sc.AckTimeout == "" {
+		sc.AckTimeout = defaultSplunkAckTimeout
+	}
+	if sc.MaxRetries == 0 {
+		sc.MaxRetries = defaultSplunkMaxRetries
+	}
+	if token := os.Getenv(SplunkTokenEnv); token != "" {
+		sc.Token = token
+	}
+	return sc
+}
+
+// A HEC event, as sent to the event endpoint
+type splunkEvent struct {
+	Time       float64                `json:"time"`
+	Index      string                 `json:"index,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	Sourcetype string                 `json:"sourcetype,omitempty"`
+	Event      map[string]interface{} `json:"event"`
+}
+
+// The HEC's response to events and acknowledgement requests
+type splunkResponse struct {
+	Text  string
+	Code  int
+	AckID *int64 `json:"ackId"`
+	Acks  map[string]bool
+}
+
+// Sends an event per domain to a Splunk HTTP Event Collector, in batches.
+type splunkSink struct {
+	conf       SplunkConfig
+	docs       *documentBuilder
+	client     *http.Client
+	ackTimeout time.Duration
+
+	// identifies this run to the HEC, for acknowledgements
+	channel string
+
+	// the encoded events which haven't been sent yet. Events which
+	// couldn't be sent are kept, up to twice the batch size, and sent again
+	// once retryAt has passed; dropped counts the ones given up on.
+	events  [][]byte
+	dropped int
+	retryAt time.Time
+
+	// the IDs of the batches which haven't been acknowledged yet
+	pendingAcks map[int64]bool
+
+	retryDelay  time.Duration
+	retryAfter  time.Duration
+	ackInterval time.Duration
+}
+
+// Returns a sink which sends rows to the Splunk HEC set up in the config.
+func NewSplunkSink(c *Config) (Sink, error) {
+	conf := c.splunk()
+	if conf.URL == "" {
+		return nil, errors.New("Splunk.URL must be set in the config to use the splunk format")
+	}
+	if conf.Token == "" {
+		return nil, fmt.Errorf("a Splunk HEC token must be set with %s or Splunk.Token",
+			SplunkTokenEnv)
+	}
+	ackTimeout, err := time.ParseDuration(conf.AckTimeout)
+	if err != nil {
+		return nil, err
+	}
+	channel, err := newChannelID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &splunkSink{
+		conf:        conf,
+		docs:        newDocumentBuilder(c),
+		client:      &http.Client{Timeout: 60 * time.Second},
+		ackTimeout:  ackTimeout,
+		channel:     channel,
+		pendingAcks: make(map[int64]bool),
+		retryDelay:  500 * time.Millisecond,
+		retryAfter:  10 * time.Second,
+		ackInterval: time.Second,
+	}, nil
+}
+
+// Returns a random UUID, as HEC channels are identified by one.
+func newChannelID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0F | 0x40
+	b[8] = b[8]&0x3F | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func (s *splunkSink) WriteRow(row Row) error {
+	now := time.Now()
+	event := splunkEvent{
+		Time:       float64(now.UnixNano()/int64(time.Millisecond)) / 1000,
+		Index:      s.conf.Index,
+		Source:     s.conf.Source,
+		Sourcetype: s.conf.Sourcetype,
+		Event:      s.docs.document(row),
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(event); err != nil {
+		return err
+	}
+
+	s.events = append(s.events, buf.Bytes())
+	if max := 2 * s.conf.BatchSize; len(s.events) > max {
+		s.dropped += len(s.events) - max
+		s.events = s.events[len(s.events)-max:]
+	}
+	if len(s.events) >= s.conf.BatchSize {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *splunkSink) Close() error {
+	var err error
+	if len(s.events) != 0 {
+		err = s.send()
+	}
+
+	// nothing is sent after this, so events which are still kept are lost
+	if dropped := s.dropped + len(s.events); dropped != 0 {
+		if err != nil {
+			return fmt.Errorf("%d event(s) couldn't be sent to Splunk, and were dropped: %v",
+				dropped, err)
+		}
+		return fmt.Errorf("%d event(s) couldn't be sent to Splunk, and were dropped", dropped)
+	}
+	if err != nil {
+		return err
+	}
+	if s.conf.Acknowledge {
+		return s.waitForAcks()
+	}
+	return nil
+}
+
+// Makes a request to the HEC. Batches of events are compressed, if the
+// config says so.
+func (s *splunkSink) newRequest(path string, body []byte) (*http.Request, error) {
+	compress := s.conf.Compression == "gzip" && path == splunkEventPath
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(s.conf.URL, "/")+path,
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Splunk "+s.conf.Token)
+	req.Header.Set("X-Splunk-Request-Channel", s.channel)
+	req.Header.Set("Content-Type", "application/json")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return req, nil
+}
+
+// Sends a request to the HEC, retrying if it is busy or can't be reached.
+func (s *splunkSink) post(path string, body []byte) (*splunkResponse, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := s.newRequest(path, body)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.client.Do(req)
+		var hecResp splunkResponse
+		if err == nil {
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			json.Unmarshal(respBody, &hecResp)
+
+			switch {
+			case resp.StatusCode == http.StatusOK:
+				return &hecResp, nil
+			case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+				err = fmt.Errorf("%s: %s", resp.Status, hecResp.Text)
+			default:
+				return nil, fmt.Errorf("Splunk HEC: %s: %s (code %d)",
+					resp.Status, hecResp.Text, hecResp.Code)
+			}
+		}
+
+		if attempt >= s.conf.MaxRetries {
+			return nil, &goinvestigate.RetryError{Attempts: attempt + 1,
+				Err: fmt.Errorf("Splunk HEC: %v", err)}
+		}
+		time.Sleep(s.retryDelay << uint(attempt))
+	}
+}
+
+// Sends the events written so far. If Splunk is busy or can't be reached,
+// they're kept, and sent along with a later batch once retryAfter has
+// passed; events Splunk rejects are dropped.
+func (s *splunkSink) Flush() error {
+	if len(s.events) == 0 || time.Now().Before(s.retryAt) {
+		return nil
+	}
+	return s.send()
+}
+
+func (s *splunkSink) send() error {
+	hecResp, err := s.post(splunkEventPath, bytes.Join(s.events, nil))
+	if err != nil {
+		if retryableSendError(err) {
+			s.retryAt = time.Now().Add(s.retryAfter)
+			return fmt.Errorf("%v; %d event(s) are kept to send again", err, len(s.events))
+		}
+		n := len(s.events)
+		s.dropped += n
+		s.events = nil
+		return fmt.Errorf("%v; %d event(s) were dropped", err, n)
+	}
+	s.events = nil
+	s.retryAt = time.Time{}
+	if s.conf.Acknowledge {
+		if hecResp.AckID == nil {
+			return errors.New("Splunk HEC didn't return an ackId; " +
+				"is indexer acknowledgement turned on for the token?")
+		}
+		s.pendingAcks[*hecResp.AckID] = true
+	}
+	return nil
+}
+
+// Polls the HEC until every batch has been acknowledged, or AckTimeout has
+// passed.
+func (s *splunkSink) waitForAcks() error {
+	deadline := time.Now().Add(s.ackTimeout)
+	for len(s.pendingAcks) != 0 {
+		ids := []int64{}
+		for id := range s.pendingAcks {
+			ids = append(ids, id)
+		}
+		body, err := json.Marshal(map[string][]int64{"acks": ids})
+		if err != nil {
+			return err
+		}
+
+		hecResp, err := s.post(splunkAckPath, body)
+		if err != nil {
+			return err
+		}
+		for id, acked := range hecResp.Acks {
+			if n, err := strconv.ParseInt(id, 10, 64); err == nil && acked {
+				delete(s.pendingAcks, n)
+			}
+		}
+
+		if len(s.pendingAcks) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d batch(es) of events were not acknowledged by Splunk within %s",
+				len(s.pendingAcks), s.ackTimeout)
+		}
+		time.Sleep(s.ackInterval)
+	}
+	return nil
+}
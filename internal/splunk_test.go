@@ -0,0 +1,245 @@
+package domainstats
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A stand-in for a HEC. The first request for events gets a 503, like a
+// busy Splunk, and each batch is only acknowledged on the second poll.
+type hecServer struct {
+	sync.Mutex
+	t        *testing.T
+	requests int
+	events   []splunkEvent
+	polls    map[int64]int
+}
+
+func (s *hecServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if r.Header.Get("Authorization") != "Splunk test-token" || r.Header.Get("X-Splunk-Request-Channel") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"text": "Invalid authorization", "code": 3}`))
+		return
+	}
+
+	switch r.URL.Path {
+	case splunkEventPath:
+		s.requests++
+		if s.requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"text": "Server is busy", "code": 9}`))
+			return
+		}
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				s.t.Error(err)
+				return
+			}
+			body = zr
+		}
+		dec := json.NewDecoder(bufio.NewReader(body))
+		for dec.More() {
+			var e splunkEvent
+			if err := dec.Decode(&e); err != nil {
+				s.t.Error(err)
+				return
+			}
+			s.events = append(s.events, e)
+		}
+		ackID := len(s.polls)
+		s.polls[int64(ackID)] = 0
+		w.Write([]byte(`{"text": "Success", "code": 0, "ackId": ` + strconv.Itoa(ackID) + `}`))
+
+	case splunkAckPath:
+		var req struct{ Acks []int64 }
+		json.NewDecoder(r.Body).Decode(&req)
+		acks := make(map[string]bool)
+		for _, id := range req.Acks {
+			s.polls[id]++
+			acks[strconv.FormatInt(id, 10)] = s.polls[id] > 1
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"acks": acks})
+	}
+}
+
+func TestSplunkSink(t *testing.T) {
+	server := &hecServer{t: t, polls: make(map[int64]int)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	t.Setenv(SplunkTokenEnv, "test-token")
+	varConfig := Config{Status: true, Splunk: &SplunkConfig{
+		URL:         ts.URL,
+		Index:       "dns",
+		BatchSize:   2,
+		Acknowledge: true,
+	}}
+	sink, err := NewSplunkSink(&varConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.(*splunkSink).retryDelay = 0
+	sink.(*splunkSink).ackInterval = 0
+
+	for _, domain := range []string{"a.com", "b.com", "c.com"} {
+		if err := sink.WriteRow(Row{Domain: domain}); err != nil {
+			t.Fatal(err)
+		}
+		// flushing sends a partial batch, as -follow does after each row
+		if domain == "a.com" {
+			if err := sink.(Flusher).Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if len(server.events) != 1 {
+				t.Fatalf("a.com should have been sent when flushed: %v", server.events)
+			}
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.events) != 3 {
+		t.Fatalf("there should be 3 events, but there are %d", len(server.events))
+	}
+	e := server.events[2]
+	if e.Event["Domain"] != "c.com" || e.Index != "dns" || e.Sourcetype != defaultSplunkSourcetype ||
+		e.Time == 0 {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	for id, polls := range server.polls {
+		if polls != 2 {
+			t.Fatalf("batch %d was polled for %d times, but should be 2", id, polls)
+		}
+	}
+}
+
+func TestSplunkSinkErrors(t *testing.T) {
+	t.Setenv(SplunkTokenEnv, "")
+	if _, err := NewSplunkSink(&Config{Splunk: &SplunkConfig{URL: "http://localhost:8088"}}); err == nil {
+		t.Fatal("a missing token should return an error")
+	}
+	if _, err := NewSplunkSink(&Config{Splunk: &SplunkConfig{Token: "x"}}); err == nil {
+		t.Fatal("a missing URL should return an error")
+	}
+
+	server := &hecServer{t: t, polls: make(map[int64]int)}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	sink, err := NewSplunkSink(&Config{Splunk: &SplunkConfig{URL: ts.URL, Token: "wrong"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.WriteRow(Row{Domain: "a.com"})
+	if err := sink.Close(); err == nil {
+		t.Fatal("a rejected token should return an error")
+	}
+	if server.requests != 0 {
+		t.Fatal("a rejected token should not be retried")
+	}
+}
+
+func TestSplunkConfigValidate(t *testing.T) {
+	t.Parallel()
+	bad := []SplunkConfig{
+		{URL: "splunk:8088"},
+		{Compression: "zstd"},
+		{AckTimeout: "2 minutes"},
+		{BatchSize: -1},
+	}
+	for _, sc := range bad {
+		if err := sc.validate(); err == nil {
+			t.Fatalf("%+v should not be valid", sc)
+		}
+	}
+}
+
+func TestSplunkSinkFailedBatches(t *testing.T) {
+	t.Parallel()
+	var requests int32
+	events := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a rejected request, then a busy Splunk for every attempt of the next
+		switch n := atomic.AddInt32(&requests, 1); {
+		case n == 1:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"text": "Invalid data format", "code": 6}`))
+			return
+		case n <= 5:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"text": "Server is busy", "code": 9}`))
+			return
+		}
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			body, _ = gzip.NewReader(r.Body)
+		}
+		dec := json.NewDecoder(body)
+		for {
+			var e splunkEvent
+			if err := dec.Decode(&e); err != nil {
+				break
+			}
+			events <- e.Event["Domain"].(string)
+		}
+		w.Write([]byte(`{"text": "Success", "code": 0}`))
+	}))
+	defer ts.Close()
+
+	sink, err := NewSplunkSink(&Config{Splunk: &SplunkConfig{URL: ts.URL, Token: "test-token", BatchSize: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.(*splunkSink).retryDelay = 0
+	sink.(*splunkSink).retryAfter = time.Hour
+
+	// a 400 drops the batch
+	sink.WriteRow(Row{Domain: "a.com"})
+	if err := sink.(Flusher).Flush(); err == nil {
+		t.Fatal("a rejected batch should return an error")
+	}
+	// a 503 keeps it, and it isn't sent again until retryAfter has passed
+	sink.WriteRow(Row{Domain: "b.com"})
+	if err := sink.(Flusher).Flush(); err == nil {
+		t.Fatal("a batch which couldn't be sent should return an error")
+	}
+	for _, domain := range []string{"c.com", "d.com", "e.com", "f.com"} {
+		if err := sink.WriteRow(Row{Domain: domain}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 5 {
+		t.Fatalf("%d requests were made, but there should have been 5", n)
+	}
+
+	// only twice the batch size is kept, and the oldest events are dropped
+	err = sink.Close()
+	if err == nil || !strings.Contains(err.Error(), "2 event(s)") {
+		t.Fatalf("the dropped events should be reported: %v", err)
+	}
+	close(events)
+	sent := []string{}
+	for domain := range events {
+		sent = append(sent, domain)
+	}
+	if ref := []string{"c.com", "d.com", "e.com", "f.com"}; !strSliceEq(ref, sent) {
+		t.Fatalf("%v != %v", ref, sent)
+	}
+}
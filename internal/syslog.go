@@ -0,0 +1,278 @@
+package domainstats
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// Settings for the "syslog" output format, which sends a message per
+// domain to a syslog server or SIEM.
+type SyslogConfig struct {
+	// The server's address, e.g. "siem.example.com:514"
+	Address string
+
+	// "udp" (the default), "tcp" or "tls". Messages sent over TCP and TLS
+	// are framed with octet counting (RFC 6587 and RFC 5425).
+	Network string
+
+	// "rfc5424" (the default), for an RFC 5424 message with the results as
+	// JSON, or "cef", for an RFC 5424 message with the results in the
+	// ArcSight Common Event Format.
+	Format string
+
+	// The syslog facility, e.g. "local0"; defaults to "user".
+	Facility string
+
+	// The APP-NAME of the messages; defaults to "domainstats".
+	AppName string
+
+	// A file with the PEM-encoded CA certificates to verify the server's
+	// certificate with, for "tls". By default, the system's are used.
+	CAFile string
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslog severities
+const (
+	syslogWarning = 4
+	syslogInfo    = 6
+)
+
+func (sc *SyslogConfig) validate() error {
+	switch sc.Network {
+	case "", "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("Syslog.Network is %q, but should be \"udp\", \"tcp\" or \"tls\"",
+			sc.Network)
+	}
+	switch sc.Format {
+	case "", "rfc5424", "cef":
+	default:
+		return fmt.Errorf("Syslog.Format is %q, but should be \"rfc5424\" or \"cef\"", sc.Format)
+	}
+	if _, ok := syslogFacilities[sc.Facility]; sc.Facility != "" && !ok {
+		return fmt.Errorf("Syslog.Facility %q is not a syslog facility", sc.Facility)
+	}
+	return nil
+}
+
+// Returns the syslog settings, with defaults filled in.
+func (c *Config) syslog() SyslogConfig {
+	sc := SyslogConfig{}
+	if c.Syslog != nil {
+		sc = *c.Syslog
+	}
+	if sc.Network == "" {
+		sc.Network = "udp"
+	}
+	if sc.Format == "" {
+		sc.Format = "rfc5424"
+	}
+	if sc.Facility == "" {
+		sc.Facility = "user"
+	}
+	if sc.AppName == "" {
+		sc.AppName = "domainstats"
+	}
+	return sc
+}
+
+// Sends a syslog message for each domain.
+type syslogSink struct {
+	conf     SyslogConfig
+	config   *Config
+	docs     *documentBuilder
+	conn     net.Conn
+	hostname string
+	procID   string
+	facility int
+}
+
+// Returns a sink which sends rows to the syslog server in the config.
+func NewSyslogSink(c *Config) (Sink, error) {
+	conf := c.syslog()
+	if conf.Address == "" {
+		return nil, errors.New("Syslog.Address must be set in the config to use the syslog format")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s := &syslogSink{
+		conf:     conf,
+		config:   c,
+		docs:     newDocumentBuilder(c),
+		hostname: hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+		facility: syslogFacilities[conf.Facility],
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *syslogSink) connect() error {
+	var err error
+	switch s.conf.Network {
+	case "tls":
+		tlsConf := &tls.Config{}
+		if s.conf.CAFile != "" {
+			pem, err := ioutil.ReadFile(expandPath(s.conf.CAFile, ""))
+			if err != nil {
+				return err
+			}
+			tlsConf.RootCAs = x509.NewCertPool()
+			if !tlsConf.RootCAs.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates found in %s", s.conf.CAFile)
+			}
+		}
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		s.conn, err = tls.DialWithDialer(dialer, "tcp", s.conf.Address, tlsConf)
+	default:
+		s.conn, err = net.DialTimeout(s.conf.Network, s.conf.Address, 30*time.Second)
+	}
+	return err
+}
+
+func (s *syslogSink) WriteRow(row Row) error {
+	msg, err := s.message(row, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// octet counting, for stream transports
+	if s.conf.Network != "udp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	if _, err := s.conn.Write(msg); err != nil {
+		if s.conf.Network == "udp" {
+			return err
+		}
+
+		// the server may have dropped the connection; try once more
+		s.conn.Close()
+		if err := s.connect(); err != nil {
+			return err
+		}
+		_, err = s.conn.Write(msg)
+		return err
+	}
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	return s.conn.Close()
+}
+
+// Formats the RFC 5424 message for the row.
+func (s *syslogSink) message(row Row, t time.Time) ([]byte, error) {
+	severity := syslogInfo
+	if status, ok := rowStatus(row); ok && status == -1 {
+		severity = syslogWarning
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %s %s - ",
+		s.facility*8+severity, t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname, s.conf.AppName, s.procID, "enrichment")
+
+	if s.conf.Format == "cef" {
+		buf.WriteString(s.config.cefMessage(row, t))
+		return buf.Bytes(), nil
+	}
+
+	doc, err := json.Marshal(s.docs.document(row))
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(doc)
+	return buf.Bytes(), nil
+}
+
+// Returns the row's categorization status, if it was queried.
+func rowStatus(row Row) (int, bool) {
+	resp, ok := findResponse(row, (*goinvestigate.DomainCategorization)(nil)).(*goinvestigate.DomainCategorization)
+	if !ok || resp == nil {
+		return 0, false
+	}
+	return resp.Status, true
+}
+
+// Formats the row as a CEF event. The signature ID and name depend on the
+// domain's status, and the extension has the domain, status, SecureRank2,
+// threat type and categories, for whichever of these are enabled.
+func (c *Config) cefMessage(row Row, t time.Time) string {
+	sigID, name, severity := "status:unknown", "Domain enrichment", 3
+	ext := []string{
+		"rt=" + strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10),
+		"dhost=" + cefEscapeExtension(row.Domain),
+	}
+
+	if status, ok := rowStatus(row); ok {
+		sigID = "status:" + strconv.Itoa(status)
+		switch status {
+		case -1:
+			name, severity = "Malicious domain", 8
+		case 1:
+			name, severity = "Benign domain", 1
+		default:
+			name, severity = "Uncategorized domain", 3
+		}
+		if c.Status {
+			ext = append(ext, "cn1Label=Status", "cn1="+strconv.Itoa(status))
+		}
+
+		cat := findResponse(row, (*goinvestigate.DomainCategorization)(nil)).(*goinvestigate.DomainCategorization)
+		if c.Categories.SecurityCategories && len(cat.SecurityCategories) != 0 {
+			ext = append(ext, "cs2Label=SecurityCategories",
+				"cs2="+cefEscapeExtension(strings.Join(cat.SecurityCategories, ",")))
+		}
+		if c.Categories.ContentCategories && len(cat.ContentCategories) != 0 {
+			ext = append(ext, "cs3Label=ContentCategories",
+				"cs3="+cefEscapeExtension(strings.Join(cat.ContentCategories, ",")))
+		}
+	}
+
+	sec, ok := findResponse(row, (*goinvestigate.SecurityFeatures)(nil)).(*goinvestigate.SecurityFeatures)
+	if ok && sec != nil {
+		if c.Security.SecureRank2 {
+			ext = append(ext, "cfp1Label=SecureRank2", "cfp1="+convertFloatToStr(sec.SecureRank2))
+		}
+		if c.Security.ThreatType && sec.ThreatType != "" {
+			ext = append(ext, "cs1Label=ThreatType", "cs1="+cefEscapeExtension(sec.ThreatType))
+		}
+	}
+
+	return fmt.Sprintf("CEF:0|domainstats|domainstats|1.0|%s|%s|%d|%s",
+		cefEscapeHeader(sigID), cefEscapeHeader(name), severity, strings.Join(ext, " "))
+}
+
+func cefEscapeHeader(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ").Replace(s)
+}
+
+func cefEscapeExtension(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
@@ -0,0 +1,164 @@
+package domainstats
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+func TestCEFMessage(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{Status: true}
+	varConfig.Categories.SecurityCategories = true
+	varConfig.Security.SecureRank2 = true
+	varConfig.Security.ThreatType = true
+
+	row := Row{Domain: "bad.com", Responses: []interface{}{
+		&goinvestigate.DomainCategorization{Status: -1, SecurityCategories: []string{"Malware", "Botnet"}},
+		&goinvestigate.SecurityFeatures{SecureRank2: -12.5, ThreatType: "a|b=c"},
+	}}
+	date := time.Date(2015, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	ref := `CEF:0|domainstats|domainstats|1.0|status:-1|Malicious domain|8|` +
+		`rt=1435665600000 dhost=bad.com cn1Label=Status cn1=-1 ` +
+		`cs2Label=SecurityCategories cs2=Malware,Botnet ` +
+		`cfp1Label=SecureRank2 cfp1=-12.5 cs1Label=ThreatType cs1=a|b\=c`
+	test := varConfig.cefMessage(row, date)
+	if ref != test {
+		t.Fatalf("%v != %v", ref, test)
+	}
+
+	// without a categorization, the status is unknown
+	ref = `CEF:0|domainstats|domainstats|1.0|status:unknown|Domain enrichment|3|` +
+		`rt=1435665600000 dhost=a.com`
+	test = (&Config{}).cefMessage(Row{Domain: "a.com"}, date)
+	if ref != test {
+		t.Fatalf("%v != %v", ref, test)
+	}
+}
+
+func TestCEFEscape(t *testing.T) {
+	t.Parallel()
+	if ref, test := `a\|b\\c`, cefEscapeHeader(`a|b\c`); ref != test {
+		t.Fatalf("%v != %v", ref, test)
+	}
+	if ref, test := `a|b\=c\\d\ne`, cefEscapeExtension("a|b=c\\d\ne"); ref != test {
+		t.Fatalf("%v != %v", ref, test)
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	t.Parallel()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	varConfig := Config{Status: true, Syslog: &SyslogConfig{Address: conn.LocalAddr().String()}}
+	sink, err := NewSyslogSink(&varConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.WriteRow(Row{Domain: "bad.com", Responses: []interface{}{
+		&goinvestigate.DomainCategorization{Status: -1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// user.warning, since the domain is malicious
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<12>1 ") || !strings.Contains(msg, " domainstats ") ||
+		!strings.HasSuffix(msg, ` - {"Domain":"bad.com","Status":-1}`) {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan []string)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+
+		// read octet-counted frames until the connection is closed
+		msgs := []string{}
+		r := bufio.NewReader(conn)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		received <- msgs
+	}()
+
+	varConfig := Config{Syslog: &SyslogConfig{
+		Address: ln.Addr().String(), Network: "tcp", Format: "cef", Facility: "local0",
+	}}
+	sink, err := NewSyslogSink(&varConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range []string{"a.com", "b.com"} {
+		if err := sink.WriteRow(Row{Domain: domain}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	msgs := <-received
+	if len(msgs) != 2 {
+		t.Fatalf("there should be 2 messages: %q", msgs)
+	}
+	// local0.info
+	if !strings.HasPrefix(msgs[1], "<134>1 ") || !strings.Contains(msgs[1], "CEF:0|") ||
+		!strings.Contains(msgs[1], "dhost=b.com") {
+		t.Fatalf("unexpected message %q", msgs[1])
+	}
+}
+
+func TestSyslogConfigValidate(t *testing.T) {
+	t.Parallel()
+	bad := []SyslogConfig{
+		{Network: "http"},
+		{Format: "leef"},
+		{Facility: "local8"},
+	}
+	for _, sc := range bad {
+		if err := sc.validate(); err == nil {
+			t.Fatalf("%+v should not be valid", sc)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package domainstats
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// Writes rows as Tab-Separated Values, starting with a header row
+type tsvSink struct {
+	config      *Config
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+// Returns a sink which writes rows as Tab-Separated Values, with the
+// columns given by the config.
+func NewTSVSink(w io.Writer, c *Config) Sink {
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Comma = rune('\t')
+	return &tsvSink{config: c, w: csvWriter}
+}
+
+func (s *tsvSink) writeHeader() error {
+	if s.wroteHeader {
+		return nil
+	}
+	s.wroteHeader = true
+	return s.w.Write(s.config.DeriveHeader())
+}
+
+func (s *tsvSink) WriteRow(row Row) error {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	return s.w.Write(s.config.ProjectRow(row.Fields))
+}
+
+func (s *tsvSink) Flush() error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *tsvSink) Close() error {
+	// the header should be written even if there were no rows
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
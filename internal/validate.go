@@ -0,0 +1,215 @@
+package domainstats
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// An UnknownKeyError is reported for each key in a config file which does
+// not correspond to any config option; e.g., because of a typo.
+type UnknownKeyError struct {
+	Key        string
+	Suggestion string
+}
+
+func (e *UnknownKeyError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("unknown config key %q (did you mean %q?)", e.Key, e.Suggestion)
+	}
+	return fmt.Sprintf("unknown config key %q", e.Key)
+}
+
+// Returns the dotted names of every key which can appear in a config file,
+// including the names of tables.
+func knownKeys() []string {
+	keys := []string{}
+	var walk func(prefix string, t reflect.Type)
+	walk = func(prefix string, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			key := prefix + f.Name
+			keys = append(keys, key)
+			t := f.Type
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if t.Kind() == reflect.Struct {
+				walk(key+".", t)
+			}
+		}
+	}
+	walk("", reflect.TypeOf(Config{}))
+	return keys
+}
+
+// Checks the keys in the decoded TOML data which didn't correspond to any
+// config option, and returns an error for each one.
+func unknownKeys(md toml.MetaData) (errs []error) {
+	known := knownKeys()
+	undecoded := make(map[string]bool)
+	for _, key := range md.Undecoded() {
+		undecoded[key.String()] = true
+
+		// providers' tables are checked when they're decoded
+		if _, ok := lookupProvider(key[0]); ok {
+			continue
+		}
+
+		// an unknown table is enough; don't also report every key in it
+		if len(key) > 1 && undecoded[key[:len(key)-1].String()] {
+			continue
+		}
+		errs = append(errs, &UnknownKeyError{key.String(), suggestKey(key.String(), known)})
+	}
+	return errs
+}
+
+// Returns the known key closest to the given unknown key, or "" if none
+// is close enough to be a likely typo. The key is also compared against
+// the last part of each known key, in case it's in the wrong table.
+func suggestKey(key string, known []string) string {
+	best := ""
+	bestDist := -1
+	lower := strings.ToLower(key)
+	for _, k := range known {
+		d := editDistance(lower, strings.ToLower(k))
+		if i := strings.LastIndex(k, "."); i != -1 {
+			d = minInt(d, editDistance(lower, strings.ToLower(k[i+1:])))
+		}
+		if bestDist == -1 || d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+
+	// only allow about one typo for every 4 characters
+	if bestDist == -1 || bestDist > len(key)/4+1 {
+		return ""
+	}
+	return best
+}
+
+// Returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Checks the values of options which aren't just true or false.
+func (c *Config) validateOptions() error {
+	tables := []struct {
+		name string
+		dsc  DomainScoreConfig
+	}{
+		{"Cooccurrences", c.Cooccurrences},
+		{"Related", c.Related},
+	}
+	for _, t := range tables {
+		switch t.dsc.Sort {
+		case "", "score", "domain":
+		default:
+			return fmt.Errorf("%s.Sort is %q, but should be \"score\" or \"domain\"",
+				t.name, t.dsc.Sort)
+		}
+		if t.dsc.MaxResults < 0 {
+			return fmt.Errorf("%s.MaxResults should not be negative", t.name)
+		}
+	}
+	if c.Whois.MaxEmailDomains < 0 {
+		return fmt.Errorf("Whois.MaxEmailDomains should not be negative")
+	}
+
+	if c.OpenSearch != nil {
+		if err := c.OpenSearch.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Splunk != nil {
+		if err := c.Splunk.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Syslog != nil {
+		if err := c.Syslog.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Skip != nil {
+		if err := c.Skip.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Egress != nil {
+		if err := c.Egress.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a human-readable description of the queries made for each
+// domain, in the order they are made.
+func (c *Config) QueryPlan() (plan []string) {
+	for _, msg := range c.DeriveMessages(nil, "") {
+		plan = append(plan, describeQuery(msg.Q))
+	}
+	return plan
+}
+
+func describeQuery(q DomainQueryType) string {
+	switch q := q.(type) {
+	case *CategorizationQuery:
+		if q.Labels {
+			return "Categorization (with labels)"
+		}
+		return "Categorization"
+	case *CooccurrencesQuery:
+		return "Cooccurrences"
+	case *RelatedQuery:
+		return "RelatedDomains"
+	case *SecurityQuery:
+		return "Security"
+	case *DomainTagsQuery:
+		return "DomainTags"
+	case *DomainRRHistoryQuery:
+		return fmt.Sprintf("DomainRRHistory (%s records)", q.QueryType)
+	case *WhoisQuery:
+		if q.EmailDomains {
+			return "Whois (and the registrant email's domains)"
+		}
+		return "Whois"
+	case *ProviderQuery:
+		return q.Provider
+	default:
+		return fmt.Sprintf("%T", q)
+	}
+}
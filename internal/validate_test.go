@@ -0,0 +1,164 @@
+package domainstats
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// writes the given TOML to a temporary file and returns its path
+func writeTempConfig(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "domainstats-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestEditDistance(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		a, b string
+		dist int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"DGAScore", "DGAScore", 0},
+		{"DGAScroe", "DGAScore", 2},
+		{"Securty", "Security", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, c := range cases {
+		if d := editDistance(c.a, c.b); d != c.dist {
+			t.Fatalf("editDistance(%q, %q) = %d, but should = %d", c.a, c.b, d, c.dist)
+		}
+	}
+}
+
+func TestSuggestKey(t *testing.T) {
+	t.Parallel()
+	known := knownKeys()
+	cases := map[string]string{
+		"Security.DGAScroe":        "Security.DGAScore",
+		"Securty":                  "Security",
+		"Cooccurences":             "Cooccurrences",
+		"DomainRRHistory.Feature":  "DomainRRHistory.Features",
+		"OpenSearch.Indx":          "OpenSearch.Index",
+		"DGAscore":                 "Security.DGAScore",
+		"SomethingCompletelyWrong": "",
+	}
+	for key, ref := range cases {
+		if test := suggestKey(key, known); test != ref {
+			t.Fatalf("suggestKey(%q) = %q, but should = %q", key, test, ref)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+	path := writeTempConfig(t, `
+APIKey = "test"
+Status = true
+Colums = ["Domain"]
+
+[Securty]
+  DGAScore = true
+  Entropy = true
+
+[Security]
+  DGAScroe = true
+  ThreatType = true
+`)
+	defer os.Remove(path)
+
+	testConfig, warnings, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refWarnings := []string{
+		`unknown config key "Colums" (did you mean "Columns"?)`,
+		`unknown config key "Securty" (did you mean "Security"?)`,
+		`unknown config key "Security.DGAScroe" (did you mean "Security.DGAScore"?)`,
+	}
+	if len(warnings) != len(refWarnings) {
+		t.Fatalf("warnings = %v, but should = %v", warnings, refWarnings)
+	}
+	for i := range warnings {
+		if warnings[i].Error() != refWarnings[i] {
+			t.Fatalf("warnings = %v, but should = %v", warnings, refWarnings)
+		}
+	}
+
+	refHeader := []string{"Domain", "Status", "ThreatType"}
+	if testHeader := testConfig.DeriveHeader(); !strSliceEq(refHeader, testHeader) {
+		t.Fatalf("testHeader = %v, but should = %v", testHeader, refHeader)
+	}
+
+	refPlan := []string{"Categorization", "Security"}
+	if testPlan := testConfig.QueryPlan(); !strSliceEq(refPlan, testPlan) {
+		t.Fatalf("testPlan = %v, but should = %v", testPlan, refPlan)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Setenv(APIKeyEnv, "")
+	for _, contents := range []string{
+		"Status = true\n",
+		"APIKey = \"test\"\nStatus = \"yes\"\n",
+		"APIKey = \"test\"\nColumns = [\"Domain\", \"Nope\"]\n",
+		"APIKey = \"test\"\n[Related]\n  Domain = true\n  Sort = \"bogus\"\n",
+		"APIKey = \"test\"\n[Cooccurrences]\n  Domain = true\n  MaxResults = -1\n",
+		"APIKey = \"test\n",
+	} {
+		path := writeTempConfig(t, contents)
+		defer os.Remove(path)
+		if _, _, err := LoadConfig(path); err == nil {
+			t.Fatalf("LoadConfig should return an error for config:\n%s", contents)
+		}
+	}
+
+	if _, _, err := LoadConfig("/nonexistent/domainstats.toml"); err == nil {
+		t.Fatal("LoadConfig should return an error for a missing file")
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	t.Setenv(APIKeyEnv, "")
+	path := writeTempConfig(t, "Status = true\n")
+	defer os.Remove(path)
+
+	// a config without a key can be checked, but not used
+	_, warnings, err := CheckConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("a config without a key should give a warning: %v", warnings)
+	}
+	if _, _, err := LoadConfig(path); err == nil {
+		t.Fatal("LoadConfig should return an error for a config without a key")
+	}
+
+	// the key command isn't run
+	path = writeTempConfig(t, "APIKeyCommand = \"exit 1\"\nStatus = true\n")
+	defer os.Remove(path)
+	testConfig, warnings, err := CheckConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 || testConfig.APIKey != "" {
+		t.Fatalf("the API key shouldn't have been resolved: %v, %q", warnings, testConfig.APIKey)
+	}
+
+	// other problems are still errors
+	path = writeTempConfig(t, "Status = \"yes\"\n")
+	defer os.Remove(path)
+	if _, _, err := CheckConfig(path); err == nil {
+		t.Fatal("CheckConfig should return an error for an invalid config")
+	}
+}
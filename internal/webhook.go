@@ -0,0 +1,410 @@
+package domainstats
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// The environment variable which, if set, overrides Webhook.Secret
+const WebhookSecretEnv = "DOMAINSTATS_WEBHOOK_SECRET"
+
+const (
+	defaultWebhookBody       = `{"count": {{.Count}}, "domains": {{json .Domains}}}`
+	defaultWebhookBatchSize  = 20
+	defaultWebhookMaxWait    = "1m"
+	defaultWebhookMaxRetries = 3
+)
+
+// The header the HMAC-SHA256 signature of the body is sent in, as
+// "sha256=<hex digest>"
+const WebhookSignatureHeader = "X-Domainstats-Signature"
+
+// Settings for notifying a webhook about flagged domains. This is done in
+// addition to the usual output.
+type WebhookConfig struct {
+	// The URL the notifications are POSTed to
+	URL string
+
+	// A domain is flagged if it matches any of these conditions, e.g.
+	// "Status == -1" or `SecurityCategories != ""`. The left-hand side is a
+	// column, named as in Columns, which must be enabled; the operator is
+	// one of ==, !=, <, <=, > and >=. Defaults to ["Status == -1"].
+	Match []string
+
+	// A Go text/template for the body of each notification. It is given
+	// .Count, the number of domains, and .Domains, their documents, with
+	// the same fields as the OpenSearch output. The json function encodes
+	// a value as JSON.
+	Body string
+
+	// The key the body is signed with. If the DOMAINSTATS_WEBHOOK_SECRET
+	// environment variable is set, it is used instead. If neither is set,
+	// notifications are not signed.
+	Secret string
+
+	// Flagged domains are sent in batches of up to BatchSize (20 by
+	// default). A batch is sent early if its first domain has been waiting
+	// for MaxWait, e.g. "30s" (1 minute by default), and at the end of the
+	// run.
+	BatchSize int
+	MaxWait   string
+
+	// How many times a notification which failed because the server was
+	// busy or unreachable is sent again; defaults to 3.
+	MaxRetries int
+}
+
+// Returns the webhook settings, with defaults filled in.
+func (c *Config) webhook() WebhookConfig {
+	wc := WebhookConfig{}
+	if c.Webhook != nil {
+		wc = *c.Webhook
+	}
+	if len(wc.Match) == 0 {
+		wc.Match = []string{"Status == -1"}
+	}
+	if wc.Body == "" {
+		wc.Body = defaultWebhookBody
+	}
+	if wc.BatchSize == 0 {
+		wc.BatchSize = defaultWebhookBatchSize
+	}
+	if wc.MaxWait == "" {
+		wc.MaxWait = defaultWebhookMaxWait
+	}
+	if wc.MaxRetries == 0 {
+		wc.MaxRetries = defaultWebhookMaxRetries
+	}
+	if secret := os.Getenv(WebhookSecretEnv); secret != "" {
+		wc.Secret = secret
+	}
+	return wc
+}
+
+// Reports whether the config has a webhook to notify.
+func (c *Config) HasWebhook() bool {
+	return c.Webhook != nil && c.Webhook.URL != ""
+}
+
+// Checks the webhook settings. Since conditions refer to columns, this has
+// to be done once the columns are known.
+func (c *Config) validateWebhook() error {
+	if c.Webhook == nil {
+		return nil
+	}
+	wc := c.webhook()
+	if wc.URL != "" {
+		u, err := url.Parse(wc.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Webhook.URL is %q, but should be an http or https URL", wc.URL)
+		}
+	}
+	if _, err := c.webhookConditions(wc.Match); err != nil {
+		return err
+	}
+	if _, err := webhookTemplate(wc.Body); err != nil {
+		return fmt.Errorf("Webhook.Body: %v", err)
+	}
+	if _, err := time.ParseDuration(wc.MaxWait); err != nil {
+		return fmt.Errorf("Webhook.MaxWait is %q, but should be a duration like \"30s\"", wc.MaxWait)
+	}
+	if wc.BatchSize < 0 {
+		return fmt.Errorf("Webhook.BatchSize should not be negative")
+	}
+	if wc.MaxRetries < 0 {
+		return fmt.Errorf("Webhook.MaxRetries should not be negative")
+	}
+	return nil
+}
+
+// A condition a flagged domain matches, e.g. "Status == -1"
+type webhookCondition struct {
+	// the index of the column in a row's fields
+	index int
+	op    string
+	value string
+}
+
+// the operators of conditions; two-character ones come first, so that
+// e.g. "<=" isn't taken for "<"
+var conditionOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// Parses the conditions, which must refer to enabled columns.
+func (c *Config) webhookConditions(match []string) ([]webhookCondition, error) {
+	cols := c.columns()
+	conds := []webhookCondition{}
+	for _, m := range match {
+		opIndex, op := -1, ""
+		for i := 0; i < len(m) && opIndex == -1; i++ {
+			for _, o := range conditionOps {
+				if strings.HasPrefix(m[i:], o) {
+					opIndex, op = i, o
+					break
+				}
+			}
+		}
+		if opIndex == -1 {
+			return nil, fmt.Errorf("Webhook.Match: %q has no operator (%s)",
+				m, strings.Join(conditionOps, ", "))
+		}
+
+		name := strings.TrimSpace(m[:opIndex])
+		value := strings.TrimSpace(m[opIndex+len(op):])
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+
+		col, ok := lookupColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("Webhook.Match: unknown column %q", name)
+		}
+		index := -1
+		for i, enabled := range cols {
+			if enabled.Path == col.Path {
+				index = i
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("Webhook.Match: column %q is not enabled in the config", name)
+		}
+
+		conds = append(conds, webhookCondition{index, op, value})
+	}
+	return conds, nil
+}
+
+// Reports whether the row's fields match the condition. Values are
+// compared as numbers if both are numbers, and as strings otherwise.
+func (cond webhookCondition) matches(fields []string) bool {
+	if cond.index >= len(fields) {
+		return false
+	}
+	field := fields[cond.index]
+
+	cmp := strings.Compare(field, cond.value)
+	a, errA := strconv.ParseFloat(field, 64)
+	b, errB := strconv.ParseFloat(cond.value, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+
+	switch cond.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func webhookTemplate(body string) (*template.Template, error) {
+	return template.New("body").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(body)
+}
+
+// Notifies a webhook of flagged domains, in batches. Rows which don't
+// match any of the conditions are ignored.
+type webhookSink struct {
+	conf       WebhookConfig
+	conditions []webhookCondition
+	docs       *documentBuilder
+	body       *template.Template
+	client     *http.Client
+	maxWait    time.Duration
+	retryDelay time.Duration
+
+	// guards the batch, which may be sent from a timer
+	mu    sync.Mutex
+	batch []map[string]interface{}
+	timer *time.Timer
+
+	// counts the timers which haven't finished sending their batch
+	sending sync.WaitGroup
+
+	// the first error from sending a batch in the background
+	err error
+}
+
+// Returns a sink which notifies the webhook in the config of the domains
+// which match its conditions.
+func NewWebhookSink(c *Config) (Sink, error) {
+	if err := c.validateWebhook(); err != nil {
+		return nil, err
+	}
+	conf := c.webhook()
+	conditions, _ := c.webhookConditions(conf.Match)
+	body, _ := webhookTemplate(conf.Body)
+	maxWait, _ := time.ParseDuration(conf.MaxWait)
+
+	return &webhookSink{
+		conf:       conf,
+		conditions: conditions,
+		docs:       newDocumentBuilder(c),
+		body:       body,
+		client:     &http.Client{Timeout: 60 * time.Second},
+		maxWait:    maxWait,
+		retryDelay: 500 * time.Millisecond,
+	}, nil
+}
+
+func (s *webhookSink) flagged(row Row) bool {
+	for _, cond := range s.conditions {
+		if cond.matches(row.Fields) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *webhookSink) WriteRow(row Row) error {
+	if !s.flagged(row) {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.batch = append(s.batch, s.docs.document(row))
+	if len(s.batch) >= s.conf.BatchSize {
+		batch := s.takeBatch()
+		s.mu.Unlock()
+		return s.send(batch)
+	}
+	if len(s.batch) == 1 && s.maxWait > 0 {
+		s.sending.Add(1)
+		s.timer = time.AfterFunc(s.maxWait, func() {
+			defer s.sending.Done()
+			s.mu.Lock()
+			batch := s.takeBatch()
+			s.mu.Unlock()
+			if err := s.send(batch); err != nil {
+				s.setErr(err)
+			}
+		})
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Returns the first error from sending a batch, including ones sent in the
+// background because they had waited for MaxWait.
+func (s *webhookSink) Close() error {
+	s.mu.Lock()
+	batch := s.takeBatch()
+	s.mu.Unlock()
+	if err := s.send(batch); err != nil {
+		s.setErr(err)
+	}
+
+	// batches already being sent in the background
+	s.sending.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *webhookSink) setErr(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+}
+
+// Takes the batch to be sent, and stops its timer. s.mu must be held.
+func (s *webhookSink) takeBatch() []map[string]interface{} {
+	if s.timer != nil {
+		// if the timer already fired, its function sends whatever is in
+		// the batch then, and counts itself as done
+		if s.timer.Stop() {
+			s.sending.Done()
+		}
+		s.timer = nil
+	}
+	batch := s.batch
+	s.batch = nil
+	return batch
+}
+
+// Sends a batch, retrying if the webhook is busy or can't be reached. s.mu
+// must not be held, so that rows can still be written meanwhile.
+func (s *webhookSink) send(batch []map[string]interface{}) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	err := s.body.Execute(&body, struct {
+		Count   int
+		Domains []map[string]interface{}
+	}{len(batch), batch})
+	if err != nil {
+		return fmt.Errorf("webhook body: %v", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("POST", s.conf.URL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if s.conf.Secret != "" {
+			req.Header.Set(WebhookSignatureHeader, signWebhookBody(s.conf.Secret, body.Bytes()))
+		}
+
+		resp, err := s.client.Do(req)
+		if err == nil {
+			respBody, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			switch {
+			case resp.StatusCode < 300:
+				return nil
+			case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+				err = fmt.Errorf("%s", resp.Status)
+			default:
+				return fmt.Errorf("webhook: %s: %s", resp.Status, respBody)
+			}
+		}
+
+		if attempt >= s.conf.MaxRetries {
+			return fmt.Errorf("webhook: %v (after %d retries)", err, attempt)
+		}
+		time.Sleep(s.retryDelay << uint(attempt))
+	}
+}
+
+// Returns the value of the signature header for the body.
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,216 @@
+package domainstats
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// A stand-in for a webhook receiver, which checks signatures. The first
+// notification gets a 503.
+type webhookServer struct {
+	sync.Mutex
+	t             *testing.T
+	secret        string
+	requests      int
+	notifications []map[string]interface{}
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	if ref, test := signWebhookBody(s.secret, body), r.Header.Get(WebhookSignatureHeader); ref != test {
+		s.t.Errorf("%v != %v", ref, test)
+	}
+
+	s.requests++
+	if s.requests == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var n map[string]interface{}
+	if err := json.Unmarshal(body, &n); err != nil {
+		s.t.Error(err)
+	}
+	s.notifications = append(s.notifications, n)
+}
+
+func categorized(domain string, status int, security ...string) Row {
+	return Row{
+		Domain: domain,
+		Fields: []string{domain, strconv.Itoa(status)},
+		Responses: []interface{}{&goinvestigate.DomainCategorization{
+			Status: status, SecurityCategories: security,
+		}},
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	server := &webhookServer{t: t, secret: "s3cret"}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	t.Setenv(WebhookSecretEnv, "s3cret")
+	varConfig := Config{Status: true, Webhook: &WebhookConfig{URL: ts.URL, BatchSize: 2}}
+	sink, err := NewWebhookSink(&varConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.(*webhookSink).retryDelay = 0
+
+	for _, row := range []Row{
+		categorized("a.com", -1), categorized("b.com", 1),
+		categorized("c.com", -1), categorized("d.com", -1),
+	} {
+		if err := sink.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a.com and c.com, after a retry, then d.com when closed
+	if server.requests != 3 || len(server.notifications) != 2 {
+		t.Fatalf("unexpected notifications %v", server.notifications)
+	}
+	n := server.notifications[0]
+	domains := n["domains"].([]interface{})
+	if n["count"] != 2.0 || len(domains) != 2 ||
+		domains[1].(map[string]interface{})["Domain"] != "c.com" {
+		t.Fatalf("unexpected notification %v", n)
+	}
+}
+
+func TestWebhookSinkMaxWait(t *testing.T) {
+	received := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(WebhookSignatureHeader) != "" {
+			t.Error("an unsigned notification should not have a signature")
+		}
+		received <- string(body)
+	}))
+	defer ts.Close()
+
+	t.Setenv(WebhookSecretEnv, "")
+	varConfig := Config{Status: true, Webhook: &WebhookConfig{
+		URL:     ts.URL,
+		Match:   []string{`SecurityCategories != ""`},
+		Body:    `{{range .Domains}}{{.Domain}} {{end}}`,
+		MaxWait: "10ms",
+	}}
+	varConfig.Categories.SecurityCategories = true
+	sink, err := NewWebhookSink(&varConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	row := categorized("a.com", -1, "Malware")
+	row.Fields = []string{"a.com", "-1", "Malware"}
+	if err := sink.WriteRow(row); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case body := <-received:
+		if ref := "a.com "; ref != body {
+			t.Fatalf("%v != %v", ref, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the batch should have been sent after MaxWait")
+	}
+}
+
+func TestWebhookSinkSlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	received := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, string(body))
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	t.Setenv(WebhookSecretEnv, "")
+	varConfig := Config{Status: true, Webhook: &WebhookConfig{
+		URL:     ts.URL,
+		Body:    `{{range .Domains}}{{.Domain}} {{end}}`,
+		MaxWait: "1ms",
+	}}
+	sink, err := NewWebhookSink(&varConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.WriteRow(categorized("a.com", -1)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// a.com's batch is stuck being sent, which shouldn't hold up other rows
+	written := make(chan error)
+	go func() { written <- sink.WriteRow(categorized("b.com", -1)) }()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writing a row should not wait for a batch being sent")
+	}
+
+	close(release)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0] != "a.com " {
+		t.Fatalf("unexpected notifications %v", received)
+	}
+}
+
+func TestWebhookConditions(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{Status: true}
+	varConfig.Security.SecureRank2 = true
+
+	conds, err := varConfig.webhookConditions([]string{"Security.SecureRank2<=-10", `Domain == "a.com"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		fields []string
+		ref    []bool
+	}{
+		{[]string{"b.com", "1", "-12.5"}, []bool{true, false}},
+		{[]string{"a.com", "1", "9"}, []bool{false, true}},
+		{[]string{"b.com", "1", "-10"}, []bool{true, false}},
+	} {
+		for i, cond := range conds {
+			if test := cond.matches(c.fields); test != c.ref[i] {
+				t.Fatalf("condition %d on %v: %v != %v", i, c.fields, c.ref[i], test)
+			}
+		}
+	}
+
+	for _, bad := range []string{"Status", "Nope == 1", "SecurityCategories != \"\""} {
+		if _, err := varConfig.webhookConditions([]string{bad}); err == nil {
+			t.Fatalf("%q should not be valid", bad)
+		}
+	}
+}
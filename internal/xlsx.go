@@ -0,0 +1,357 @@
+package domainstats
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// The most characters Excel allows in a single cell. Longer values are cut
+// short; the detail sheets still have everything in them.
+const xlsxMaxCellLen = 32767
+
+// Excel's limit on the length of sheet names
+const xlsxMaxSheetNameLen = 31
+
+// A cell of an XLSX sheet. Type is "n" for numbers, "b" for booleans, or
+// "inlineStr" for strings; Value is the value as it is written in the XML.
+type xlsxCell struct {
+	Type  string
+	Value string
+}
+
+type xlsxSheet struct {
+	Name   string
+	Header []string
+	Rows   [][]xlsxCell
+
+	// if set, rows for which this formula is true are highlighted. The
+	// formula is written for the first data row, i.e. row 2.
+	Highlight string
+}
+
+func (s *xlsxSheet) addRow(cells ...xlsxCell) {
+	s.Rows = append(s.Rows, cells)
+}
+
+// Writes rows as an Excel workbook. The first sheet, "Summary", has one
+// row per domain with the configured columns. The other sheets have one
+// row per item in the list-valued fields, like cooccurrences and RR
+// periods, for each endpoint which is enabled.
+//
+// Since the workbook is a zip file, the whole workbook is kept in memory
+// and is only written when the sink is closed.
+type xlsxSink struct {
+	config  *Config
+	w       io.Writer
+	columns []OutputColumn
+
+	summary       *xlsxSheet
+	cooccurrences *xlsxSheet
+	related       *xlsxSheet
+	tags          *xlsxSheet
+	periods       *xlsxSheet
+}
+
+// Returns a sink which writes rows to an Excel (XLSX) workbook.
+func NewXLSXSink(w io.Writer, c *Config) Sink {
+	s := &xlsxSink{
+		config:  c,
+		w:       w,
+		columns: c.OutputColumns(),
+	}
+
+	s.summary = &xlsxSheet{Name: "Summary", Header: c.DeriveHeader()}
+	for i, col := range s.columns {
+		if col.Path == "Status" {
+			s.summary.Highlight = fmt.Sprintf("$%s2=-1", xlsxColumnName(i))
+		}
+	}
+
+	if any(c.Cooccurrences) {
+		s.cooccurrences = &xlsxSheet{Name: "Cooccurrences",
+			Header: []string{"Domain", "Cooccurring Domain", "Score"}}
+	}
+	if any(c.Related) {
+		s.related = &xlsxSheet{Name: "Related Domains",
+			Header: []string{"Domain", "Related Domain", "Score"}}
+	}
+	if any(c.TaggingDates) {
+		s.tags = &xlsxSheet{Name: "Tagging Dates",
+			Header: []string{"Domain", "Begin", "End", "Category", "Url"}}
+	}
+	if any(c.DomainRRHistory.Periods) {
+		s.periods = &xlsxSheet{Name: "RR Periods",
+			Header: []string{"Domain", "FirstSeen", "LastSeen", "Name", "TTL", "Class", "Type", "RR"}}
+	}
+
+	return s
+}
+
+func (s *xlsxSink) WriteRow(row Row) error {
+	values := s.config.ProjectRow(row.Fields)
+	cells := make([]xlsxCell, len(values))
+	for i, v := range values {
+		colType := StringColumn
+		if i < len(s.columns) {
+			colType = s.columns[i].Type
+		}
+		cells[i] = typedXLSXCell(colType, v)
+	}
+	s.summary.addRow(cells...)
+
+	domain := xlsxString(row.Domain)
+	for _, resp := range row.Responses {
+		switch resp := resp.(type) {
+		case []goinvestigate.Cooccurrence:
+			if s.cooccurrences == nil {
+				continue
+			}
+			for _, sd := range s.config.Cooccurrences.filter(cooccurrenceResults(resp)) {
+				s.cooccurrences.addRow(domain, xlsxString(sd.Domain), xlsxNumber(sd.ScoreStr))
+			}
+		case []goinvestigate.RelatedDomain:
+			if s.related == nil {
+				continue
+			}
+			for _, sd := range s.config.Related.filter(relatedDomainResults(resp)) {
+				s.related.addRow(domain, xlsxString(sd.Domain), xlsxNumber(sd.ScoreStr))
+			}
+		case []goinvestigate.DomainTag:
+			if s.tags == nil {
+				continue
+			}
+			for _, dt := range resp {
+				s.tags.addRow(domain, xlsxString(dt.Period.Begin), xlsxString(dt.Period.End),
+					xlsxString(dt.Category), xlsxString(dt.Url))
+			}
+		case *goinvestigate.DomainRRHistory:
+			if s.periods == nil {
+				continue
+			}
+			for _, p := range resp.RRPeriods {
+				for _, rr := range p.RRs {
+					s.periods.addRow(domain, xlsxString(p.FirstSeen), xlsxString(p.LastSeen),
+						xlsxString(rr.Name), xlsxNumber(strconv.Itoa(rr.TTL)),
+						xlsxString(rr.Class), xlsxString(rr.Type), xlsxString(rr.RR))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *xlsxSink) Close() error {
+	sheets := []*xlsxSheet{s.summary}
+	for _, sheet := range []*xlsxSheet{s.cooccurrences, s.related, s.tags, s.periods} {
+		if sheet != nil {
+			sheets = append(sheets, sheet)
+		}
+	}
+	return writeXLSX(s.w, sheets)
+}
+
+// Makes a cell of the given type. If the value can't be parsed as that
+// type, e.g. because it is blank, it is written as a string.
+func typedXLSXCell(colType ColumnType, value string) xlsxCell {
+	switch colType {
+	case IntColumn, FloatColumn:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return xlsxNumber(value)
+		}
+	case BoolColumn:
+		if b, err := strconv.ParseBool(value); err == nil {
+			if b {
+				return xlsxCell{"b", "1"}
+			}
+			return xlsxCell{"b", "0"}
+		}
+	}
+	return xlsxString(value)
+}
+
+func xlsxNumber(value string) xlsxCell {
+	return xlsxCell{"n", value}
+}
+
+func xlsxString(value string) xlsxCell {
+	return xlsxCell{"inlineStr", value}
+}
+
+// Returns the letters of the column with the given 0-based index; e.g.,
+// 0 is A, 25 is Z, and 26 is AA.
+func xlsxColumnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// Writes the escaped text to buf, leaving out characters which are not
+// allowed in XML at all, and cutting it short at Excel's cell limit.
+func writeXLSXText(buf *bytes.Buffer, s string) {
+	s = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r != 0xFFFE && r != 0xFFFF {
+			return r
+		}
+		return -1
+	}, s)
+	if utf8.RuneCountInString(s) > xlsxMaxCellLen {
+		s = string([]rune(s)[:xlsxMaxCellLen])
+	}
+	xml.EscapeText(buf, []byte(s))
+}
+
+func writeXLSXCell(buf *bytes.Buffer, ref string, cell xlsxCell, style int) {
+	fmt.Fprintf(buf, `<c r="%s"`, ref)
+	if style != 0 {
+		fmt.Fprintf(buf, ` s="%d"`, style)
+	}
+	switch cell.Type {
+	case "inlineStr":
+		buf.WriteString(` t="inlineStr"><is><t xml:space="preserve">`)
+		writeXLSXText(buf, cell.Value)
+		buf.WriteString(`</t></is></c>`)
+	default:
+		fmt.Fprintf(buf, ` t="%s"><v>%s</v></c>`, cell.Type, cell.Value)
+	}
+}
+
+func (sheet *xlsxSheet) xml(selected bool) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+
+	// freeze the header row
+	tabSelected := ""
+	if selected {
+		tabSelected = ` tabSelected="1"`
+	}
+	fmt.Fprintf(buf, `<sheetViews><sheetView workbookViewId="0"%s>`+
+		`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`+
+		`</sheetView></sheetViews>`, tabSelected)
+
+	buf.WriteString(`<sheetData><row r="1">`)
+	for i, h := range sheet.Header {
+		writeXLSXCell(buf, xlsxColumnName(i)+"1", xlsxString(h), 1)
+	}
+	buf.WriteString(`</row>`)
+	for r, row := range sheet.Rows {
+		fmt.Fprintf(buf, `<row r="%d">`, r+2)
+		for i, cell := range row {
+			writeXLSXCell(buf, xlsxColumnName(i)+strconv.Itoa(r+2), cell, 0)
+		}
+		buf.WriteString(`</row>`)
+	}
+	buf.WriteString(`</sheetData>`)
+
+	if sheet.Highlight != "" && len(sheet.Header) != 0 {
+		fmt.Fprintf(buf, `<conditionalFormatting sqref="A2:%s1048576">`+
+			`<cfRule type="expression" dxfId="0" priority="1"><formula>`,
+			xlsxColumnName(len(sheet.Header)-1))
+		xml.EscapeText(buf, []byte(sheet.Highlight))
+		buf.WriteString(`</formula></cfRule></conditionalFormatting>`)
+	}
+
+	buf.WriteString(`</worksheet>`)
+	return buf.Bytes()
+}
+
+const xlsxRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// Style 0 is the default, and style 1 is bold, for headers. The only
+// differential format, 0, is Excel's "light red fill with dark red text".
+const xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font>` +
+	`<font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill>` +
+	`<fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`<dxfs count="1"><dxf><font><color rgb="FF9C0006"/></font>` +
+	`<fill><patternFill><bgColor rgb="FFFFC7CE"/></patternFill></fill></dxf></dxfs>` +
+	`</styleSheet>`
+
+// Writes the sheets as an XLSX workbook to w.
+func writeXLSX(w io.Writer, sheets []*xlsxSheet) error {
+	contentTypes := new(bytes.Buffer)
+	contentTypes.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+
+	workbook := new(bytes.Buffer)
+	workbook.WriteString(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+
+	workbookRels := new(bytes.Buffer)
+	workbookRels.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	for i, sheet := range sheets {
+		n := i + 1
+		fmt.Fprintf(contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" `+
+			`ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+
+		name := sheet.Name
+		if len(name) > xlsxMaxSheetNameLen {
+			name = name[:xlsxMaxSheetNameLen]
+		}
+		workbook.WriteString(`<sheet name="`)
+		xml.EscapeText(workbook, []byte(name))
+		fmt.Fprintf(workbook, `" sheetId="%d" r:id="rId%d"/>`, n, n)
+
+		fmt.Fprintf(workbookRels, `<Relationship Id="rId%d" `+
+			`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" `+
+			`Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(workbookRels, `<Relationship Id="rId%d" `+
+		`Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" `+
+		`Target="styles.xml"/></Relationships>`, len(sheets)+1)
+
+	parts := []struct {
+		name     string
+		contents []byte
+	}{
+		{"[Content_Types].xml", contentTypes.Bytes()},
+		{"_rels/.rels", []byte(xlsxRels)},
+		{"xl/workbook.xml", workbook.Bytes()},
+		{"xl/_rels/workbook.xml.rels", workbookRels.Bytes()},
+		{"xl/styles.xml", []byte(xlsxStyles)},
+	}
+	for i, sheet := range sheets {
+		parts = append(parts, struct {
+			name     string
+			contents []byte
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), sheet.xml(i == 0)})
+	}
+
+	zw := zip.NewWriter(w)
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(part.contents); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
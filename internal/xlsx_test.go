@@ -0,0 +1,294 @@
+package domainstats
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/dead10ck/goinvestigate"
+)
+
+// Reads every part of the XLSX file, checking that each is well-formed XML
+func readXLSX(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dec := xml.NewDecoder(bytes.NewReader(contents))
+		for {
+			if _, err := dec.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not valid XML: %v", f.Name, err)
+			}
+		}
+		parts[f.Name] = string(contents)
+	}
+	return parts
+}
+
+func TestXLSXSink(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{
+		Status:        true,
+		Cooccurrences: DomainScoreConfig{Domain: true, Score: true, MaxResults: 1},
+	}
+	varConfig.Security.Fastflux = true
+
+	buf := new(bytes.Buffer)
+	sink := NewXLSXSink(buf, &varConfig)
+	err := sink.WriteRow(Row{
+		Line:   1,
+		Domain: "a.com",
+		Fields: []string{"a.com", "-1", "b.com:0.5", "true"},
+		Responses: []interface{}{
+			&goinvestigate.DomainCategorization{Status: -1},
+			[]goinvestigate.Cooccurrence{{Domain: "b.com", Score: 0.5}, {Domain: "c.com", Score: 0.25}},
+			&goinvestigate.SecurityFeatures{Fastflux: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := readXLSX(t, buf.Bytes())
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml",
+		"xl/_rels/workbook.xml.rels", "xl/styles.xml", "xl/worksheets/sheet1.xml",
+		"xl/worksheets/sheet2.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Fatalf("missing part %s", name)
+		}
+	}
+
+	// only enabled endpoints get a sheet of their own
+	if _, ok := parts["xl/worksheets/sheet3.xml"]; ok {
+		t.Fatal("there should only be a summary and a cooccurrences sheet")
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="Cooccurrences"`) {
+		t.Fatalf("workbook should have a Cooccurrences sheet: %s", parts["xl/workbook.xml"])
+	}
+
+	summary := parts["xl/worksheets/sheet1.xml"]
+	for _, ref := range []string{
+		`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`,
+		`<c r="B2" t="n"><v>-1</v></c>`,
+		`<c r="D2" t="b"><v>1</v></c>`,
+		`<formula>$B2=-1</formula>`,
+	} {
+		if !strings.Contains(summary, ref) {
+			t.Fatalf("summary sheet should contain %s: %s", ref, summary)
+		}
+	}
+
+	// the detail sheet has the same results as the summary cell
+	cooc := parts["xl/worksheets/sheet2.xml"]
+	if !strings.Contains(cooc, `<c r="C2" t="n"><v>0.5</v></c>`) || strings.Contains(cooc, "c.com") {
+		t.Fatalf("cooccurrences sheet should only have b.com: %s", cooc)
+	}
+}
+
+// The content type each kind of relationship should point at, from ECMA-376
+var xlsxRelContentTypes = map[string]string{
+	"http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml",
+	"http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet":      "application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml",
+	"http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles":         "application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml",
+}
+
+func TestXLSXPackage(t *testing.T) {
+	t.Parallel()
+	varConfig := Config{
+		Status:        true,
+		Cooccurrences: DomainScoreConfig{Domain: true, Score: true},
+	}
+	varConfig.DomainRRHistory.Periods.FirstSeen = true
+	varConfig.DomainRRHistory.Periods.TTL = true
+
+	buf := new(bytes.Buffer)
+	sink := NewXLSXSink(buf, &varConfig)
+	if err := sink.WriteRow(Row{Line: 1, Domain: "a.com", Fields: []string{"a.com", "1", "", ""}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	parts := readXLSX(t, buf.Bytes())
+
+	var types struct {
+		Defaults []struct {
+			Extension   string `xml:",attr"`
+			ContentType string `xml:",attr"`
+		} `xml:"Default"`
+		Overrides []struct {
+			PartName    string `xml:",attr"`
+			ContentType string `xml:",attr"`
+		} `xml:"Override"`
+	}
+	if err := xml.Unmarshal([]byte(parts["[Content_Types].xml"]), &types); err != nil {
+		t.Fatal(err)
+	}
+	contentType := func(name string) string {
+		for _, o := range types.Overrides {
+			if o.PartName == "/"+name {
+				return o.ContentType
+			}
+		}
+		for _, d := range types.Defaults {
+			if strings.EqualFold(d.Extension, strings.TrimPrefix(path.Ext(name), ".")) {
+				return d.ContentType
+			}
+		}
+		return ""
+	}
+	for name := range parts {
+		if name != "[Content_Types].xml" && contentType(name) == "" {
+			t.Fatalf("%s has no content type", name)
+		}
+	}
+	for _, o := range types.Overrides {
+		if _, ok := parts[strings.TrimPrefix(o.PartName, "/")]; !ok {
+			t.Fatalf("there's a content type for %s, but no such part", o.PartName)
+		}
+	}
+
+	// follow the relationships from the package down, checking that each
+	// points at a part of the right type, and that every part is reached
+	reached := map[string]bool{"[Content_Types].xml": true}
+	var follow func(source string)
+	follow = func(source string) {
+		relsName := path.Join(path.Dir(source), "_rels", path.Base(source)+".rels")
+		if source == "" {
+			relsName = "_rels/.rels"
+		}
+		rels, ok := parts[relsName]
+		if !ok {
+			return
+		}
+		reached[relsName] = true
+
+		var relationships struct {
+			Relationships []struct {
+				Id     string `xml:",attr"`
+				Type   string `xml:",attr"`
+				Target string `xml:",attr"`
+			} `xml:"Relationship"`
+		}
+		if err := xml.Unmarshal([]byte(rels), &relationships); err != nil {
+			t.Fatal(err)
+		}
+		for _, rel := range relationships.Relationships {
+			target := path.Join(path.Dir(source), rel.Target)
+			if _, ok := parts[target]; !ok {
+				t.Fatalf("%s: %s points at %s, which doesn't exist", relsName, rel.Id, target)
+			}
+			if ref, test := xlsxRelContentTypes[rel.Type], contentType(target); ref != test {
+				t.Fatalf("%s: %v != %v", target, ref, test)
+			}
+			if !reached[target] {
+				reached[target] = true
+				follow(target)
+			}
+		}
+	}
+	follow("")
+	for name := range parts {
+		if !reached[name] {
+			t.Fatalf("nothing points at %s", name)
+		}
+	}
+	if len(parts) != 8 {
+		t.Fatalf("there should be 3 sheets, and 5 other parts: %v", reached)
+	}
+}
+
+func TestTypedXLSXCell(t *testing.T) {
+	t.Parallel()
+	ref := []struct {
+		colType ColumnType
+		value   string
+		cell    xlsxCell
+	}{
+		{IntColumn, "3", xlsxCell{"n", "3"}},
+		{FloatColumn, "0.25", xlsxCell{"n", "0.25"}},
+		{FloatColumn, "", xlsxCell{"inlineStr", ""}},
+		{BoolColumn, "false", xlsxCell{"b", "0"}},
+		{StringColumn, "1", xlsxCell{"inlineStr", "1"}},
+	}
+	for _, r := range ref {
+		if test := typedXLSXCell(r.colType, r.value); test != r.cell {
+			t.Fatalf("typedXLSXCell(%v, %q) = %v, but should = %v", r.colType, r.value, test, r.cell)
+		}
+	}
+}
+
+func TestXLSXColumnName(t *testing.T) {
+	t.Parallel()
+	ref := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"}
+	for i, name := range ref {
+		if test := xlsxColumnName(i); test != name {
+			t.Fatalf("xlsxColumnName(%d) = %s, but should = %s", i, test, name)
+		}
+	}
+}
+
+func TestWriteXLSXText(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	writeXLSXText(buf, "a<b\x00"+strings.Repeat("x", xlsxMaxCellLen))
+	test := buf.String()
+	if !strings.HasPrefix(test, "a&lt;b") || strings.Contains(test, "\x00") {
+		t.Fatalf("text should be escaped without control characters: %.20q", test)
+	}
+	if n := len(test) - len("a&lt;b"); n != xlsxMaxCellLen-3 {
+		t.Fatalf("text should be cut to %d characters, but has %d", xlsxMaxCellLen, n+3)
+	}
+}
+
+func TestXLSXSinkCooccurrencesOptions(t *testing.T) {
+	t.Parallel()
+	varConfig := &Config{Cooccurrences: DomainScoreConfig{
+		Domain: true, Score: true, MinScore: 0.3, Sort: "score"}}
+
+	buf := new(bytes.Buffer)
+	sink := NewXLSXSink(buf, varConfig)
+	err := sink.WriteRow(Row{
+		Domain: "a.com",
+		Fields: []string{"a.com", "c.com:0.5, d.com:0.4"},
+		Responses: []interface{}{[]goinvestigate.Cooccurrence{
+			{Domain: "b.com", Score: 0.25}, {Domain: "d.com", Score: 0.4}, {Domain: "c.com", Score: 0.5}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the detail sheet is filtered and sorted like the summary cell
+	cooc := readXLSX(t, buf.Bytes())["xl/worksheets/sheet2.xml"]
+	c, d := strings.Index(cooc, "c.com"), strings.Index(cooc, "d.com")
+	if c < 0 || d < c || strings.Contains(cooc, "b.com") ||
+		!strings.Contains(cooc, `<c r="C2" t="n"><v>0.5</v></c>`) {
+		t.Fatalf("cooccurrences sheet should have c.com, then d.com: %s", cooc)
+	}
+}
@@ -12,32 +12,59 @@ package main
 
 import (
 	"bufio"
-	"encoding/csv"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/dead10ck/domainstats/enrich"
 	domainstats "github.com/dead10ck/domainstats/internal"
-	"github.com/dead10ck/goinvestigate"
 )
 
 type opt struct {
-	verbose    bool
-	setup      string
-	outFile    string
-	configPath string
+	verbose     bool
+	setup       string
+	outFile     string
+	configPath  string
+	ordered     bool
+	strict      bool
+	profile     string
+	force       bool
+	noVerify    bool
+	format      string
+	inputFormat string
+	follow      bool
+	dedupWindow time.Duration
+	reportFile  string
 }
 
 var (
-	opts       opt
-	numDomains int
+	opts     opt
+	progress = domainstats.NewProgress()
 )
 
 const (
 	DEFAULT_MAX_GOROUTINES = 5
+
+	// the maximum number of domains which can be read from the input but not
+	// yet written out. In ordered mode, this bounds the reorder buffer.
+	DEFAULT_MAX_IN_FLIGHT = 1000
+
+	// how often a followed file is checked for new lines
+	FOLLOW_POLL_INTERVAL = time.Second
+
+	// how often the progress line is redrawn on a terminal, and how often
+	// it is logged otherwise
+	PROGRESS_REDRAW_INTERVAL = 250 * time.Millisecond
+	PROGRESS_LOG_INTERVAL    = 10 * time.Second
 )
 
 func init() {
@@ -48,20 +75,42 @@ func main() {
 
 	flag.BoolVar(&opts.verbose, "v", false, "Print out verbose log messages.")
 	flag.StringVar(&opts.setup, "setup", "",
-		"Generate a default config file in ~/.domainstats/default.toml with"+
-			" the given API key.")
+		"Generate a default config file in "+domainstats.DefaultConfigPath+
+			" with the given API key.")
+	flag.BoolVar(&opts.force, "force", false,
+		"With -setup, replace an existing default config file.")
+	flag.BoolVar(&opts.noVerify, "no-verify", false,
+		"With -setup, don't check the API key with a test query.")
 	flag.StringVar(&opts.outFile, "out", "", "Output matching IPs to the given file")
+	flag.StringVar(&opts.format, "format", "tsv",
+		"The format of the output file ("+strings.Join(domainstats.OutputFormats, ", ")+").")
 	flag.StringVar(&opts.configPath, "c", domainstats.DefaultConfigPath, "The config file to use")
+	flag.StringVar(&opts.profile, "profile", "",
+		"Use <profile>.toml in "+domainstats.ConfigDir+", or if there is no such file, the"+
+			" config file with its fields replaced by the built-in preset of this"+
+			" name (presets: "+strings.Join(domainstats.PresetNames(), ", ")+").")
+	flag.BoolVar(&opts.ordered, "ordered", false,
+		"Write output rows in the same order as the input domains.")
+	flag.StringVar(&opts.inputFormat, "input-format", "list",
+		"The format of the domain list ("+strings.Join(domainstats.InputFormats, ", ")+
+			"). Resolver logs and packet captures are read for the names looked up.")
+	flag.BoolVar(&opts.follow, "follow", false,
+		"Keep reading the domain list as it grows, like tail -F, until interrupted.")
+	flag.DurationVar(&opts.dedupWindow, "dedup-window", 24*time.Hour,
+		"With -follow, skip domains which were already read within this long (0 to never skip).")
+	flag.StringVar(&opts.reportFile, "report", "",
+		"Also write the summary printed at the end of the run to the given file, as an HTML page.")
+	flag.BoolVar(&opts.strict, "strict", false,
+		"Treat config warnings, such as unknown keys, as errors.")
+	flag.Usage = usage
 	flag.Parse()
 
-	if opts.setup != "" {
-		err := domainstats.GenerateDefaultConfig(opts.setup)
-		if err != nil {
-			log.Fatalf("error creating default config file: %v", err)
-		}
+	if flag.Arg(0) == "config" {
+		os.Exit(configCommand(flag.Args()[1:]))
+	}
 
-		fmt.Printf(fmt.Sprintf("Config file generated in %s\n", domainstats.DefaultConfigPath))
-		os.Exit(0)
+	if opts.setup != "" {
+		os.Exit(setup(opts.setup))
 	}
 
 	// if the default config file does not exist and the user did not specify
@@ -72,160 +121,288 @@ func main() {
 			"config file.")
 	}
 
-	config, err := domainstats.NewConfig(opts.configPath)
+	config, err := loadConfig(opts.configPath, opts.profile, opts.strict)
 	if err != nil {
 		log.Fatal(err)
 	}
-	var outWriter *csv.Writer
-	inv := goinvestigate.New(config.APIKey)
+	var sink domainstats.Sink
 	domainListFileName := flag.Arg(flag.NArg() - 1)
 	if domainListFileName == "" {
 		fmt.Println("Need a file name")
 		os.Exit(-1)
 	}
-	inChan := readDomainsFrom(domainListFileName)
+	// each domain takes a slot when it is read and gives it back when its
+	// row is written, so the reader can't get too far ahead of the writer
+	inFlight := make(chan struct{}, DEFAULT_MAX_IN_FLIGHT)
+	parser, err := domainstats.NewQueryLogParser(opts.inputFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.inputFormat == "list" && config.QueryLog != (domainstats.QueryLogConfig{}) {
+		log.Printf("warning: the QueryLog columns are only filled in for resolver logs " +
+			"and packet captures; see -input-format")
+	}
 
-	if opts.verbose {
-		inv.SetVerbose(true)
+	var inChan <-chan enrich.Input
+	switch {
+	case opts.follow && opts.inputFormat == "pcap":
+		log.Fatal("-follow can't be used with -input-format pcap")
+	case opts.follow:
+		inChan = followDomainsFrom(domainListFileName, parser, inFlight)
+	case opts.inputFormat == "pcap":
+		inChan = readPcap(domainListFileName, inFlight)
+	case parser != nil:
+		inChan = readQueryLog(domainListFileName, parser, inFlight)
+	default:
+		inChan = readDomainsFrom(domainListFileName, inFlight)
 	}
 
-	if opts.outFile != "" {
+	if err := domainstats.CheckOutputFormat(opts.format); err != nil {
+		log.Fatal(err)
+	}
+	if opts.follow && (opts.format == "xlsx" || opts.format == "parquet") {
+		log.Printf("warning: -format %s can't be streamed; "+
+			"the output is only written once domainstats is interrupted", opts.format)
+	}
+	remote := config.RemoteFormat(opts.format)
+	if remote && opts.outFile != "" {
+		log.Printf("warning: -format %s sends results to the server in the config; "+
+			"-out is not used", opts.format)
+	}
+
+	if remote {
+		sink, err = domainstats.NewSink(opts.format, nil, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := sink.Close(); err != nil {
+				log.Printf("error sending results: %v", err)
+			}
+		}()
+	} else if opts.outFile != "" {
 		outFile, err := os.Create(opts.outFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		outWriter = csv.NewWriter(outFile)
-		outWriter.Comma = rune('\t')
-		outWriter.Write(config.DeriveHeader())
+		sink, err = domainstats.NewSink(opts.format, outFile, config)
+		if err != nil {
+			log.Fatal(err)
+		}
 		defer func() {
-			outWriter.Flush()
+			if err := sink.Close(); err != nil {
+				log.Printf("error writing %s: %v", opts.outFile, err)
+			}
 			outFile.Close()
 		}()
 	}
 
-	outChan := getInfo(config, inv, inChan)
+	var webhook domainstats.Sink
+	if config.HasWebhook() {
+		webhook, err = domainstats.NewWebhookSink(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := webhook.Close(); err != nil {
+				log.Printf("error notifying webhook: %v", err)
+			}
+		}()
+	}
+
+	egress := config.EgressPolicy()
+	if config.Egress != nil && config.Egress.AuditFile != "" {
+		auditFile, err := os.OpenFile(config.Egress.AuditFile,
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		egress.SetAudit(auditFile)
+		defer auditFile.Close()
+	}
+	defer func() {
+		if n := egress.Blocked(); n > 0 {
+			log.Printf("the egress policy blocked %d names from being sent to Investigate", n)
+		}
+	}()
+
+	outChan := getInfo(config, inChan)
 	mainWg := new(sync.WaitGroup)
 
+	report := domainstats.NewReport()
+	stopProgress := showProgress()
 	mainWg.Add(1)
-	go writeOut(outWriter, outChan, mainWg)
+	go writeOut(domainstats.MultiSink(sink, webhook), report, outChan, inFlight, mainWg)
 
 	mainWg.Wait()
+	stopProgress()
+	writeReport(report)
 }
 
-func writeOut(outWriter *csv.Writer, outChan <-chan []string, wg *sync.WaitGroup) {
-	numProcessed := 0
-	msgChan := make(chan string, 10)
-	go printStdOut(msgChan)
+func writeOut(sink domainstats.Sink, report *domainstats.Report,
+	outChan <-chan domainstats.Row, inFlight <-chan struct{}, wg *sync.WaitGroup) {
+	var reorderBuf *domainstats.ReorderBuffer
+	if opts.ordered {
+		reorderBuf = domainstats.NewReorderBuffer(1)
+	}
 
-	for respRow := range outChan {
-		numProcessed++
-		msgChan <- fmt.Sprintf("\r%d/%d: %s", numProcessed, numDomains, respRow[0])
-		if outWriter != nil {
-			outWriter.Write(respRow)
+	for row := range outChan {
+		rows := []domainstats.Row{row}
+		if reorderBuf != nil {
+			rows = reorderBuf.Push(row)
+		}
+
+		for _, r := range rows {
+			<-inFlight
+			report.Add(r)
+			progress.Done(r.Domain)
+
+			// domains whose queries failed only hold their place in the order
+			if r.Fields == nil {
+				continue
+			}
+
+			if sink != nil {
+				if err := sink.WriteRow(r); err != nil {
+					log.Printf("error writing row for %v: %v", r.Domain, err)
+				}
+				// when following, rows should show up as soon as they're ready
+				if f, ok := sink.(domainstats.Flusher); ok && opts.follow {
+					if err := f.Flush(); err != nil {
+						log.Printf("error writing row for %v: %v", r.Domain, err)
+					}
+				}
+			}
 		}
 	}
 
-	close(msgChan)
 	wg.Done()
 }
 
-func printStdOut(msgChan <-chan string) {
-	for msg := range msgChan {
-		fmt.Printf("\r%120s", " ")
-		fmt.Print(msg)
+// Prints the summary of the run, and writes it to the -report file, if one
+// was given.
+func writeReport(report *domainstats.Report) {
+	if err := report.WriteText(os.Stdout); err != nil {
+		log.Printf("error printing the summary: %v", err)
+	}
+	if opts.reportFile == "" {
+		return
 	}
-	fmt.Println()
-}
 
-// The goroutine which does the HTTP queries
-func query(qChan <-chan *domainstats.DomainQueryMessage) {
-	for m := range qChan {
-		m.RespChan <- m.Q.Query()
+	f, err := os.Create(opts.reportFile)
+	if err != nil {
+		log.Printf("error writing the report: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := report.WriteHTML(f); err != nil {
+		log.Printf("error writing the report: %v", err)
 	}
 }
 
-func process(inv *goinvestigate.Investigate, config *domainstats.Config,
-	domainChan <-chan string,
-	qChan chan<- *domainstats.DomainQueryMessage,
-	outChan chan<- []string,
-	wg *sync.WaitGroup) {
-
-domainLoop:
-	for domain := range domainChan {
-
-		// generate the list of queries to make for each domain
-		queries := config.DeriveMessages(inv, domain)
-
-		// send each query on the query channel for the query goroutines
-		// to receive
-		for _, q := range queries {
-			qChan <- q
-		}
+// Shows the progress of the run on stderr until the returned function is
+// called. On a terminal, a status line is redrawn in place; otherwise, it is
+// logged every so often, so that logs don't fill up with redraws.
+func showProgress() (stop func()) {
+	tty := isTerminal(os.Stderr)
+	interval := PROGRESS_LOG_INTERVAL
+	if tty {
+		interval = PROGRESS_REDRAW_INTERVAL
+	}
 
-		row := []string{domain}
-		// receive once for each query that was sent
-		for _, q := range queries {
-			qmResp := <-q.RespChan
-			if qmResp.Err != nil {
-				log.Printf("error during query for %v: %v\nskipping this domain",
-					domain, qmResp.Err)
-				continue domainLoop
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				if tty {
+					fmt.Fprintf(os.Stderr, "\r\x1b[K%s\n", progress.Line())
+				} else {
+					log.Print(progress.Line())
+				}
+				close(stopped)
+				return
 			}
-			subRow, err := config.ExtractCSVSubRow(qmResp.Resp)
-			if err != nil {
-				inv.Logf("error extracting CSV sub row: %v", err)
-				continue
+			if tty {
+				fmt.Fprintf(os.Stderr, "\r\x1b[K%s: %s", progress.Line(), progress.Last())
+			} else {
+				log.Print(progress.Line())
 			}
-			row = append(row, subRow...)
 		}
+	}()
 
-		outChan <- row
+	return func() {
+		close(done)
+		<-stopped
 	}
-	wg.Done()
 }
 
-func getInfo(config *domainstats.Config, inv *goinvestigate.Investigate, domainChan <-chan string) <-chan []string {
-	outChan := make(chan []string, 100)
-	qChan := make(chan *domainstats.DomainQueryMessage)
-	wg := new(sync.WaitGroup)
-
-	// launch the query goroutines
-	for i := 0; i < DEFAULT_MAX_GOROUTINES; i++ {
-		go query(qChan)
+// Enriches the domains read from the input, and returns their rows as
+// they're ready.
+func getInfo(config *domainstats.Config, inChan <-chan enrich.Input) <-chan domainstats.Row {
+	// when following, results are shared for as long as domains are
+	// deduplicated
+	ttl := time.Duration(0)
+	if opts.follow {
+		ttl = opts.dedupWindow
 	}
-
-	// launch the processor goroutines
-	for i := 0; i < DEFAULT_MAX_GOROUTINES; i++ {
-		wg.Add(1)
-		go process(inv, config, domainChan, qChan, outChan, wg)
+	enricher, err := enrich.New(config, enrich.Options{
+		Concurrency: DEFAULT_MAX_GOROUTINES,
+		CacheTTL:    ttl,
+		Verbose:     opts.verbose,
+		OnQuery:     progress.Queried,
+	})
+	if err != nil {
+		log.Fatalf("\nError loading skip lists: %v\n", err)
 	}
 
-	// launch a goroutine which closes the output channel when the processor
-	// goroutines are finished
+	outChan := make(chan domainstats.Row, 100)
 	go func() {
-		wg.Wait()
-		close(qChan)
+		for res := range enricher.Stream(context.Background(), inChan) {
+			if res.Err != nil {
+				log.Printf("error during query for %v: %v\nskipping this domain",
+					res.Domain, res.Err)
+			}
+			outChan <- res.Row()
+		}
 		close(outChan)
 	}()
 
 	return outChan
 }
 
-func readDomainsFrom(fName string) <-chan string {
+// Reads domains from the given file, one per line. A slot in inFlight is
+// taken for each domain before it is sent, so this blocks when too many
+// domains are waiting to be written out.
+func readDomainsFrom(fName string, inFlight chan<- struct{}) <-chan enrich.Input {
 	file, err := os.Open(fName)
 
 	if err != nil {
 		log.Fatalf("\nError opening domain list %s: %v\n", fName, err)
 	}
 
-	domainChan := make(chan string, 100)
+	domainChan := make(chan enrich.Input, 100)
 
 	scanner := bufio.NewScanner(file)
 
+	// count the domains separately, so that they can be queried meanwhile
+	go func() {
+		if n, err := countLines(fName); err == nil {
+			progress.SetTotal(n)
+		}
+	}()
+
 	go func() {
+		line := 0
 		for scanner.Scan() {
-			domainChan <- scanner.Text()
-			numDomains++
+			line++
+			inFlight <- struct{}{}
+			domainChan <- enrich.Input{Domain: scanner.Text(), Line: line}
+			progress.Read()
 		}
 		close(domainChan)
 		file.Close()
@@ -233,3 +410,159 @@ func readDomainsFrom(fName string) <-chan string {
 
 	return domainChan
 }
+
+// Returns the number of lines in the given file, the way readDomainsFrom
+// counts them.
+func countLines(fName string) (int, error) {
+	file, err := os.Open(fName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// Reads a resolver log, and then sends each name queried in it, in the
+// order they were first queried, along with how often they were queried.
+// Since each name is only sent once, names are numbered in that order
+// rather than by their lines.
+func readQueryLog(fName string, parser domainstats.QueryLogParser,
+	inFlight chan<- struct{}) <-chan enrich.Input {
+	file, err := os.Open(fName)
+	if err != nil {
+		log.Fatalf("\nError opening domain list %s: %v\n", fName, err)
+	}
+	defer file.Close()
+
+	queries := domainstats.NewQueryAggregator()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if q, ok := parser.ParseLine(scanner.Text()); ok {
+			queries.Add(q)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("\nError reading %s: %v\n", fName, err)
+	}
+	return sendQueries(fName, queries, inFlight)
+}
+
+// Reads the names looked up in a packet capture, and then sends them like
+// readQueryLog does.
+func readPcap(fName string, inFlight chan<- struct{}) <-chan enrich.Input {
+	file, err := os.Open(fName)
+	if err != nil {
+		log.Fatalf("\nError opening domain list %s: %v\n", fName, err)
+	}
+	defer file.Close()
+
+	r, err := domainstats.NewPcapReader(file)
+	if err != nil {
+		log.Fatalf("\nError reading %s: %v\n", fName, err)
+	}
+	queries := domainstats.NewQueryAggregator()
+	for {
+		q, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			log.Printf("warning: %s ends in the middle of a packet", fName)
+			break
+		}
+		if err != nil {
+			log.Fatalf("\nError reading %s: %v\n", fName, err)
+		}
+		queries.Add(q)
+	}
+	return sendQueries(fName, queries, inFlight)
+}
+
+// Sends each name in queries, in the order they were first seen, along with
+// their stats.
+func sendQueries(fName string, queries *domainstats.QueryAggregator,
+	inFlight chan<- struct{}) <-chan enrich.Input {
+	if len(queries.Names()) == 0 {
+		log.Printf("warning: no queries were found in %s; is -input-format %s right?",
+			fName, opts.inputFormat)
+	}
+
+	progress.SetTotal(len(queries.Names()))
+	domainChan := make(chan enrich.Input, 100)
+	go func() {
+		for i, name := range queries.Names() {
+			inFlight <- struct{}{}
+			domainChan <- enrich.Input{Domain: name, Line: i + 1, Queries: queries.Stats(name)}
+			progress.Read()
+		}
+		close(domainChan)
+	}()
+
+	return domainChan
+}
+
+// Reads domains from the given file as it grows, until domainstats is
+// interrupted. Blank lines are skipped, as are domains which were already
+// read within the dedup window. Since the file may be rotated, domains are
+// numbered in the order they're read rather than by their lines.
+//
+// With a resolver log, the names queried are read instead, along with how
+// often they've been queried since domainstats started.
+func followDomainsFrom(fName string, parser domainstats.QueryLogParser,
+	inFlight chan<- struct{}) <-chan enrich.Input {
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		// let the domains read so far be written out; a second signal
+		// stops domainstats right away
+		signal.Stop(sigs)
+		close(stop)
+	}()
+
+	lines, err := domainstats.FollowFile(fName, FOLLOW_POLL_INTERVAL, stop)
+	if err != nil {
+		log.Fatalf("\nError opening domain list %s: %v\n", fName, err)
+	}
+
+	domainChan := make(chan enrich.Input, 100)
+	recent := domainstats.NewRecentDomains(opts.dedupWindow)
+	queries := domainstats.NewQueryAggregator()
+
+	go func() {
+		line := 0
+		for text := range lines {
+			domain := strings.TrimSpace(text)
+			var stats *domainstats.QueryStats
+			if parser != nil {
+				q, ok := parser.ParseLine(text)
+				if !ok {
+					continue
+				}
+				// count the queries since the domain was last enriched, as
+				// long as it's within the dedup window
+				queries.Prune(opts.dedupWindow)
+				s := queries.Add(q)
+				domain, stats = q.Name, &s
+			}
+
+			if domain == "" || recent.Seen(domain) {
+				continue
+			}
+			line++
+			inFlight <- struct{}{}
+			domainChan <- enrich.Input{Domain: domain, Line: line, Queries: stats}
+			progress.Read()
+		}
+		close(domainChan)
+	}()
+
+	return domainChan
+}
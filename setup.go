@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	domainstats "github.com/dead10ck/domainstats/internal"
+)
+
+// Runs -setup with the given API key, and returns the exit status.
+func setup(apiKey string) int {
+	if !opts.force {
+		if _, err := os.Stat(domainstats.DefaultConfigPath); err == nil {
+			fmt.Fprintf(os.Stderr, "%s already exists; not overwriting it. "+
+				"Use -force to replace it (the old file is kept as a .bak file).\n",
+				domainstats.DefaultConfigPath)
+			return 1
+		}
+	}
+
+	if !opts.noVerify {
+		fmt.Print("Checking API key... ")
+		if err := domainstats.CheckAPIKey(apiKey); err != nil {
+			fmt.Println("failed")
+			fmt.Fprintf(os.Stderr, "error checking API key: %v\n"+
+				"Use -no-verify to skip this check.\n", err)
+			return 1
+		}
+		fmt.Println("ok")
+	}
+
+	// without a terminal to ask questions on, use the triage preset
+	var fields *domainstats.Config
+	if isTerminal(os.Stdin) {
+		var err error
+		fields, err = setupWizard(bufio.NewReader(os.Stdin), os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nerror reading answer: %v\n", err)
+			return 1
+		}
+	}
+
+	err := domainstats.GenerateDefaultConfig(apiKey, fields, opts.force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating default config file: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Config file generated in %s\n", domainstats.DefaultConfigPath)
+	return 0
+}
+
+// Reports whether f looks like a terminal: a character device, other than
+// the null device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if devNull, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, devNull) {
+		return false
+	}
+	return true
+}
+
+// Asks which fields the default config should query.
+func setupWizard(in *bufio.Reader, out io.Writer) (*domainstats.Config, error) {
+	presets := domainstats.PresetNames()
+
+	fmt.Fprintln(out, "\nWhich fields should be queried by default?")
+	for i, name := range presets {
+		fmt.Fprintf(out, "  %d) the %q preset\n", i+1, name)
+	}
+	custom := len(presets) + 1
+	fmt.Fprintf(out, "  %d) choose endpoints\n", custom)
+
+	// default to the lightest preset, rather than hammering every endpoint
+	def := 1
+	for i, name := range presets {
+		if name == "triage" {
+			def = i + 1
+		}
+	}
+
+	choice, err := askChoice(in, out, "Choice", def, custom)
+	if err != nil {
+		return nil, err
+	}
+	if choice != custom {
+		return domainstats.PresetConfig(presets[choice-1])
+	}
+
+	fields := new(domainstats.Config)
+	fmt.Fprintln(out, "\nEach endpoint is one more query per domain.")
+	for _, e := range domainstats.Endpoints() {
+		yes, err := askYesNo(in, out, fmt.Sprintf("Query %s (%s)?", e.Name, e.Description))
+		if err != nil {
+			return nil, err
+		}
+		if yes {
+			e.Enable(fields)
+		}
+	}
+	return fields, nil
+}
+
+// reads a line of input, without surrounding whitespace
+func readAnswer(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func askChoice(in *bufio.Reader, out io.Writer, prompt string, def, max int) (int, error) {
+	for {
+		fmt.Fprintf(out, "%s [%d]: ", prompt, def)
+		answer, err := readAnswer(in)
+		if err != nil {
+			return 0, err
+		}
+		if answer == "" {
+			return def, nil
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= max {
+			return n, nil
+		}
+		fmt.Fprintf(out, "Please enter a number from 1 to %d.\n", max)
+	}
+}
+
+func askYesNo(in *bufio.Reader, out io.Writer, prompt string) (bool, error) {
+	for {
+		fmt.Fprintf(out, "%s [y/N]: ", prompt)
+		answer, err := readAnswer(in)
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return true, nil
+		case "", "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(out, "Please answer y or n.")
+	}
+}